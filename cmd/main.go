@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -10,7 +11,12 @@ import (
 	"io"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethclient"
 
 	"go-azimuth/pkg/crypto"
@@ -24,13 +30,53 @@ var ROLLER_URL = ""
 
 var DB_PATH = ""
 
+var WATCH_INTERVAL = 15 * time.Second
+
+var LOGS_FILE = ""
+
+// Where to cache responses from the Ethereum node(s); empty means don't
+var CACHE_DIR = ""
+
+// Only use the cache, with no Ethereum node
+var OFFLINE = false
+
+// Which network a new DB is for: "mainnet", or the path of a network profile (see `pkg_db.LoadNetwork`)
+var NETWORK = ""
+
+var RETRY_POLICY = scraper.DefaultRetryPolicy
+
+var QUORUM = 1
+
+var MAX_REQUESTS_PER_SECOND = 10.0
+
+var SCRAPER_OPTIONS scraper.Options
+
+// Counts the RPC credits used by calls to the Ethereum node(s)
+var CREDIT_METER = &scraper.CreditMeter{}
+
+// Open the DB, or create it (for NETWORK) if it doesn't exist yet
 func get_db(path string) pkg_db.DB {
-	db, err := pkg_db.DBCreate(path)
+	network := pkg_db.MAINNET
+	if NETWORK != "" && NETWORK != pkg_db.MAINNET.Name {
+		var err error
+		network, err = pkg_db.LoadNetwork(NETWORK)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	db, err := pkg_db.DBCreateForNetwork(path, network)
 	if errors.Is(err, pkg_db.ErrTargetExists) {
 		db, err = pkg_db.DBConnect(path)
 		if err != nil {
 			panic(err)
 		}
+		if existing := db.GetNetwork(); NETWORK != "" && existing.Name != network.Name {
+			fmt.Printf("%s is a database for %s, not %s\n", path, existing.Name, network.Name)
+			os.Exit(1)
+		}
+	} else if err != nil {
+		panic(err)
 	}
 	return db
 }
@@ -68,27 +114,126 @@ func main() {
 
 	flag.StringVar(&DB_PATH, "db", "azimuth.db", "database file")
 	flag.StringVar(&ETHEREUM_RPC_URL, "eth-url", ETHEREUM_RPC_URL,
-		"Ethereum node RPC URL (defaults to environment variable ETHEREUM_RPC_URL)")
+		"Ethereum node RPC URL, or several comma-separated ones to fail over between (defaults to environment "+
+			"variable ETHEREUM_RPC_URL)")
+	flag.IntVar(&SCRAPER_OPTIONS.Workers, "workers", 4, "how many ranges of blocks to fetch logs from at once")
+	flag.Float64Var(&MAX_REQUESTS_PER_SECOND, "max-rps", MAX_REQUESTS_PER_SECOND,
+		"the most calls per second to make to each Ethereum RPC URL (0 means no limit)")
+	flag.Uint64Var(&CREDIT_METER.Budget, "budget", 0,
+		"the most RPC credits to use in this run; it stops (resumably) before going over (0 means no limit)")
+	flag.Uint64Var(&CREDIT_METER.DailyBudget, "daily-budget", 0,
+		"the most RPC credits to use per day (UTC), counting every run on this database (0 means no limit)")
+	credit_costs := flag.String("credit-costs", "",
+		"JSON file of how many credits each RPC method costs, e.g. {\"eth_getLogs\": 255} (defaults to Infura's)")
+	flag.IntVar(&QUORUM, "quorum", QUORUM,
+		"fetch everything from this many of the Ethereum RPC URLs, and refuse to save it unless they all agree")
+	flag.DurationVar(&WATCH_INTERVAL, "interval", WATCH_INTERVAL, "how long `watch` waits between polls for new blocks")
+	flag.IntVar(&RETRY_POLICY.MaxRetries, "max-retries", RETRY_POLICY.MaxRetries,
+		"how many times to retry a failed Ethereum node call before giving up")
+	flag.DurationVar(&RETRY_POLICY.InitialDelay, "retry-delay", RETRY_POLICY.InitialDelay,
+		"how long to wait before retrying a failed Ethereum node call (doubles after each retry)")
+	finality := flag.String("finality", "finalized",
+		"when events are final enough to play: \"finalized\", \"safe\", \"latest\", or a number of confirmations")
+	call_data_from := flag.String("call-data-from", "txs",
+		"how to fetch Naive call data: \"txs\" (look up each transaction) or \"blocks\" (fetch whole blocks)")
+	flag.BoolVar(&SCRAPER_OPTIONS.FetchVotes, "fetch-votes", false,
+		"also fetch galaxies' votes on polls (needs an Ethereum node with `trace_filter`)")
+	flag.BoolVar(&SCRAPER_OPTIONS.FetchLockups, "fetch-lockups", false,
+		"also fetch who locked-up stars belong to (needs an Ethereum node with `trace_filter`)")
+	flag.BoolVar(&SCRAPER_OPTIONS.FetchSenders, "fetch-senders", false,
+		"also fetch who sent each event's transaction, and which of the point's roles they had (one call per transaction)")
+	flag.StringVar(&LOGS_FILE, "logs-file", "",
+		"read logs from an exported newline-delimited JSON file instead of an Ethereum node (for `catch_up_logs`)")
+	flag.StringVar(&CACHE_DIR, "cache-dir", "",
+		"save the Ethereum node's responses in this directory, and reuse them instead of calling it again")
+	flag.BoolVar(&OFFLINE, "offline", false, "don't use an Ethereum node at all, only what's in the --cache-dir")
+	flag.StringVar(&NETWORK, "network", "",
+		"which network a new database is for: \"mainnet\" (default), or a JSON file with a network profile")
 
 	flag.Parse()
 	args := flag.Args()
 
+	var err error
+	SCRAPER_OPTIONS.CallDataStrategy, err = scraper.ParseCallDataStrategy(*call_data_from)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	SCRAPER_OPTIONS.Finality, err = scraper.ParseFinality(*finality)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if *credit_costs != "" {
+		CREDIT_METER.Costs, err = scraper.LoadCreditCosts(*credit_costs)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	SCRAPER_OPTIONS.Progress = pkg_db.PrintProgress{}
+	SCRAPER_OPTIONS.Meter = CREDIT_METER
+
 	if len(args) == 0 {
 		fmt.Printf("subcommand needed\n")
 		os.Exit(1)
 	}
 
+	// Ctrl-C stops long-running commands after whatever they're saving right now, so they can be
+	// resumed later.  A second Ctrl-C kills it right away, like usual.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop() // Back to the default signal handling
+	}()
+
 	switch args[0] {
 	case "catch_up_logs":
-		catch_up_logs()
+		catch_up_logs(ctx)
 	case "play_logs":
-		play_logs()
+		play_logs(ctx)
+	case "watch":
+		watch(ctx)
+	case "verify_logs":
+		verify_logs(ctx)
+	case "repair":
+		repair(ctx)
+	case "gaps":
+		gaps(ctx, len(args) > 1 && args[1] == "fill")
 	case "query":
 		query(args[1])
 	case "show_logs":
 		show_logs(args[1])
+	case "approvals":
+		if len(args) < 2 {
+			panic("Gotta provide a ship or an Ethereum address")
+		}
+		approvals(args[1])
+	case "invites":
+		if len(args) < 2 {
+			panic("Gotta provide a ship")
+		}
+		invites(args[1])
+	case "polls":
+		polls()
+	case "poll":
+		if len(args) < 2 {
+			panic("Gotta provide a proposal (an Ecliptic address or document hash)")
+		}
+		if len(args) < 3 {
+			poll(args[1], "")
+		} else {
+			poll(args[1], args[2])
+		}
 	case "diff_roller":
 		diff_roller()
+	case "pending":
+		if len(args) < 2 {
+			panic("Gotta provide a ship")
+		}
+		pending(args[1])
 	case "checkpoint":
 		if len(args) < 2 {
 			panic("Gotta provide a path to checkpoint into")
@@ -115,27 +260,413 @@ func main() {
 	}
 }
 
-// Download all Azimuth and Naive data from Ethereum, in chunks
-func catch_up_logs() {
+// Connect to the Ethereum node(s), and make sure they're for the same chain as the DB.  If there are
+// several, it fails over between them, or checks them against each other if QUORUM is set.  Each
+// one gets at most MAX_REQUESTS_PER_SECOND calls per second, and failed calls get retried according
+// to RETRY_POLICY.  Every call (including retries, and the chain ID check) is charged to
+// CREDIT_METER.
+func connect_eth_client(db pkg_db.DB) (scraper.LogSource, func()) {
+	if OFFLINE {
+		if CACHE_DIR == "" {
+			fmt.Printf("`--offline` needs a `--cache-dir` to read from\n")
+			os.Exit(1)
+		}
+		// Only what was cached for the DB's chain
+		source, err := scraper.NewOfflineCachingSource(CACHE_DIR, db.GetNetwork().ChainID)
+		if err != nil {
+			log.Fatalf("Failed to open the cache: %v", err)
+		}
+		return source, func() {}
+	}
+
 	require_eth_rpc_url()
+	urls := strings.Split(ETHEREUM_RPC_URL, ",")
+	if QUORUM < 1 || QUORUM > len(urls) {
+		fmt.Printf("Quorum must be between 1 and the number of Ethereum RPC urls (%d)\n", len(urls))
+		os.Exit(1)
+	}
+
+	sources := []scraper.LogSource{}
+	clients := []*ethclient.Client{}
+	stop_rate_limiters := []func(){}
+	for i, url := range urls {
+		client, err := ethclient.Dial(strings.TrimSpace(url))
+		if err != nil {
+			// Don't print the url; it probably has an API key in it
+			log.Fatalf("Failed to connect to Ethereum endpoint #%d: %v", i+1, err)
+		}
+		clients = append(clients, client)
+		var source scraper.LogSource = scraper.MeteredSource{
+			LogSource: scraper.EthClientSource{Client: client},
+			Meter:     CREDIT_METER,
+		}
+		if MAX_REQUESTS_PER_SECOND > 0 {
+			rate_limited_source := scraper.NewRateLimitedSource(source, MAX_REQUESTS_PER_SECOND)
+			stop_rate_limiters = append(stop_rate_limiters, rate_limited_source.Stop)
+			source = rate_limited_source
+		}
+		chain_id, err := source.ChainID(context.Background())
+		if err != nil {
+			log.Fatalf("Failed to get the chain ID of Ethereum endpoint #%d: %v", i+1, err)
+		}
+		if network := db.GetNetwork(); chain_id != network.ChainID {
+			fmt.Printf("Ethereum endpoint #%d is for chain ID %d, but the database is for %s (chain ID %d)\n", i+1,
+				chain_id, network.Name, network.ChainID)
+			os.Exit(1)
+		}
+		sources = append(sources, source)
+	}
+	close_clients := func() {
+		for _, c := range clients {
+			c.Close()
+		}
+		for _, stop := range stop_rate_limiters {
+			stop()
+		}
+	}
+
+	var source scraper.LogSource = &scraper.FailoverSource{Sources: sources, Progress: pkg_db.PrintProgress{}}
+	if QUORUM > 1 {
+		source = scraper.QuorumSource{Sources: sources, Quorum: QUORUM, Progress: pkg_db.PrintProgress{}}
+	}
+	source = scraper.RetryingSource{LogSource: source, Policy: RETRY_POLICY, Progress: pkg_db.PrintProgress{}}
+	if CACHE_DIR != "" {
+		// Outside everything else, so cached responses don't count against the rate limit or budget
+		caching_source, err := scraper.NewCachingSource(source, CACHE_DIR, db.GetNetwork().ChainID)
+		if err != nil {
+			log.Fatalf("Failed to open the cache: %v", err)
+		}
+		source = caching_source
+	}
+	return source, close_clients
+}
+
+// Nothing is saved from a range of blocks until it's been completely fetched, so a failure never
+// leaves the DB in a bad state
+func exit_on_fetch_error(db pkg_db.DB, err error) {
+	CREDIT_METER.Save(db)
+	print_credit_summary()
+	if errors.Is(err, context.Canceled) {
+		fmt.Printf("Interrupted.  Everything fetched so far has been saved; run `catch_up_logs` again to pick up " +
+			"where it left off.\n")
+		os.Exit(1)
+	}
+	fmt.Printf("Failed to fetch logs: %v\n\n", err)
+	if errors.Is(err, scraper.ErrBudgetExhausted) {
+		fmt.Printf("It stopped before going over the credit budget (`--budget` / `--daily-budget`).\n")
+	}
+	if errors.Is(err, scraper.ErrNotCached) {
+		fmt.Printf("Running with `--offline`, so only what's already in the cache can be fetched.\n")
+	}
+	if errors.Is(err, scraper.ErrSourcesDisagree) {
+		fmt.Printf("The Ethereum endpoints returned different data, so at least one of them is wrong.  Nothing " +
+			"they disagreed on has been saved.\n")
+	}
+	fmt.Printf("Everything fetched before the error has been saved.  It's safe to run `catch_up_logs` again to " +
+		"pick up where it left off.\n")
+	os.Exit(1)
+}
+
+// Print how many RPC credits this run used, if it used any
+func print_credit_summary() {
+	if CREDIT_METER.Used() == 0 && CREDIT_METER.Budget == 0 && CREDIT_METER.DailyBudget == 0 {
+		return
+	}
+	fmt.Printf("\nRPC credits used:\n%s", CREDIT_METER.Summary())
+}
+
+// Download all Azimuth, Naive, Polls, Claims and DelegatedSending data from Ethereum (or from a logs file), in chunks
+func catch_up_logs(ctx context.Context) {
+	db := get_db(DB_PATH)
+	var source scraper.LogSource
+	if LOGS_FILE != "" {
+		file_source, err := scraper.NewFileLogSource(LOGS_FILE)
+		if err != nil {
+			log.Fatalf("Failed to load logs file: %v", err)
+		}
+		source = file_source
+	} else {
+		client, close_client := connect_eth_client(db)
+		defer close_client()
+		source = client
+	}
+
+	CREDIT_METER.Load(db)
+	if err := scraper.CatchUpAzimuthLogs(ctx, source, db, SCRAPER_OPTIONS); err != nil {
+		exit_on_fetch_error(db, err)
+	}
+	if err := scraper.CatchUpEclipticLogs(ctx, source, db, SCRAPER_OPTIONS); err != nil {
+		exit_on_fetch_error(db, err)
+	}
+	if err := scraper.CatchUpNaiveLogs(ctx, source, db, SCRAPER_OPTIONS); err != nil {
+		exit_on_fetch_error(db, err)
+	}
+	if err := scraper.CatchUpPollsLogs(ctx, source, db, SCRAPER_OPTIONS); err != nil {
+		exit_on_fetch_error(db, err)
+	}
+	if err := scraper.CatchUpClaimsLogs(ctx, source, db, SCRAPER_OPTIONS); err != nil {
+		exit_on_fetch_error(db, err)
+	}
+	if err := scraper.CatchUpDelegatedSendingLogs(ctx, source, db, SCRAPER_OPTIONS); err != nil {
+		exit_on_fetch_error(db, err)
+	}
+	if SCRAPER_OPTIONS.FetchLockups {
+		if err := scraper.CatchUpStarReleaseCalls(ctx, source, db, SCRAPER_OPTIONS); err != nil {
+			exit_on_fetch_error(db, err)
+		}
+	}
+	if err := scraper.BackfillBlockTimestamps(ctx, source, db, SCRAPER_OPTIONS); err != nil {
+		exit_on_fetch_error(db, err)
+	}
+	if SCRAPER_OPTIONS.FetchSenders {
+		if err := scraper.BackfillTransactionSenders(ctx, source, db, SCRAPER_OPTIONS); err != nil {
+			exit_on_fetch_error(db, err)
+		}
+	}
+	if err := scraper.UpdateFinalizedBlock(ctx, source, db, SCRAPER_OPTIONS); err != nil {
+		exit_on_fetch_error(db, err)
+	}
+	CREDIT_METER.Save(db)
+	print_credit_summary()
+}
 
+// Check the saved events against the receipts roots of their blocks
+func verify_logs(ctx context.Context) {
 	db := get_db(DB_PATH)
-	client, err := ethclient.Dial(ETHEREUM_RPC_URL)
+	client, close_client := connect_eth_client(db)
+	defer close_client()
+
+	CREDIT_METER.Load(db)
+	failures, err := scraper.VerifyEvents(ctx, client, db, SCRAPER_OPTIONS)
+	CREDIT_METER.Save(db)
+	print_credit_summary()
+	for _, f := range failures {
+		fmt.Printf("Event #%d (block %d, log %d, %s): %s\n", f.Event.ID, f.Event.BlockNumber, f.Event.LogIndex,
+			f.Event.TxHash.Hex(), f.Reason)
+	}
 	if err != nil {
-		log.Fatalf("Failed to connect to the Ethereum client: %v", err)
+		if errors.Is(err, context.Canceled) {
+			fmt.Printf("Interrupted.  Everything checked so far is marked; run `verify_logs` again to pick up where " +
+				"it left off.\n")
+		} else {
+			fmt.Printf("Failed to verify logs: %v\n", err)
+		}
+		os.Exit(1)
 	}
-	defer client.Close()
 
-	scraper.CatchUpAzimuthLogs(client, db)
-	scraper.CatchUpNaiveLogs(client, db)
+	verified, total := db.CountVerifiedEvents()
+	fmt.Printf("%d of %d events verified\n", verified, total)
+	fmt.Printf("(Naive Batch events' call data isn't covered; only the logs themselves are verified.)\n")
+	if len(failures) != 0 {
+		fmt.Printf("%d events don't match the chain!\n", len(failures))
+		os.Exit(1)
+	}
 }
 
-func play_logs() {
+func play_logs(ctx context.Context) {
 	db := get_db(DB_PATH)
 	fmt.Println("Playing azimuth logs")
-	db.PlayAzimuthLogs()
-	fmt.Println("Playing naive logs")
-	db.PlayNaiveLogs()
+	err := db.PlayAzimuthLogs(ctx, pkg_db.PrintProgress{})
+	if err == nil {
+		fmt.Println("Playing naive logs")
+		err = db.PlayNaiveLogs(ctx, pkg_db.PrintProgress{})
+	}
+	if errors.Is(err, context.Canceled) {
+		fmt.Println("Interrupted.  Everything played so far has been saved; run `play_logs` again to pick up where it " +
+			"left off.")
+		os.Exit(1)
+	}
+	if errors.Is(err, pkg_db.ErrMissingCallData) {
+		fmt.Printf("Played everything up to a Naive Batch event with no call data: %v.  Nothing after it has been "+
+			"played, so the database is consistent as of that point.  Run `repair` to fetch it, then `play_logs` again "+
+			"to carry on.\n", err)
+		os.Exit(1)
+	}
+}
+
+// Fetch the call data of Batch events that don't have it (e.g., if fetching them got interrupted in
+// an old version, or they were sent through another contract and the node couldn't trace them).
+//
+// Until then, `play_logs` plays everything before the first of them.  That's safe: logs are played
+// in order, so nothing after a missing batch gets played, and everything before it comes out the
+// same as if it had been there.
+func repair(ctx context.Context) {
+	db := get_db(DB_PATH)
+	client, close_client := connect_eth_client(db)
+	defer close_client()
+
+	CREDIT_METER.Load(db)
+	repaired, err := scraper.RepairNaiveCallData(ctx, client, db, SCRAPER_OPTIONS)
+	CREDIT_METER.Save(db)
+	print_credit_summary()
+	fmt.Printf("Fetched the call data of %d Batch events\n", len(repaired))
+	num_played := 0
+	for _, e := range repaired {
+		if e.IsProcessed {
+			num_played++
+		}
+	}
+	if num_played != 0 {
+		fmt.Printf("%d of them were already played as empty batches, so the points they touched are wrong.  Rebuild "+
+			"the database from scratch to fix them.\n", num_played)
+	}
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			fmt.Printf("Interrupted.  Everything fetched so far is saved; run `repair` again to pick up where it " +
+				"left off.\n")
+		} else {
+			fmt.Printf("Failed to repair the logs: %v\n", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// List the ranges of blocks inside what's been fetched whose logs never were (e.g., if a range got
+// skipped), and fetch them if `fill` is set
+func gaps(ctx context.Context, fill bool) {
+	db := get_db(DB_PATH)
+	newest_played_block := db.GetNewestPlayedEventBlockNum()
+	num_gaps := 0
+	is_any_before_played := false
+	for _, contract := range db.GetLogContracts() {
+		for _, gap := range db.GetGaps(contract) {
+			num_gaps++
+			fmt.Printf("%s (%s): blocks %d - %d", contract.Name, contract.Address.Hex(), gap.FromBlock, gap.ToBlock)
+			if newest_played_block != 0 && gap.FromBlock <= newest_played_block {
+				is_any_before_played = true
+				fmt.Printf(" (before events that were already played)")
+			}
+			fmt.Println()
+		}
+	}
+	if num_gaps == 0 {
+		fmt.Println("No gaps; every contract's logs have been fetched from its start block onward")
+		return
+	}
+	if !fill {
+		fmt.Printf("%d gaps.  Run `gaps fill` to fetch them.\n", num_gaps)
+		return
+	}
+
+	client, close_client := connect_eth_client(db)
+	defer close_client()
+	CREDIT_METER.Load(db)
+	if err := scraper.FillGaps(ctx, client, db, SCRAPER_OPTIONS); err != nil {
+		CREDIT_METER.Save(db)
+		print_credit_summary()
+		if errors.Is(err, context.Canceled) {
+			fmt.Printf("Interrupted.  Every range fetched so far is saved; run `gaps fill` again to pick up where it " +
+				"left off.\n")
+		} else {
+			fmt.Printf("Failed to fill the gaps: %v\n", err)
+		}
+		os.Exit(1)
+	}
+	CREDIT_METER.Save(db)
+	print_credit_summary()
+	fmt.Printf("Filled %d gaps.  Run `catch_up_logs` to fetch any new Ecliptics they turned up.\n", num_gaps)
+	if is_any_before_played {
+		fmt.Printf("Some of them were before events that were already played, so any events found in them will " +
+			"get played out of order.  Rebuild the database from scratch to be sure the points are right.\n")
+	}
+}
+
+// Follow the chain head: poll for new blocks, fetch their Azimuth and Naive logs, and play them
+// right away.  Runs until interrupted.
+func watch(ctx context.Context) {
+	db := get_db(DB_PATH)
+	client, close_client := connect_eth_client(db)
+	defer close_client()
+
+	CREDIT_METER.Load(db)
+
+	for {
+		err := sync_to_head(ctx, client, db)
+		CREDIT_METER.Save(db)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				break
+			}
+			if errors.Is(err, pkg_db.ErrReorgTooDeep) {
+				log.Fatalf("Failed to roll back a chain reorg: %v\nThe database has to be rebuilt.", err)
+			}
+			// Anything fetched before the error is saved; the next poll picks up from there.  (If it's
+			// over the daily budget, that's tomorrow.)
+			fmt.Printf("Failed to sync (retrying in %s): %v\n", WATCH_INTERVAL, err)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(WATCH_INTERVAL):
+			continue
+		}
+		break
+	}
+	fmt.Println("Interrupted.")
+	print_credit_summary()
+}
+
+// One poll of `watch`
+func sync_to_head(ctx context.Context, client scraper.LogSource, db pkg_db.DB) error {
+	latest_block, err := client.BlockNumber(ctx)
+	if err != nil {
+		return fmt.Errorf("getting latest block number: %w", err)
+	}
+
+	// Undo anything that got orphaned since last time
+	if err := scraper.HandleReorgs(ctx, client, db, latest_block, SCRAPER_OPTIONS); err != nil {
+		return err
+	}
+
+	// Fetch both contracts up to the same block, so that L1 and L2 logs can be played in order
+	// (see WTF(naive-azimuth-interlacing))
+	if latest_block > db.GetContractByName("Naive").LatestBlockNumFetched {
+		if err := scraper.CatchUpAzimuthLogsUntil(ctx, client, db, latest_block, SCRAPER_OPTIONS); err != nil {
+			return err
+		}
+		if err := scraper.CatchUpEclipticLogsUntil(ctx, client, db, latest_block, SCRAPER_OPTIONS); err != nil {
+			return err
+		}
+		if err := scraper.CatchUpNaiveLogsUntil(ctx, client, db, latest_block, SCRAPER_OPTIONS); err != nil {
+			return err
+		}
+		if err := scraper.CatchUpPollsLogsUntil(ctx, client, db, latest_block, SCRAPER_OPTIONS); err != nil {
+			return err
+		}
+		if err := scraper.CatchUpClaimsLogsUntil(ctx, client, db, latest_block, SCRAPER_OPTIONS); err != nil {
+			return err
+		}
+		if err := scraper.CatchUpDelegatedSendingLogsUntil(ctx, client, db, latest_block, SCRAPER_OPTIONS); err != nil {
+			return err
+		}
+		if SCRAPER_OPTIONS.FetchLockups {
+			if err := scraper.CatchUpStarReleaseCallsUntil(ctx, client, db, latest_block, SCRAPER_OPTIONS); err != nil {
+				return err
+			}
+		}
+		if err := scraper.SaveBlockHash(ctx, client, db, latest_block); err != nil {
+			return err
+		}
+	}
+
+	// Play whatever has become final
+	prev_finalized_block := db.GetFinalizedBlock()
+	if err := scraper.UpdateFinalizedBlock(ctx, client, db, SCRAPER_OPTIONS); err != nil {
+		return err
+	}
+	if db.GetFinalizedBlock() > prev_finalized_block {
+		if err := db.PlayAzimuthLogs(ctx, nil); err != nil {
+			return err
+		}
+		if err := db.PlayNaiveLogs(ctx, nil); errors.Is(err, pkg_db.ErrMissingCallData) {
+			// Trying again won't help; keep syncing, and play the rest once it's repaired
+			fmt.Printf("Can't play past a Naive Batch event with no call data: %v.  Run `repair` to fetch it.\n", err)
+		} else if err != nil {
+			return err
+		}
+		db.PruneSnapshots()
+		fmt.Printf("Synced up to block %d; final up to block %d\n", latest_block, db.GetFinalizedBlock())
+	}
+	return nil
 }
 
 func diff_roller() {
@@ -147,6 +678,50 @@ func diff_roller() {
 	}
 }
 
+// What a point will look like once the roller's pending L2 transactions land
+func pending(urbit_id string) {
+	require_roller_url()
+	point, is_ok := phonemes.PhonemeToInt(urbit_id)
+	if !is_ok {
+		fmt.Printf("Not a valid ship name: %q\n", urbit_id)
+		os.Exit(1)
+	}
+
+	txs, skipped, err := GetRollerPendingNaiveTxs(ROLLER_URL)
+	if err != nil {
+		fmt.Printf("Failed to get pending transactions from the roller: %v\n", err)
+		os.Exit(1)
+	}
+	for _, err := range skipped {
+		fmt.Fprintf(os.Stderr, "Skipping a pending transaction that couldn't be parsed: %v\n", err)
+	}
+	db := get_db(DB_PATH)
+	result, is_found, diffs := db.GetPendingPoint(pkg_db.AzimuthNumber(point), txs)
+	if !is_found {
+		fmt.Printf("Point not found, even with pending transactions!\n")
+		os.Exit(2)
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(string(data))
+
+	// The rest goes to stderr, so the JSON can still be piped to `jq`
+	fmt.Fprintf(os.Stderr, "\n%d pending transactions in the roller's queue", len(txs))
+	if len(skipped) != 0 {
+		fmt.Fprintf(os.Stderr, " (and %d that couldn't be parsed)", len(skipped))
+	}
+	if len(diffs) == 0 {
+		fmt.Fprintf(os.Stderr, "; none of them change this point\n")
+		return
+	}
+	fmt.Fprintf(os.Stderr, "; they change this point:\n")
+	for _, d := range diffs {
+		fmt.Fprintf(os.Stderr, "  - %s %x\n", db.GetDiffTypeName(d.Operation), d.Data)
+	}
+}
+
 func query(urbit_id string) {
 	point, is_ok := phonemes.PhonemeToInt(urbit_id)
 	if !is_ok {
@@ -183,11 +758,168 @@ func show_logs(urbit_id string) {
 	}
 
 	// Header
-	fmt.Printf("%-7s  %-7s  %-64s  %-3s  %-24s  %s\n", "ID", "Layer", "Tx Hash", "Idx", "Operation", "Data")
-	fmt.Printf("-------  -------  ----------------------------------------------------------------  ---  ------------------------  ----\n")
+	// Sender and role are blank unless they were fetched (`--fetch-senders`); L2 ones are always blank
+	fmt.Printf("%-7s  %-19s  %-7s  %-64s  %-3s  %-24s  %-42s  %-26s  %s\n",
+		"ID", "Date (UTC)", "Layer", "Tx Hash", "Idx", "Operation", "Sender", "Sent as", "Data")
+	fmt.Printf("-------  -------------------  -------  ----------------------------------------------------------------  ---  " +
+		"------------------------  ------------------------------------------  --------------------------  ----\n")
 	for _, h := range result {
-		fmt.Printf("%-7d  %-7s  %-64s  %-3d  %-24s  %s\n",
-			h.ID, h.ContractName, h.TxHash, h.IntraLogIndex, h.OperationName, h.HexData)
+		fmt.Printf("%-7d  %-19s  %-7s  %-64s  %-3d  %-24s  %-42s  %-26s  %s\n",
+			h.ID, format_timestamp(h.Timestamp), h.ContractName, h.TxHash, h.IntraLogIndex, h.OperationName, h.Sender,
+			h.SenderRole, h.HexData)
+	}
+
+	// Events that aren't final yet
+	pending := db.GetPendingEventsForPoint(pkg_db.AzimuthNumber(point))
+	if len(pending) != 0 {
+		fmt.Printf("\nPending (not final yet):\n")
+		fmt.Printf("%-9s  %-19s  %-64s  %-3s  %s\n", "Block", "Date (UTC)", "Tx Hash", "Idx", "Event")
+		fmt.Printf("---------  -------------------  ----------------------------------------------------------------  ---  -----\n")
+		for _, e := range pending {
+			fmt.Printf("%-9d  %-19s  %-64x  %-3d  %s\n",
+				e.BlockNumber, format_timestamp(db.GetBlockTimestamp(e.BlockNumber)), e.TxHash, e.LogIndex, e.Name)
+		}
+	}
+}
+
+// Format a block timestamp (unix seconds) as a UTC date and time, or blank if it's unknown
+func format_timestamp(timestamp uint64) string {
+	if timestamp == 0 {
+		return ""
+	}
+	return time.Unix(int64(timestamp), 0).UTC().Format(time.DateTime)
+}
+
+// Show who can transfer a point, or who an address has approved as an operator (and vice versa)
+func approvals(urbit_id_or_address string) {
+	db := get_db(DB_PATH)
+	if common.IsHexAddress(urbit_id_or_address) {
+		address := common.HexToAddress(urbit_id_or_address)
+		fmt.Printf("Operators approved by %s:\n", address)
+		for _, a := range db.GetOperatorsOf(address) {
+			fmt.Printf("  %s\n", a)
+		}
+		fmt.Printf("Addresses that approved %s as an operator:\n", address)
+		for _, a := range db.GetOwnersApprovingOperator(address) {
+			fmt.Printf("  %s\n", a)
+		}
+		return
+	}
+
+	point, is_ok := phonemes.PhonemeToInt(urbit_id_or_address)
+	if !is_ok {
+		fmt.Printf("Not a valid ship name or Ethereum address: %q\n", urbit_id_or_address)
+		os.Exit(1)
+	}
+	result, is_found := db.GetApprovalsForPoint(pkg_db.AzimuthNumber(point))
+	if !is_found {
+		fmt.Printf("Point not found!\n")
+		os.Exit(2)
+	}
+	fmt.Printf("Owner:           %s\n", result.Owner)
+	fmt.Printf("Transfer proxy:  %s\n", result.TransferProxy)
+	fmt.Printf("Owner's operators:\n")
+	for _, a := range result.Operators {
+		fmt.Printf("  %s\n", a)
+	}
+}
+
+// Show a point's invite pool, and if it's a star, which of its planets have been sent with invites
+func invites(urbit_id string) {
+	point, is_ok := phonemes.PhonemeToInt(urbit_id)
+	if !is_ok {
+		fmt.Printf("Not a valid ship name: %q\n", urbit_id)
+		os.Exit(1)
+	}
+	db := get_db(DB_PATH)
+	azimuth_number := pkg_db.AzimuthNumber(point)
+
+	if pool, is_found := db.GetInvitePool(azimuth_number); is_found {
+		fmt.Printf("Invite pool (from %s): %d of %d invites left\n",
+			phonemes.IntToPhoneme(uint64(pool.Prefix)), pool.Remaining, pool.Size)
+	} else {
+		fmt.Printf("No invite pool\n")
+	}
+
+	if azimuth_number.Rank() != pkg_db.STAR {
+		return
+	}
+	sent := db.GetInvitesSentFromStar(azimuth_number)
+	fmt.Printf("\nPlanets sent with invites: %d\n", len(sent))
+	fmt.Printf("%-9s  %-14s  %-14s  %-42s  %s\n", "Block", "Planet", "Sent by", "To", "Pool")
+	fmt.Printf("---------  --------------  --------------  ------------------------------------------  ----\n")
+	for _, s := range sent {
+		fmt.Printf("%-9d  %-14s  %-14s  %-42s  %s\n", s.BlockNumber, phonemes.IntToPhoneme(uint64(s.Point)),
+			phonemes.IntToPhoneme(uint64(s.By)), s.To, phonemes.IntToPhoneme(uint64(s.Pool)))
+	}
+}
+
+// List all the polls, and which ones are still open
+func polls() {
+	db := get_db(DB_PATH)
+	now := uint64(time.Now().Unix())
+	fmt.Printf("%-8s  %-66s  %-9s  %-10s  %-9s  %-3s  %-3s\n", "Kind", "Proposal", "Started", "Date", "Status", "Yes", "No")
+	fmt.Printf("--------  ------------------------------------------------------------------  ---------  ----------  ---------  ---  ---\n")
+	for _, p := range db.GetPolls() {
+		status := "closed"
+		if p.HasMajority {
+			status = "majority"
+		} else if p.IsOpen(now) {
+			status = "open"
+		}
+		yes, no := 0, 0
+		for _, v := range db.GetPollVotes(p) {
+			if v.Vote {
+				yes++
+			} else {
+				no++
+			}
+		}
+		fmt.Printf("%-8s  0x%-64x  %-9d  %-10s  %-9s  %-3d  %-3d\n",
+			p.Kind, p.Proposal, p.StartBlockNum, time.Unix(int64(p.StartTime), 0).UTC().Format(time.DateOnly), status, yes, no)
+	}
+	if !SCRAPER_OPTIONS.FetchVotes && db.GetPollVotesFetched() == 0 {
+		fmt.Printf("\n(Votes haven't been fetched; see `--fetch-votes`)\n")
+	}
+}
+
+// Show the votes on a proposal (in its most recent poll), or how one galaxy voted on it
+func poll(proposal_hex string, galaxy_name string) {
+	proposal, err := hex.DecodeString(strings.TrimPrefix(proposal_hex, "0x"))
+	if err != nil || (len(proposal) != 20 && len(proposal) != 32) {
+		fmt.Printf("Not a valid proposal (should be an Ecliptic address or a document hash): %q\n", proposal_hex)
+		os.Exit(1)
+	}
+	db := get_db(DB_PATH)
+	p, is_found := db.GetLatestPoll(proposal)
+	if !is_found {
+		fmt.Printf("Poll not found!\n")
+		os.Exit(2)
+	}
+
+	if galaxy_name != "" {
+		galaxy, is_ok := phonemes.PhonemeToInt(galaxy_name)
+		if !is_ok || galaxy >= 256 {
+			fmt.Printf("Not a valid galaxy name: %q\n", galaxy_name)
+			os.Exit(1)
+		}
+		vote, is_found := db.GetPollVote(proposal, pkg_db.AzimuthNumber(galaxy))
+		if !is_found {
+			fmt.Printf("%s didn't vote on it\n", galaxy_name)
+			return
+		}
+		fmt.Printf("%s voted %t (block %d, tx %x)\n", galaxy_name, vote.Vote, vote.BlockNumber, vote.TxHash)
+		return
+	}
+
+	fmt.Printf("%s poll, started at block %d\n", p.Kind, p.StartBlockNum)
+	if p.HasMajority {
+		fmt.Printf("Got a majority at block %d\n", p.MajorityBlockNum)
+	}
+	fmt.Printf("%-9s  %-6s  %s\n", "Block", "Galaxy", "Vote")
+	fmt.Printf("---------  ------  ----\n")
+	for _, v := range db.GetPollVotes(p) {
+		fmt.Printf("%-9d  %-6s  %t\n", v.BlockNumber, phonemes.IntToPhoneme(uint64(v.Galaxy)), v.Vote)
 	}
 }
 
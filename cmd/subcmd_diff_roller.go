@@ -49,6 +49,10 @@ func decodeRollerHexKey(s string) ([]byte, error) {
 		// "0x" with no data: treat as no key
 		return nil, nil
 	}
+	if len(s)%2 == 1 {
+		// Hex atoms drop their leading zeros, which can leave half a byte at the front
+		s = "0" + s
+	}
 
 	return hex.DecodeString(s)
 }
@@ -222,6 +226,48 @@ type RollerPoint struct {
 	} `json:"network"`
 }
 
+// Make a JSON-RPC call to the roller, and decode its result into `result`
+func callRoller(url string, method string, params interface{}, result interface{}) error {
+	reqBody, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      "7",
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return fmt.Errorf("marshal request: %w", err)
+	}
+
+	resp, err := http.Post(url, "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read response: %w", err)
+	}
+
+	var jsonRPCResp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &jsonRPCResp); err != nil {
+		return fmt.Errorf("unmarshal jsonrpc response: %w", err)
+	}
+	if jsonRPCResp.Error != nil {
+		return fmt.Errorf("jsonrpc error: %s", jsonRPCResp.Error.Message)
+	}
+	if err := json.Unmarshal(jsonRPCResp.Result, result); err != nil {
+		return fmt.Errorf("unmarshal %s result: %w", method, err)
+	}
+	return nil
+}
+
 func CheckPointsAgainstRoller(db DB, url string) error {
 	points, ok := db.GetPoints()
 	if !ok || len(points) == 0 {
@@ -230,44 +276,13 @@ func CheckPointsAgainstRoller(db DB, url string) error {
 	}
 
 	for _, p := range points {
-		reqBody, err := json.Marshal(map[string]interface{}{
-			"jsonrpc": "2.0",
-			"id":      "7",
-			"method":  "getPoint",
-			"params":  map[string]interface{}{"ship": int(p.Number)},
-		})
-		if err != nil {
-			return fmt.Errorf("marshal request for point %d: %w", p.Number, err)
-		}
-
-		resp, err := http.Post(url, "application/json", bytes.NewBuffer(reqBody))
-		if err != nil {
+		var rp RollerPoint
+		if err := callRoller(url, "getPoint", map[string]interface{}{"ship": int(p.Number)}, &rp); err != nil {
 			fmt.Println("roller getpoint error")
 			return fmt.Errorf("roller GetPoint(%d): %w", p.Number, err)
 		}
-		defer resp.Body.Close()
-
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return fmt.Errorf("read response for point %d: %w", p.Number, err)
-		}
-
-		var jsonRPCResp struct {
-			Result RollerPoint `json:"result"`
-			Error  *struct {
-				Code    int    `json:"code"`
-				Message string `json:"message"`
-			} `json:"error"`
-		}
-		if err := json.Unmarshal(body, &jsonRPCResp); err != nil {
-			return fmt.Errorf("unmarshal jsonrpc response for point %d: %w", p.Number, err)
-		}
-
-		if jsonRPCResp.Error != nil {
-			return fmt.Errorf("jsonrpc error for point %d: %s", p.Number, jsonRPCResp.Error.Message)
-		}
 
-		diffs := DiffDBPointWithRemote(p, jsonRPCResp.Result)
+		diffs := DiffDBPointWithRemote(p, rp)
 		if len(diffs) > 0 {
 			fmt.Printf("point %d mismatches:\n", p.Number)
 			for _, d := range diffs {
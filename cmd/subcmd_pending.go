@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+
+	. "go-azimuth/pkg/db"
+)
+
+// A signed L2 transaction in the roller's queue, waiting to be submitted in a Batch (from the
+// roller's `getAllPending`)
+type RollerPendingTx struct {
+	Force   bool   `json:"force"`
+	Address string `json:"address"`
+	RawTx   struct {
+		Sig string `json:"sig"` // 65 bytes
+		Raw string `json:"raw"` // The transaction without the signature, as it'd appear in a Batch
+	} `json:"rawTx"`
+}
+
+func GetRollerPendingTxs(url string) ([]RollerPendingTx, error) {
+	var ret []RollerPendingTx
+	if err := callRoller(url, "getAllPending", map[string]interface{}{}, &ret); err != nil {
+		return nil, fmt.Errorf("roller getAllPending: %w", err)
+	}
+	return ret, nil
+}
+
+// Parse a pending transaction the same way it'd be parsed out of a Batch.  In a Batch, each
+// transaction is its raw data followed by its signature, so a batch of just this one transaction is
+// the two stuck together.
+func ParseRollerPendingTx(tx RollerPendingTx) (NaiveTx, error) {
+	sig, err := decodeRollerHexKey(tx.RawTx.Sig)
+	if err != nil || len(sig) > 65 {
+		return NaiveTx{}, fmt.Errorf("invalid signature %q", tx.RawTx.Sig)
+	}
+	raw, err := decodeRollerHexKey(tx.RawTx.Raw)
+	if err != nil || len(raw) == 0 {
+		return NaiveTx{}, fmt.Errorf("invalid raw tx %q", tx.RawTx.Raw)
+	}
+
+	// Hex atoms drop their leading zeros; put them back on the signature so it's 65 bytes again
+	batch := append(raw, make([]byte, 65-len(sig))...)
+	batch = append(batch, sig...)
+	txs := ParseNaiveBatch(batch, 0)
+	if len(txs) != 1 {
+		return NaiveTx{}, fmt.Errorf("raw tx %q parsed as %d transactions", tx.RawTx.Raw, len(txs))
+	}
+	return txs[0], nil
+}
+
+// Get the roller's pending transactions, parsed.  Ones that can't be parsed are skipped, rather
+// than spoiling the rest; they're returned as errors, for reporting.
+func GetRollerPendingNaiveTxs(url string) ([]NaiveTx, []error, error) {
+	pending, err := GetRollerPendingTxs(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	ret := []NaiveTx{}
+	skipped := []error{}
+	for _, p := range pending {
+		tx, err := ParseRollerPendingTx(p)
+		if err != nil {
+			skipped = append(skipped, fmt.Errorf("pending tx from %s: %w", p.Address, err))
+			continue
+		}
+		ret = append(ret, tx)
+	}
+	return ret, skipped, nil
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// Something a point has published about itself, through the Claims contract
+type Claim struct {
+	Protocol string        `db:"protocol"` // E.g., "twitter", or "eth"
+	Claim    string        `db:"claim"`    // E.g., a handle or an address
+	Dossier  hexutil.Bytes `db:"dossier"`  // Proof of the claim, if any; e.g., a signature
+}
+
+// Get the current claims of a point, oldest first
+func (db DB) GetClaims(azimuth_number AzimuthNumber) []Claim {
+	ret := []Claim{}
+	err := db.DB.Select(&ret, `
+		select protocol, claim, dossier from claims
+		 where azimuth_number = ? and removed_by_event_id is null
+		 order by added_by_event_id`,
+		azimuth_number)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// Get the `i`th dynamic value (string or bytes) out of ABI-encoded data.  The head has one word per
+// value, which is the offset of the value; the value is a length word, then the bytes.
+func abi_dynamic_value(data []byte, i int) ([]byte, error) {
+	word := func(offset uint64) (uint64, error) {
+		if offset+32 > uint64(len(data)) {
+			return 0, fmt.Errorf("offset %d is out of bounds (length %d)", offset, len(data))
+		}
+		return binary.BigEndian.Uint64(data[offset+24 : offset+32]), nil
+	}
+	offset, err := word(uint64(i) * 32)
+	if err != nil {
+		return nil, err
+	}
+	length, err := word(offset)
+	if err != nil {
+		return nil, err
+	}
+	if offset+32+length > uint64(len(data)) {
+		return nil, fmt.Errorf("value %d (length %d) is out of bounds (length %d)", i, length, len(data))
+	}
+	return data[offset+32 : offset+32+length], nil
+}
+
+// Apply a ClaimAdded or ClaimRemoved event.
+//
+// Adding a claim that the point already has replaces it (i.e., updates its dossier).  Points lose
+// all their claims when they're transferred (with a reset), but that emits a ClaimRemoved for each
+// one, so it doesn't need handling here.
+func (db *DB) ApplyClaimsEvent(e EthereumEventLog) {
+	azimuth_number := topic_to_azimuth_number(e.Topic1)
+	protocol, err := abi_dynamic_value(e.Data, 0)
+	if err != nil {
+		panic(fmt.Errorf("decoding claim protocol (event %d): %w", e.ID, err))
+	}
+	claim, err := abi_dynamic_value(e.Data, 1)
+	if err != nil {
+		panic(fmt.Errorf("decoding claim (event %d): %w", e.ID, err))
+	}
+
+	tx := db.DB.MustBegin()
+	// Either way, the old one (if any) is gone
+	tx.MustExec(`
+		update claims set removed_by_event_id = ?
+		 where azimuth_number = ? and protocol = ? and claim = ? and removed_by_event_id is null`,
+		e.ID, azimuth_number, string(protocol), string(claim))
+
+	switch e.Topic0 {
+	case CLAIM_ADDED:
+		dossier, err := abi_dynamic_value(e.Data, 2)
+		if err != nil {
+			panic(fmt.Errorf("decoding claim dossier (event %d): %w", e.ID, err))
+		}
+		tx.MustExec(`
+			insert into claims (azimuth_number, protocol, claim, dossier, added_by_event_id)
+			            values (?, ?, ?, ?, ?)`,
+			azimuth_number, string(protocol), string(claim), dossier, e.ID)
+	case CLAIM_REMOVED:
+	default:
+		panic(e.Topic0)
+	}
+
+	tx.MustExec(`update ethereum_events set is_processed = 1 where rowid = ?`, e.ID)
+	if err := tx.Commit(); err != nil {
+		panic(err)
+	}
+}
+
+// Undo the effects of a Claims event (if it is one), for a reorg
+func (tx Tx) UndoClaimsEvent(event_id uint64) {
+	tx.MustExec(`delete from claims where added_by_event_id = ?`, event_id)
+	tx.MustExec(`update claims set removed_by_event_id = null where removed_by_event_id = ?`, event_id)
+}
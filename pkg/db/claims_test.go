@@ -0,0 +1,72 @@
+package db_test
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "go-azimuth/pkg/db"
+)
+
+// ABI-encode some strings / bytes
+func abi_encode(values ...string) []byte {
+	head := []byte{}
+	tail := []byte{}
+	for _, v := range values {
+		head = append(head, common.BigToHash(big.NewInt(int64(32*len(values)+len(tail)))).Bytes()...)
+		tail = append(tail, common.BigToHash(big.NewInt(int64(len(v)))).Bytes()...)
+		padded := make([]byte, (len(v)+31)/32*32)
+		copy(padded, v)
+		tail = append(tail, padded...)
+	}
+	return append(head, tail...)
+}
+
+func TestClaims(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	db, err := DBCreate(":memory:")
+	require.NoError(err)
+	db.DB.MustExec(`insert into points (azimuth_number) values (5)`)
+
+	claims := common.HexToAddress("e7e7f69b34d7d9bd8d61fb22c33b22708947971a")
+	point := common.BigToHash(big.NewInt(5))
+	events := []EthereumEventLog{
+		{BlockNumber: 100, ContractAddress: claims, Topic0: CLAIM_ADDED, Topic1: point,
+			Data: abi_encode("twitter", "@wispem_wantex", "")},
+		{BlockNumber: 110, ContractAddress: claims, Topic0: CLAIM_ADDED, Topic1: point,
+			Data: abi_encode("eth", "0x671738dada5c209c12b6501e80c62e091c27b14a", "\x01\x02\x03")},
+		{BlockNumber: 120, ContractAddress: claims, Topic0: CLAIM_ADDED, Topic1: point,
+			Data: abi_encode("eth", "0x671738dada5c209c12b6501e80c62e091c27b14a", "a signature that's more than 32 bytes long")},
+		{BlockNumber: 130, ContractAddress: claims, Topic0: CLAIM_REMOVED, Topic1: point,
+			Data: abi_encode("twitter", "@wispem_wantex")},
+	}
+	for i := range events {
+		db.SaveEvent(&events[i])
+	}
+	db.SetFinalizedBlock(130)
+	require.NoError(db.PlayNaiveLogs(context.Background(), nil))
+
+	// Replaced claims are updated; removed ones are gone
+	p, is_found := db.GetPoint(5)
+	require.True(is_found)
+	require.Len(p.Claims, 1)
+	assert.Equal("eth", p.Claims[0].Protocol)
+	assert.Equal("0x671738dada5c209c12b6501e80c62e091c27b14a", p.Claims[0].Claim)
+	assert.Equal([]byte("a signature that's more than 32 bytes long"), []byte(p.Claims[0].Dossier))
+
+	// Reorging out the removal and the update puts them back the way they were
+	require.NoError(db.RollBackToBlock(120, 120, nil))
+	p, is_found = db.GetPoint(5)
+	require.True(is_found)
+	require.Len(p.Claims, 2)
+	assert.Equal("twitter", p.Claims[0].Protocol)
+	assert.Equal("@wispem_wantex", p.Claims[0].Claim)
+	assert.Equal([]byte{}, []byte(p.Claims[0].Dossier))
+	assert.Equal([]byte{1, 2, 3}, []byte(p.Claims[1].Dossier))
+}
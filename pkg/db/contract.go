@@ -1,6 +1,9 @@
 package db
 
 import (
+	"database/sql"
+	"errors"
+
 	"github.com/ethereum/go-ethereum/common"
 )
 
@@ -22,7 +25,45 @@ func (db *DB) GetContractByName(name string) Contract {
 	return ret
 }
 
-// Update the contract to note that more blocks have been fetched, if applicable.  Won't go backward
+// Get a contract that a network might not have (see `Network`), e.g., Polls
+func (db *DB) FindContractByName(name string) (Contract, bool) {
+	var ret Contract
+	query := `SELECT rowid, address, name, start_block, latest_block_fetched FROM contracts WHERE name like ?`
+	err := db.DB.Get(&ret, query, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Contract{}, false
+	} else if err != nil {
+		panic(err)
+	}
+	return ret, true
+}
+
+// Update the contract to note that more blocks have been fetched, if applicable.  Won't go backward.
+// The blocks in between count as fetched too (see `fetched_ranges`).
 func (db *DB) SetLatestContractBlockFetched(contract_id uint64, block_num uint64) {
-	db.DB.MustExec(`UPDATE contracts SET latest_block_fetched = max(latest_block_fetched, ?) WHERE rowid = ?`, block_num, contract_id)
+	tx := db.DB.MustBegin()
+	var contract Contract
+	err := tx.Get(&contract, `SELECT rowid, address, name, start_block, latest_block_fetched FROM contracts WHERE rowid = ?`, contract_id)
+	if err != nil {
+		panic(err)
+	}
+	from_block := max(contract.StartBlockNum, contract.LatestBlockNumFetched+1)
+	if block_num >= from_block {
+		record_fetched_range(tx, contract_id, BlockRange{FromBlock: from_block, ToBlock: block_num})
+	}
+	tx.MustExec(`UPDATE contracts SET latest_block_fetched = max(latest_block_fetched, ?) WHERE rowid = ?`, block_num, contract_id)
+	if err := tx.Commit(); err != nil {
+		panic(err)
+	}
+}
+
+// Get all the contracts with a name, oldest first.  (There can be several Ecliptics.)
+func (db *DB) GetContractsByName(name string) []Contract {
+	var ret []Contract
+	query := `SELECT rowid, address, name, start_block, latest_block_fetched FROM contracts WHERE name like ? ORDER BY start_block`
+	err := db.DB.Select(&ret, query, name)
+	if err != nil {
+		panic(err)
+	}
+	return ret
 }
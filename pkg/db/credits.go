@@ -0,0 +1,19 @@
+package db
+
+// Get how many RPC credits were used on a day (UTC, "YYYY-MM-DD")
+func (db *DB) GetCreditsUsedOn(day string) uint64 {
+	var ret uint64
+	err := db.DB.Get(&ret, `select coalesce((select credits from credit_usage where day = ?), 0)`, day)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// Add to how many RPC credits were used on a day (UTC, "YYYY-MM-DD")
+func (db *DB) AddCreditsUsed(day string, credits uint64) {
+	db.DB.MustExec(`
+		insert into credit_usage (day, credits) values (?, ?)
+		    on conflict (day) do update set credits = credits + excluded.credits`,
+		day, credits)
+}
@@ -3,9 +3,14 @@ package db_test
 import (
 	"fmt"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	. "go-azimuth/pkg/db"
 )
@@ -26,3 +31,67 @@ func TestCreateAndConnectToDB(t *testing.T) {
 	_, err = DBConnect(fmt.Sprintf("../../sample_data/random-%d.db", i))
 	assert.NoError(t, err)
 }
+
+func TestCreditUsage(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	db, err := DBCreate(":memory:")
+	require.NoError(err)
+
+	assert.Equal(uint64(0), db.GetCreditsUsedOn("2024-01-01"))
+	db.AddCreditsUsed("2024-01-01", 100)
+	db.AddCreditsUsed("2024-01-01", 55)
+	db.AddCreditsUsed("2024-01-02", 7)
+	assert.Equal(uint64(155), db.GetCreditsUsedOn("2024-01-01"))
+	assert.Equal(uint64(7), db.GetCreditsUsedOn("2024-01-02"))
+}
+
+func TestMigrateBaselineDB(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// A DB from before any migrations, that's caught up to some block
+	schema, err := os.ReadFile("testdata/baseline_schema.sql")
+	require.NoError(err)
+	path := filepath.Join(t.TempDir(), "old.db")
+	old_db := sqlx.MustOpen("sqlite3", path+"?_foreign_keys=on")
+	old_db.MustExec(string(schema))
+	old_db.MustExec(`update contracts set latest_block_fetched = 15000000`)
+	// A Batch whose call data never got fetched
+	old_db.MustExec(`
+		insert into ethereum_events (block_number, block_hash, tx_hash, log_index, contract_address, topic0, topic1,
+		                             topic2, data)
+		values (14000000, zeroblob(32), zeroblob(32), 0, (select address from contracts where name = 'Naive'), ?,
+		        zeroblob(32), zeroblob(32), X'')`,
+		BATCH)
+	require.NoError(old_db.Close())
+
+	db, err := DBConnect(path)
+	require.NoError(err)
+	var version int
+	require.NoError(db.DB.Get(&version, `select version from db_version`))
+	assert.Equal(ENGINE_DATABASE_VERSION, version)
+
+	// Azimuth gets fetched again, to get the OwnershipTransferred logs that used to be dropped
+	azimuth := db.GetContractByName("Azimuth")
+	assert.Equal(uint64(0), azimuth.LatestBlockNumFetched)
+	assert.Empty(db.GetFetchedRanges(azimuth.ID))
+	assert.Empty(db.GetGaps(azimuth))
+	naive := db.GetContractByName("Naive")
+	assert.Equal(uint64(15000000), naive.LatestBlockNumFetched)
+	assert.Equal([]BlockRange{{FromBlock: naive.StartBlockNum, ToBlock: 15000000}}, db.GetFetchedRanges(naive.ID))
+
+	// Its call data counts as missing (not as an empty batch), so it doesn't get played until it's repaired
+	assert.Len(db.GetBatchesMissingCallData(0, 10), 1)
+
+	// Which lets the Ecliptics get found
+	e := EthereumEventLog{
+		BlockNumber: 7033765, BlockHash: common.BigToHash(common.Big1), ContractAddress: azimuth.Address,
+		Topic0: OWNERSHIP_TRANSFERRED, Topic1: common.HexToHash("0x1111111111111111111111111111111111111111"),
+		Topic2: common.HexToHash("0x2222222222222222222222222222222222222222"), Data: []byte{},
+	}
+	db.SaveEvent(&e)
+	db.RegisterEclipticContracts()
+	assert.Len(db.GetContractsByName("Ecliptic"), 1)
+}
@@ -0,0 +1,118 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"errors"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// A pool of planet invites, from the DelegatedSending contract.  A star gives a point (usually one
+// of its planets) a pool of invites; each invite lets it send one of the star's planets to someone.
+// Planets sent from a pool share that pool.
+type InvitePool struct {
+	Pool      AzimuthNumber // The point the pool was given to
+	Prefix    AzimuthNumber // The star whose planets get sent
+	Size      uint16        // How many invites the pool was given (the last time it was set)
+	Sent      uint16        // How many have been used since then
+	Remaining uint16
+}
+
+// A planet sent with an invite
+type SentInvite struct {
+	BlockNumber uint64
+	TxHash      common.Hash
+	Prefix      AzimuthNumber // The star whose planet it was
+	Pool        AzimuthNumber // Whose pool the invite came from
+	By          AzimuthNumber // Who sent it
+	Point       AzimuthNumber // The planet that got sent
+	To          common.Address
+}
+
+func azimuth_number_to_topic(p AzimuthNumber) common.Hash {
+	var ret common.Hash
+	binary.BigEndian.PutUint32(ret[28:], uint32(p))
+	return ret
+}
+
+// Count the (played) invites sent from a pool since a given event
+func (db *DB) count_invites_sent_since(pool AzimuthNumber, since EthereumEventLog) uint16 {
+	var ret uint16
+	err := db.DB.Get(&ret, `
+	    select count(*) from ethereum_events
+	     where contract_address = (select address from contracts where name = 'DelegatedSending')
+	       and topic0 = ? and topic2 = ? and is_processed = 1
+	       and (block_number > ? or (block_number = ? and log_index > ?))`,
+		SENT, azimuth_number_to_topic(pool), since.BlockNumber, since.BlockNumber, since.LogIndex)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// Get a point's invite pool, i.e., how many invites it has left.  Returns false if it's never been
+// given one.
+//
+// Setting a pool's size replaces it (rather than adding to it), so only invites sent since then
+// count.
+func (db *DB) GetInvitePool(pool AzimuthNumber) (InvitePool, bool) {
+	var pool_event EthereumEventLog
+	err := db.DB.Get(&pool_event, `
+	    select rowid, block_number, block_hash, tx_hash, log_index, contract_address, topic0, topic1,
+	            topic2, topic3, data, is_processed from ethereum_events
+	     where contract_address = (select address from contracts where name = 'DelegatedSending')
+	       and topic0 = ? and topic2 = ? and is_processed = 1
+	  order by block_number desc, log_index desc
+	     limit 1`,
+		POOL, azimuth_number_to_topic(pool))
+	if errors.Is(err, sql.ErrNoRows) {
+		return InvitePool{}, false
+	} else if err != nil {
+		panic(err)
+	}
+
+	ret := InvitePool{
+		Pool:   pool,
+		Prefix: topic_to_azimuth_number(pool_event.Topic1),
+		Size:   uint16(topic_to_uint32(common.BytesToHash(pool_event.Data))),
+		Sent:   db.count_invites_sent_since(pool, pool_event),
+	}
+	if ret.Sent < ret.Size {
+		ret.Remaining = ret.Size - ret.Sent
+	}
+	return ret, true
+}
+
+// Get the planets of a star that were sent with invites, oldest first
+func (db *DB) GetInvitesSentFromStar(star AzimuthNumber) []SentInvite {
+	var events []EthereumEventLog
+	err := db.DB.Select(&events, `
+	    select rowid, block_number, block_hash, tx_hash, log_index, contract_address, topic0, topic1,
+	            topic2, topic3, data, is_processed from ethereum_events
+	     where contract_address = (select address from contracts where name = 'DelegatedSending')
+	       and topic0 = ? and topic1 = ? and is_processed = 1
+	  order by block_number, log_index asc`,
+		SENT, azimuth_number_to_topic(star))
+	if err != nil {
+		panic(err)
+	}
+
+	ret := []SentInvite{}
+	for _, e := range events {
+		// Data is 3 words: by, point, to
+		if len(e.Data) != 3*32 {
+			panic(e)
+		}
+		ret = append(ret, SentInvite{
+			BlockNumber: e.BlockNumber,
+			TxHash:      e.TxHash,
+			Prefix:      topic_to_azimuth_number(e.Topic1),
+			Pool:        topic_to_azimuth_number(e.Topic2),
+			By:          topic_to_azimuth_number(common.BytesToHash(e.Data[0:32])),
+			Point:       topic_to_azimuth_number(common.BytesToHash(e.Data[32:64])),
+			To:          topic_to_eth_address(common.BytesToHash(e.Data[64:96])),
+		})
+	}
+	return ret
+}
@@ -0,0 +1,68 @@
+package db_test
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "go-azimuth/pkg/db"
+)
+
+func TestDelegatedSending(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	db, err := DBCreate(":memory:")
+	require.NoError(err)
+
+	delegated_sending := common.HexToAddress("f6b461fe1ad4bd2ce25b23fe0aff2ac19b3dfa76")
+	as_topic := func(p AzimuthNumber) common.Hash { return common.BigToHash(big.NewInt(int64(p))) }
+	to := common.HexToAddress("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	star := AzimuthNumber(0x0100)
+	pool := AzimuthNumber(0x00010100)
+	sent := func(block_num uint64, by AzimuthNumber, point AzimuthNumber) EthereumEventLog {
+		return EthereumEventLog{BlockNumber: block_num, ContractAddress: delegated_sending, Topic0: SENT,
+			Topic1: as_topic(star), Topic2: as_topic(pool),
+			Data: append(append(as_topic(by).Bytes(), as_topic(point).Bytes()...), common.BytesToHash(to[:]).Bytes()...)}
+	}
+	events := []EthereumEventLog{
+		{BlockNumber: 100, ContractAddress: delegated_sending, Topic0: POOL, Topic1: as_topic(star),
+			Topic2: as_topic(pool), Data: as_topic(3).Bytes()},
+		sent(101, pool, 0x00020100),
+		// Resetting the pool's size
+		{BlockNumber: 102, ContractAddress: delegated_sending, Topic0: POOL, Topic1: as_topic(star),
+			Topic2: as_topic(pool), Data: as_topic(5).Bytes()},
+		sent(103, 0x00020100, 0x00030100), // By a planet that was sent from the pool
+		sent(104, pool, 0x00040100),
+	}
+	for i := range events {
+		db.SaveEvent(&events[i])
+	}
+
+	// Not played yet
+	_, is_found := db.GetInvitePool(pool)
+	assert.False(is_found)
+
+	db.SetFinalizedBlock(104)
+	require.NoError(db.PlayNaiveLogs(context.Background(), nil))
+	p, is_found := db.GetInvitePool(pool)
+	require.True(is_found)
+	assert.Equal(star, p.Prefix)
+	assert.Equal(uint16(5), p.Size)
+	assert.Equal(uint16(2), p.Sent)
+	assert.Equal(uint16(3), p.Remaining)
+	_, is_found = db.GetInvitePool(0x00050100)
+	assert.False(is_found)
+
+	result := db.GetInvitesSentFromStar(star)
+	require.Len(result, 3)
+	assert.Equal(AzimuthNumber(0x00030100), result[1].Point)
+	assert.Equal(AzimuthNumber(0x00020100), result[1].By)
+	assert.Equal(pool, result[1].Pool)
+	assert.Equal(to, result[1].To)
+	assert.Len(db.GetInvitesSentFromStar(0x0200), 0)
+}
@@ -71,3 +71,13 @@ const (
 	DIFF_RESET_KEYS
 	DIFF_NEW_DOMINION
 )
+
+// Get the name of a diff operation, e.g., "spawn" for DIFF_SPAWNED
+func (db DB) GetDiffTypeName(operation uint) string {
+	var ret string
+	err := db.DB.Get(&ret, `select name from diff_types where rowid = ?`, operation)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// The events an Ecliptic can emit
+var ECLIPTIC_EVENTS = []common.Hash{OWNERSHIP_TRANSFERRED, TRANSFER, APPROVAL, APPROVAL_FOR_ALL, UPGRADED}
+
+// Ecliptic is Azimuth's owner, and every time Ecliptic is upgraded, Azimuth's ownership gets
+// transferred to the new one.  So each OwnershipTransferred event from Azimuth is a new Ecliptic.
+//
+// Register each one as a contract, starting at the block it took over, so its logs can be fetched.
+// Ones that are already registered are left alone.
+func (db *DB) RegisterEclipticContracts() {
+	var events []EthereumEventLog
+	err := db.DB.Select(&events, `
+	    select rowid, block_number, topic1, topic2 from ethereum_events
+	     where contract_address = ? and topic0 = ?
+	  order by block_number, log_index asc
+	`, db.GetContractByName("Azimuth").Address, OWNERSHIP_TRANSFERRED)
+	if err != nil {
+		panic(err)
+	}
+
+	tx := db.DB.MustBegin()
+	for _, e := range events {
+		if e.Topic1 == (common.Hash{}) {
+			// From nobody; that's just a contract being created, which makes its deployer the owner
+			continue
+		}
+		address := topic_to_eth_address(e.Topic2)
+		tx.MustExec(`insert or ignore into contracts (address, name, start_block) values (?, 'Ecliptic', ?)`,
+			address, e.BlockNumber)
+		for _, hashed_name := range ECLIPTIC_EVENTS {
+			tx.MustExec(`insert or ignore into event_types (contract_address, hashed_name, name) values (?, ?, ?)`,
+				address, hashed_name, EVENT_NAMES[hashed_name])
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		panic(err)
+	}
+}
+
+// Get the addresses that `owner` has approved as operators, i.e., to move all of its points
+func (db *DB) GetOperatorsOf(owner common.Address) []common.Address {
+	ret := []common.Address{}
+	err := db.DB.Select(&ret, `select operator_address from operators where owner_address = ?`, owner)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// Get the addresses that have approved `operator` to move all of their points
+func (db *DB) GetOwnersApprovingOperator(operator common.Address) []common.Address {
+	ret := []common.Address{}
+	err := db.DB.Select(&ret, `select owner_address from operators where operator_address = ?`, operator)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// Everyone who can transfer a point
+type PointApprovals struct {
+	Owner         common.Address
+	TransferProxy common.Address   // Set by Ecliptic's `approve`, among other things
+	Operators     []common.Address // Can move every point the owner has
+}
+
+func (db *DB) GetApprovalsForPoint(azimuth_number AzimuthNumber) (PointApprovals, bool) {
+	p, is_ok := db.GetPoint(azimuth_number)
+	if !is_ok {
+		return PointApprovals{}, false
+	}
+	return PointApprovals{
+		Owner:         p.OwnerAddress,
+		TransferProxy: p.TransferAddress,
+		Operators:     db.GetOperatorsOf(p.OwnerAddress),
+	}, true
+}
@@ -0,0 +1,84 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "go-azimuth/pkg/db"
+)
+
+func TestEclipticOperators(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	db, err := DBCreate(":memory:")
+	require.NoError(err)
+
+	azimuth := common.HexToAddress("223c067f8cf28ae173ee5cafea60ca44c335fecb")
+	deployer := common.HexToAddress("dddddddddddddddddddddddddddddddddddddddd")
+	ecliptic_1 := common.HexToAddress("e1e1e1e1e1e1e1e1e1e1e1e1e1e1e1e1e1e1e1e1")
+	ecliptic_2 := common.HexToAddress("e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2")
+	owner := common.HexToAddress("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	operator_1 := common.HexToAddress("0000000000000000000000000000000000000001")
+	operator_2 := common.HexToAddress("0000000000000000000000000000000000000002")
+	as_topic := func(a common.Address) common.Hash { return common.BytesToHash(a[:]) }
+	is_approved := common.BigToHash(common.Big1).Bytes()
+	not_approved := common.Hash{}.Bytes()
+
+	// Ecliptic upgrades
+	upgrades := []EthereumEventLog{
+		{BlockNumber: 100, ContractAddress: azimuth, Topic0: OWNERSHIP_TRANSFERRED, Topic1: as_topic(deployer),
+			Topic2: as_topic(ecliptic_1)},
+		{BlockNumber: 200, ContractAddress: azimuth, Topic0: OWNERSHIP_TRANSFERRED, Topic1: as_topic(ecliptic_1),
+			Topic2: as_topic(ecliptic_2)},
+	}
+	for i := range upgrades {
+		upgrades[i].Data = []byte{}
+		db.SaveEvent(&upgrades[i])
+	}
+	db.RegisterEclipticContracts()
+	db.RegisterEclipticContracts() // Should be idempotent
+	ecliptics := db.GetContractsByName("Ecliptic")
+	require.Len(ecliptics, 2)
+	assert.Equal(ecliptic_1, ecliptics[0].Address)
+	assert.Equal(uint64(100), ecliptics[0].StartBlockNum)
+	assert.Equal(ecliptic_2, ecliptics[1].Address)
+	assert.Equal(uint64(200), ecliptics[1].StartBlockNum)
+
+	// Operator approvals, across both Ecliptics
+	events := []EthereumEventLog{
+		{BlockNumber: 150, ContractAddress: ecliptic_1, Topic0: APPROVAL_FOR_ALL, Topic1: as_topic(owner),
+			Topic2: as_topic(operator_1), Data: is_approved},
+		{BlockNumber: 250, ContractAddress: ecliptic_2, Topic0: APPROVAL_FOR_ALL, Topic1: as_topic(owner),
+			Topic2: as_topic(operator_2), Data: is_approved},
+		{BlockNumber: 255, ContractAddress: ecliptic_2, Topic0: APPROVAL_FOR_ALL, Topic1: as_topic(owner),
+			Topic2: as_topic(operator_1), Data: not_approved},
+		{BlockNumber: 260, ContractAddress: ecliptic_2, Topic0: TRANSFER, Topic1: as_topic(owner),
+			Topic2: as_topic(operator_2), Topic3: common.BigToHash(common.Big3), Data: []byte{}},
+	}
+	for i := range events {
+		db.SaveEvent(&events[i])
+	}
+
+	// Not played yet
+	assert.Len(db.GetOperatorsOf(owner), 0)
+
+	db.SetFinalizedBlock(300)
+	require.NoError(db.PlayNaiveLogs(context.Background(), nil))
+	assert.Equal([]common.Address{operator_2}, db.GetOperatorsOf(owner))
+	assert.Equal([]common.Address{owner}, db.GetOwnersApprovingOperator(operator_2))
+	assert.Len(db.GetOwnersApprovingOperator(operator_1), 0)
+
+	var transfer EthereumEventLog
+	require.NoError(db.DB.Get(&transfer, `select * from ethereum_events where topic0 = ?`, TRANSFER))
+	assert.Equal(common.BigToHash(common.Big3), transfer.Topic3)
+
+	// Reorging out the upgrade forgets the new Ecliptic
+	require.NoError(db.RollBackToBlock(200, 200, nil))
+	assert.Len(db.GetContractsByName("Ecliptic"), 1)
+	assert.Equal([]common.Address{operator_1}, db.GetOperatorsOf(owner))
+}
@@ -1,10 +1,12 @@
 package db
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/jmoiron/sqlx"
 	"golang.org/x/crypto/sha3"
 )
 
@@ -31,12 +33,33 @@ var (
 	CHANGED_KEYS             = get_hash("ChangedKeys(uint32,bytes32,bytes32,uint32,uint32)")
 	CHANGED_DNS              = get_hash("ChangedDns(string,string,string)")
 
-	// Ecliptic Events
-	// APPROVAL_FOR_ALL         = get_hash("ApprovalForAll(address,address,bool)")
-	// OWNERSHIP_TRANSFERRED    = get_hash("OwnershipTransferred(address,address)")
+	// Ecliptic Events (Azimuth also emits OwnershipTransferred, when Ecliptic gets upgraded)
+	OWNERSHIP_TRANSFERRED = get_hash("OwnershipTransferred(address,address)")
+	TRANSFER              = get_hash("Transfer(address,address,uint256)")
+	APPROVAL              = get_hash("Approval(address,address,uint256)")
+	APPROVAL_FOR_ALL      = get_hash("ApprovalForAll(address,address,bool)")
+	UPGRADED              = get_hash("Upgraded(address)")
+
+	// Polls Events
+	UPGRADE_POLL_STARTED  = get_hash("UpgradePollStarted(address)")
+	DOCUMENT_POLL_STARTED = get_hash("DocumentPollStarted(bytes32)")
+	UPGRADE_MAJORITY      = get_hash("UpgradeMajority(address)")
+	DOCUMENT_MAJORITY     = get_hash("DocumentMajority(bytes32)")
+
+	// Claims Events
+	CLAIM_ADDED   = get_hash("ClaimAdded(uint32,string,string,bytes)")
+	CLAIM_REMOVED = get_hash("ClaimRemoved(uint32,string,string)")
+
+	// DelegatedSending Events
+	POOL = get_hash("Pool(uint16,uint32,uint16)")
+	SENT = get_hash("Sent(uint16,uint32,uint32,uint32,address)")
 
 	// Naive Events
 	BATCH = get_hash("Batch()")
+
+	// ConditionalStarRelease Events.  (Fetched on their own, with the calls; see the scraper's star
+	// release code.)
+	CONDITION_COMPLETED = get_hash("ConditionCompleted(uint8,uint256)")
 )
 
 var EVENT_NAMES = map[common.Hash]string{}
@@ -57,10 +80,26 @@ func init() {
 	EVENT_NAMES[CHANGED_VOTING_PROXY] = "ChangedVotingProxy"
 	EVENT_NAMES[CHANGED_DNS] = "ChangedDns"
 
-	// EVENT_NAMES[APPROVAL_FOR_ALL] = "ApprovalForAll"
-	// EVENT_NAMES[OWNERSHIP_TRANSFERRED] = "OwnershipTransferred"
+	EVENT_NAMES[OWNERSHIP_TRANSFERRED] = "OwnershipTransferred"
+	EVENT_NAMES[TRANSFER] = "Transfer"
+	EVENT_NAMES[APPROVAL] = "Approval"
+	EVENT_NAMES[APPROVAL_FOR_ALL] = "ApprovalForAll"
+	EVENT_NAMES[UPGRADED] = "Upgraded"
+
+	EVENT_NAMES[UPGRADE_POLL_STARTED] = "UpgradePollStarted"
+	EVENT_NAMES[DOCUMENT_POLL_STARTED] = "DocumentPollStarted"
+	EVENT_NAMES[UPGRADE_MAJORITY] = "UpgradeMajority"
+	EVENT_NAMES[DOCUMENT_MAJORITY] = "DocumentMajority"
+
+	EVENT_NAMES[CLAIM_ADDED] = "ClaimAdded"
+	EVENT_NAMES[CLAIM_REMOVED] = "ClaimRemoved"
+
+	EVENT_NAMES[POOL] = "Pool"
+	EVENT_NAMES[SENT] = "Sent"
 
 	EVENT_NAMES[BATCH] = "Batch"
+
+	EVENT_NAMES[CONDITION_COMPLETED] = "ConditionCompleted"
 }
 
 var L2_DEPOSIT_ADDRESS = common.HexToAddress("1111111111111111111111111111111111111111")
@@ -82,23 +121,49 @@ type EthereumEventLog struct {
 	Topic0          common.Hash    `db:"topic0"` // Hashed version of Name and the arg types
 	Topic1          common.Hash    `db:"topic1"`
 	Topic2          common.Hash    `db:"topic2"`
+	Topic3          common.Hash    `db:"topic3"`
 	Data            []byte         `db:"data"`
 
-	IsProcessed bool `db:"is_processed"`
+	IsProcessed bool   `db:"is_processed"`
+	IsVerified  bool   `db:"is_verified"` // Checked against its block's receipts root
+	SenderRole  string `db:"sender_role"` // Which of the point's roles sent the transaction, if known
+
+	IsCallDataMissing bool `db:"is_call_data_missing"` // Batch events whose call data couldn't be found
 }
 
-func (db *DB) SaveEvent(e *EthereumEventLog) {
-	result, err := db.DB.NamedExec(`
-		insert into ethereum_events (
-			            block_number, block_hash, tx_hash, log_index, contract_address, topic0, topic1, topic2, data, is_processed
-			        ) values (
-			            :block_number, :block_hash, :tx_hash, :log_index, :contract_address, :topic0, :topic1, :topic2, :data,
-			            :is_processed
-			        )
-	`, e)
+const save_event_sql = `
+	insert into ethereum_events (
+	            block_number, block_hash, tx_hash, log_index, contract_address, topic0, topic1, topic2, topic3, data,
+	            is_processed, is_call_data_missing
+	        ) values (
+	            :block_number, :block_hash, :tx_hash, :log_index, :contract_address, :topic0, :topic1, :topic2, :topic3,
+	            :data, :is_processed, :is_call_data_missing
+	        )
+	on conflict (block_number, log_index) do nothing
+`
+
+// Save an event, and set its ID.  If it's already saved (e.g., its range got fetched again), the
+// existing one is kept, and its ID is used.
+//
+// WTF: "already saved" just means same block number and log index.  A reorged-out event would
+// match too, but those get rolled back (and deleted) before their blocks are fetched again.
+func save_event(ext sqlx.Ext, e *EthereumEventLog) {
+	result, err := sqlx.NamedExec(ext, save_event_sql, e)
 	if err != nil {
 		panic(err)
 	}
+	num_rows, err := result.RowsAffected()
+	if err != nil {
+		panic(err)
+	}
+	if num_rows == 0 {
+		err := sqlx.Get(ext, &e.ID, `select rowid from ethereum_events where block_number = ? and log_index = ?`,
+			e.BlockNumber, e.LogIndex)
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
 
 	// Update the event's ID
 	new_id, err := result.LastInsertId()
@@ -108,9 +173,52 @@ func (db *DB) SaveEvent(e *EthereumEventLog) {
 	e.ID = uint64(new_id)
 }
 
+func (db *DB) SaveEvent(e *EthereumEventLog) {
+	save_event(db.DB, e)
+}
+
+// Save the events from a range of blocks (and the blocks and transactions they're in), and mark
+// the range as fetched, all at once.  That way an interrupted fetch never leaves a range half-saved, so it can
+// always be resumed.  Events that are already saved are left alone, so fetching a range again is fine.
+func (db *DB) SaveFetchedEvents(
+	contract_id uint64, events []EthereumEventLog, blocks []BlockInfo, txs []TransactionInfo,
+	fetched BlockRange,
+) {
+	t, err := db.DB.Beginx()
+	if err != nil {
+		panic(err)
+	}
+	for i := range events {
+		save_event(t, &events[i])
+	}
+	for _, b := range blocks {
+		_, err := t.NamedExec(`
+			insert or replace into blocks (block_number, block_hash, timestamp)
+			                       values (:block_number, :block_hash, :timestamp)`,
+			b)
+		if err != nil {
+			panic(err)
+		}
+	}
+	for _, tx := range txs {
+		if _, err := t.NamedExec(save_transaction_sql, tx); err != nil {
+			panic(err)
+		}
+	}
+	record_fetched_range(t, contract_id, fetched)
+	t.MustExec(`update contracts set latest_block_fetched = max(latest_block_fetched, ?) where rowid = ?`,
+		fetched.ToBlock, contract_id)
+	if err := t.Commit(); err != nil {
+		panic(err)
+	}
+}
+
 // Either create a new event, or add in the Naive Batch data after the fact
 func (db *DB) SmuggleNaiveBatchDataIntoEvent(e EthereumEventLog) {
-	rslt, err := db.DB.NamedExec(`update ethereum_events set data=:data where block_number=:block_number and log_index=:log_index`, e)
+	rslt, err := db.DB.NamedExec(`
+		update ethereum_events
+		   set data=coalesce(:data, X''), is_call_data_missing=:is_call_data_missing
+		 where block_number=:block_number and log_index=:log_index`, e)
 	if err != nil {
 		panic(err)
 	}
@@ -127,15 +235,29 @@ func (db *DB) SmuggleNaiveBatchDataIntoEvent(e EthereumEventLog) {
 //
 // WTF(naive-azimuth-interlacing): Note that L1 and L2 txs actually do have to be
 // processed in-order; the L1 does not "happen before" the L2, as I had previously believed.
-func (db *DB) PlayAzimuthLogs() {
+//
+// Each batch is played in its own transaction.  If `ctx` gets cancelled, it stops after the current
+// batch and returns the context's error; playing again picks up where it left off.
+func (db *DB) PlayAzimuthLogs(ctx context.Context, progress ProgressReporter) error {
+	const where_clause = `
+		     where contract_address = (select address from contracts where name = 'Azimuth') and is_processed = 0
+		       and block_number < (select start_block from contracts where name like 'Naive')
+		       and block_number <= (select block_number from finalized_block)`
+	var total uint64
+	if err := db.DB.Get(&total, `select count(*) from ethereum_events`+where_clause); err != nil {
+		panic(err)
+	}
+
 	var events []EthereumEventLog
+	done := uint64(0)
 	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		// Batches of 500.  Go until the Naive contract starts
 		err := db.DB.Select(&events, `
 		    select rowid, block_number, block_hash, tx_hash, log_index, contract_address, topic0, topic1,
-		            topic2, data, is_processed from ethereum_events
-		     where contract_address = X'223c067f8cf28ae173ee5cafea60ca44c335fecb' and is_processed = 0
-		       and block_number < (select start_block from contracts where name like 'Naive')
+		            topic2, topic3, data, is_processed from ethereum_events`+where_clause+`
 		  order by block_number, log_index asc
 		     limit 500
 		`)
@@ -143,10 +265,16 @@ func (db *DB) PlayAzimuthLogs() {
 			panic(err)
 		} else if len(events) == 0 {
 			// No unprocessed logs left; we're finished
-			break
+			return nil
 		}
-		fmt.Printf("Applying events %d to %d\n", events[0].ID, events[len(events)-1].ID)
 		db.ApplyEventEffects(events)
+		done += uint64(len(events))
+		ReportProgress(progress, Progress{
+			Task:         "Playing Azimuth logs",
+			Done:         done,
+			Total:        total,
+			CurrentBlock: events[len(events)-1].BlockNumber,
+		})
 	}
 }
 
@@ -156,12 +284,22 @@ func (db *DB) ApplyEventEffects(events []EthereumEventLog) {
 		panic(err)
 	}
 	tx := Tx{t}
+	newest_block_num := tx.GetNewestEventBlockNum()
 
 	for _, e := range events {
+		e.SenderRole = tx.get_sender_role(e, false) // Before the point changes
 		effects, diffs := e.Effects(tx)
 
 		// Apply the query
 		if effects.SQL != "" {
+			if is_in_reorg_window(e.BlockNumber, newest_block_num) {
+				// Keep the point's previous state around in case this event gets reorged out
+				p, is_ok := effects.BindValues.(Point)
+				if !is_ok {
+					panic(effects.BindValues)
+				}
+				tx.SavePointSnapshot(e.ID, p.Number)
+			}
 			_, err = tx.NamedExec(effects.SQL, effects.BindValues)
 			if err != nil {
 				fmt.Printf("%q; %#v\n", effects.SQL, effects.BindValues)
@@ -180,7 +318,7 @@ func (db *DB) ApplyEventEffects(events []EthereumEventLog) {
 		// Mark the event as processed
 		_, err = tx.NamedExec(`
 			update ethereum_events
-			   set is_processed=1
+			   set is_processed=1, sender_role=:sender_role
 			 where block_number = :block_number and log_index = :log_index`,
 			e)
 		if err != nil {
@@ -616,6 +754,20 @@ func (e EthereumEventLog) Effects(tx Tx) (Query, []AzimuthDiff) {
 			}}
 	case CHANGED_DNS:
 		return Query{}, []AzimuthDiff{} // TODO
+	case OWNERSHIP_TRANSFERRED, TRANSFER, APPROVAL, UPGRADED:
+		// Ecliptic stuff.  Transfers and approvals also emit OwnerChanged and ChangedTransferProxy from
+		// Azimuth, which is where their effects get applied.
+		return Query{}, []AzimuthDiff{}
+	case APPROVAL_FOR_ALL:
+		// See the `operators` view
+		return Query{}, []AzimuthDiff{}
+	case UPGRADE_POLL_STARTED, DOCUMENT_POLL_STARTED, UPGRADE_MAJORITY, DOCUMENT_MAJORITY:
+		// See the `polls` view
+		return Query{}, []AzimuthDiff{}
+	case POOL, SENT:
+		// Planet invites.  Sending a planet also emits Azimuth events, which is where its effects get
+		// applied.  See `GetInvitePool`.
+		return Query{}, []AzimuthDiff{}
 	default:
 		panic(e.Topic0)
 	}
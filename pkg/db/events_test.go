@@ -40,3 +40,38 @@ func TestRiftEvent(t *testing.T) {
 	assert.Equal(azm_num, diffs[0].AzimuthNumber)
 	assert.Equal([]byte{0x0, 0x0, 0x0, 0x1}, diffs[0].Data)
 }
+
+func TestSaveFetchedEventsWithTimestamps(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	db, err := DBCreate(":memory:")
+	require.NoError(err)
+
+	azimuth := db.GetContractByName("Azimuth")
+	galaxy := common.HexToHash("0000000000000000000000000000000000000000000000000000000000000005")
+	events := []EthereumEventLog{
+		{BlockNumber: 100, BlockHash: common.Hash{100}, ContractAddress: azimuth.Address, Topic0: ACTIVATED,
+			Topic1: galaxy, Data: []byte{}},
+		{BlockNumber: 101, BlockHash: common.Hash{101}, ContractAddress: azimuth.Address, Topic0: OWNER_CHANGED,
+			Topic1: galaxy, Data: []byte{}},
+	}
+	// Block 101's time is unknown
+	db.SaveFetchedEvents(azimuth.ID, events, []BlockInfo{{Number: 100, Hash: common.Hash{100}, Timestamp: 1546300800}}, nil,
+		BlockRange{FromBlock: 100, ToBlock: 101})
+	assert.Equal(uint64(101), db.GetContractByName("Azimuth").LatestBlockNumFetched)
+	assert.Equal(uint64(1546300800), db.GetBlockTimestamp(100))
+	assert.Equal([]uint64{101}, db.GetEventBlocksWithoutTimestamps(0, 10))
+	assert.Equal([]uint64{}, db.GetEventBlocksWithoutTimestamps(101, 10))
+	// Once it's known to be unknown, it doesn't come up again
+	db.SaveUnknownBlockTimestamps([]uint64{101})
+	assert.Equal([]uint64{}, db.GetEventBlocksWithoutTimestamps(0, 10))
+
+	db.ApplyEventEffects(events)
+	history, is_found := db.GetEventsForPoint(AzimuthNumber(5))
+	require.True(is_found)
+	require.Len(history, 2)
+	assert.Equal(uint64(100), history[0].BlockNumber)
+	assert.Equal(uint64(1546300800), history[0].Timestamp)
+	assert.Equal(uint64(0), history[1].Timestamp)
+}
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"github.com/jmoiron/sqlx"
+)
+
+// A range of blocks, inclusive
+type BlockRange struct {
+	FromBlock uint64 `db:"from_block"`
+	ToBlock   uint64 `db:"to_block"`
+}
+
+// Note that a contract's logs from a range of blocks have been fetched.  It gets merged with any
+// ranges it touches or overlaps, so there's only one row per contiguous stretch.
+func record_fetched_range(ext sqlx.Ext, contract_id uint64, r BlockRange) {
+	var touching []BlockRange
+	err := sqlx.Select(ext, &touching, `
+		select from_block, to_block from fetched_ranges
+		 where contract_id = ? and from_block <= ? + 1 and to_block + 1 >= ?`,
+		contract_id, r.ToBlock, r.FromBlock)
+	if err != nil {
+		panic(err)
+	}
+	merged := r
+	for _, t := range touching {
+		merged.FromBlock = min(merged.FromBlock, t.FromBlock)
+		merged.ToBlock = max(merged.ToBlock, t.ToBlock)
+	}
+	sqlx.MustExec(ext, `
+		delete from fetched_ranges where contract_id = ? and from_block <= ? + 1 and to_block + 1 >= ?`,
+		contract_id, r.ToBlock, r.FromBlock)
+	sqlx.MustExec(ext, `insert into fetched_ranges (contract_id, from_block, to_block) values (?, ?, ?)`,
+		contract_id, merged.FromBlock, merged.ToBlock)
+}
+
+// Get the ranges of blocks a contract's logs have been fetched from, in order
+func (db *DB) GetFetchedRanges(contract_id uint64) []BlockRange {
+	ret := []BlockRange{}
+	err := db.DB.Select(&ret, `
+		select from_block, to_block from fetched_ranges where contract_id = ? order by from_block`,
+		contract_id)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// Get the ranges of blocks between a contract's start block and the latest block fetched whose logs
+// haven't been fetched
+func (db *DB) GetGaps(contract Contract) []BlockRange {
+	ret := []BlockRange{}
+	next_block := contract.StartBlockNum // The first block that isn't known to be fetched
+	for _, r := range db.GetFetchedRanges(contract.ID) {
+		if r.FromBlock > next_block {
+			ret = append(ret, BlockRange{FromBlock: next_block, ToBlock: r.FromBlock - 1})
+		}
+		next_block = max(next_block, r.ToBlock+1)
+	}
+	if contract.LatestBlockNumFetched >= next_block {
+		ret = append(ret, BlockRange{FromBlock: next_block, ToBlock: contract.LatestBlockNumFetched})
+	}
+	return ret
+}
+
+// Get the contracts whose logs get fetched (i.e., all of them except the star release ones), oldest
+// first
+func (db *DB) GetLogContracts() []Contract {
+	var ret []Contract
+	err := db.DB.Select(&ret, `
+		select rowid, address, name, start_block, latest_block_fetched from contracts
+		 where name not like '%StarRelease'
+		 order by start_block, rowid`)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// Get the block number of the newest event that's been played.  Zero if none have.
+func (db *DB) GetNewestPlayedEventBlockNum() uint64 {
+	var ret uint64
+	err := db.DB.Get(&ret, `select coalesce(max(block_number), 0) from ethereum_events where is_processed = 1`)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
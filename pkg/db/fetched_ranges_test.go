@@ -0,0 +1,56 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "go-azimuth/pkg/db"
+)
+
+func TestFetchedRangesAndGaps(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	db, err := DBCreate(":memory:")
+	require.NoError(err)
+
+	azimuth := db.GetContractByName("Azimuth")
+	start := azimuth.StartBlockNum
+	galaxy := common.HexToHash("0000000000000000000000000000000000000000000000000000000000000005")
+	event := func(block_num uint64) EthereumEventLog {
+		return EthereumEventLog{BlockNumber: block_num, BlockHash: common.Hash{1}, ContractAddress: azimuth.Address,
+			Topic0: ACTIVATED, Topic1: galaxy, Data: []byte{}}
+	}
+
+	db.SetLatestContractBlockFetched(azimuth.ID, start+99)
+	db.SaveFetchedEvents(azimuth.ID, nil, nil, nil, BlockRange{FromBlock: start + 200, ToBlock: start + 299})
+	db.SaveFetchedEvents(azimuth.ID, nil, nil, nil, BlockRange{FromBlock: start + 300, ToBlock: start + 399})
+	assert.Equal([]BlockRange{
+		{FromBlock: start, ToBlock: start + 99},
+		{FromBlock: start + 200, ToBlock: start + 399}, // Touching ranges get merged
+	}, db.GetFetchedRanges(azimuth.ID))
+	assert.Equal([]BlockRange{{FromBlock: start + 100, ToBlock: start + 199}},
+		db.GetGaps(db.GetContractByName("Azimuth")))
+
+	// Fill the gap, overlapping what's already fetched
+	events := []EthereumEventLog{event(start + 150)}
+	db.SaveFetchedEvents(azimuth.ID, events, nil, nil, BlockRange{FromBlock: start + 50, ToBlock: start + 250})
+	assert.Equal([]BlockRange{{FromBlock: start, ToBlock: start + 399}}, db.GetFetchedRanges(azimuth.ID))
+	assert.Equal([]BlockRange{}, db.GetGaps(db.GetContractByName("Azimuth")))
+
+	// Saving the same event again keeps the existing one
+	again := []EthereumEventLog{event(start + 150)}
+	db.SaveFetchedEvents(azimuth.ID, again, nil, nil, BlockRange{FromBlock: start + 100, ToBlock: start + 199})
+	assert.Equal(events[0].ID, again[0].ID)
+	var num_events int
+	require.NoError(db.DB.Get(&num_events, `select count(*) from ethereum_events`))
+	assert.Equal(1, num_events)
+
+	// Rolling back un-fetches the blocks
+	require.NoError(db.RollBackToBlock(start+150, start+150, nil))
+	assert.Equal([]BlockRange{{FromBlock: start, ToBlock: start + 149}}, db.GetFetchedRanges(azimuth.ID))
+	assert.Equal([]BlockRange{}, db.GetGaps(db.GetContractByName("Azimuth")))
+}
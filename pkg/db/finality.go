@@ -0,0 +1,57 @@
+package db
+
+// Get the newest block whose events are final, i.e., can be played
+func (db *DB) GetFinalizedBlock() uint64 {
+	var ret uint64
+	err := db.DB.Get(&ret, `select block_number from finalized_block`)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// Update the finalized block.  Won't go backward (except in a reorg; see `RollBackToBlock`)
+func (db *DB) SetFinalizedBlock(block_num uint64) {
+	db.DB.MustExec(`update finalized_block set block_number = max(block_number, ?)`, block_num)
+}
+
+// Get the events that have been fetched, but aren't final yet, so they haven't been played.  They
+// could still disappear in a reorg.
+func (db *DB) GetPendingEvents() []EthereumEventLog {
+	var ret []EthereumEventLog
+	err := db.DB.Select(&ret, `
+	    select rowid, block_number, block_hash, tx_hash, log_index, contract_address, topic0, topic1,
+	            topic2, topic3, data, is_processed from ethereum_events
+	     where is_processed = 0 and block_number > (select block_number from finalized_block)
+	  order by block_number, log_index asc
+	`)
+	if err != nil {
+		panic(err)
+	}
+	for i := range ret {
+		ret[i].Name = EVENT_NAMES[ret[i].Topic0]
+	}
+	return ret
+}
+
+// Get the pending events (see above) that affect a point.  For Naive batches, that's if any of the
+// L2 transactions in the batch are from or to the point.
+func (db *DB) GetPendingEventsForPoint(azimuth_number AzimuthNumber) []EthereumEventLog {
+	ret := []EthereumEventLog{}
+	for _, e := range db.GetPendingEvents() {
+		if e.Topic0 == BATCH {
+			for _, tx := range ParseNaiveBatch(e.Data, e.ID) {
+				if tx.SourceShip == azimuth_number || tx.TargetShip == azimuth_number {
+					ret = append(ret, e)
+					break
+				}
+			}
+		} else if e.Topic0 == SPAWNED && topic_to_azimuth_number(e.Topic2) == azimuth_number {
+			// The child is the second topic
+			ret = append(ret, e)
+		} else if e.Topic0 != CHANGED_DNS && topic_to_azimuth_number(e.Topic1) == azimuth_number {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,71 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "go-azimuth/pkg/db"
+)
+
+func TestOnlyFinalEventsArePlayed(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	db, err := DBCreate(":memory:")
+	require.NoError(err)
+
+	azimuth := common.HexToAddress("223c067f8cf28ae173ee5cafea60ca44c335fecb")
+	galaxy := common.HexToHash("0000000000000000000000000000000000000000000000000000000000000005")
+	address_a := common.HexToAddress("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	events := []EthereumEventLog{
+		{BlockNumber: 100, ContractAddress: azimuth, Topic0: ACTIVATED, Topic1: galaxy},
+		{BlockNumber: 101, ContractAddress: azimuth, Topic0: OWNER_CHANGED, Topic1: galaxy,
+			Topic2: common.BytesToHash(address_a[:])},
+	}
+	for i := range events {
+		events[i].Data = []byte{}
+		db.SaveEvent(&events[i])
+	}
+	assert.Equal(uint64(0), db.GetFinalizedBlock())
+
+	// Only the activation is final
+	db.SetFinalizedBlock(100)
+
+	// Nothing gets played if it's cancelled already
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(db.PlayAzimuthLogs(ctx, nil), context.Canceled)
+	_, is_ok := db.GetPoint(AzimuthNumber(5))
+	assert.False(is_ok)
+
+	reports := []Progress{}
+	require.NoError(db.PlayAzimuthLogs(context.Background(), ProgressFunc(func(p Progress) {
+		reports = append(reports, p)
+	})))
+	assert.Equal([]Progress{{Task: "Playing Azimuth logs", Done: 1, Total: 1, CurrentBlock: 100}}, reports)
+	p, is_ok := db.GetPoint(AzimuthNumber(5))
+	require.True(is_ok)
+	assert.True(p.IsActive)
+	assert.Equal(common.Address{}, p.OwnerAddress)
+
+	pending := db.GetPendingEventsForPoint(AzimuthNumber(5))
+	require.Len(pending, 1)
+	assert.Equal("OwnerChanged", pending[0].Name)
+	assert.Len(db.GetPendingEventsForPoint(AzimuthNumber(6)), 0)
+
+	// Finalized block doesn't go backward
+	db.SetFinalizedBlock(99)
+	assert.Equal(uint64(100), db.GetFinalizedBlock())
+
+	// Now the transfer is final too
+	db.SetFinalizedBlock(101)
+	require.NoError(db.PlayAzimuthLogs(context.Background(), nil))
+	p, is_ok = db.GetPoint(AzimuthNumber(5))
+	require.True(is_ok)
+	assert.Equal(address_a, p.OwnerAddress)
+	assert.Len(db.GetPendingEvents(), 0)
+}
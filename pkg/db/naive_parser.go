@@ -2,6 +2,7 @@ package db
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/binary"
 	"errors"
@@ -59,7 +60,7 @@ type NaiveTx struct {
 // 1. Fetch the source ship+proxy's address and nonce
 // 2. Prepare data by concatenating:
 //  1. "UrbitIDV1Chain" (14 bytes)
-//  2. "1337"           (4 bytes)
+//  2. chain ID         (unknown bytes; decimal, e.g. "1" on mainnet)
 //  3. ":"              (1 byte)
 //  4. proxy nonce      (4 bytes)
 //  5. tx.TxRawData        (unknown bytes)
@@ -73,8 +74,8 @@ type NaiveTx struct {
 // 5. Recover public key: pubkey, err := crypto.SigToPub(hash, tx.Signature)
 // 6. Derive address from public key: address := crypto.PubkeyToAddress(*pubKey)
 // 7. Return address == source ship proxy's address
-func (tx NaiveTx) VerifySignature(source_ship_point Point) bool {
-	var eth_chain_id = []byte("1") // Ethereum Mainnet chain ID
+func (tx NaiveTx) VerifySignature(source_ship_point Point, chain_id uint64) bool {
+	var eth_chain_id = []byte(fmt.Sprint(chain_id)) // e.g., "1" for mainnet; see `Network`
 	var urbit_chain_id = []byte("UrbitIDV1Chain")
 
 	// Get the appropriate proxy address and nonce
@@ -166,38 +167,74 @@ func (tx NaiveTx) VerifySignature(source_ship_point Point) bool {
 // address and incorrectly consider the L2 tx invalid.
 //
 // So L1 and L2 txs have to actually be processed in order, interleaving between the two.
-func (db *DB) PlayNaiveLogs() {
+//
+// Each event is played in its own transaction.  If `ctx` gets cancelled, it stops after the current
+// event and returns the context's error; playing again picks up where it left off.
+//
+// A Batch event whose call data is missing would get played as an empty batch (see
+// `GetBatchesMissingCallData`), so it stops there, having played everything before it, and returns
+// ErrMissingCallData.  That's safe, since events get played strictly in order: everything before it
+// ends up the same as if the call data had been there all along, and nothing after it gets played.
+// Once it's repaired, playing again picks up from it.
+func (db *DB) PlayNaiveLogs(ctx context.Context, progress ProgressReporter) error {
+	naive_address := db.GetContractByName("Naive").Address
 	var events []EthereumEventLog
 	for {
 		err := db.DB.Select(&events, `
 		    select rowid, block_number, block_hash, tx_hash, log_index, contract_address, topic0, topic1,
-		            topic2, data, is_processed from ethereum_events
-		     where is_processed = 0
+		            topic2, topic3, data, is_processed, is_call_data_missing from ethereum_events
+		     where is_processed = 0 and block_number <= (select block_number from finalized_block)
 		  order by block_number, log_index asc
 		`)
 		if err != nil {
 			panic(err)
 		} else if len(events) == 0 {
 			// No unprocessed logs left; we're finished
-			break
+			return nil
 		}
-		fmt.Printf("Applying events %d to %d\n", events[0].ID, events[len(events)-1].ID)
 		for i, e := range events {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			if i%1000 == 0 {
-				fmt.Printf("On event %d of %d\n", i, len(events))
+				ReportProgress(progress, Progress{
+					Task:         "Playing logs",
+					Done:         uint64(i),
+					Total:        uint64(len(events)),
+					CurrentBlock: e.BlockNumber,
+				})
 			}
-			if e.ContractAddress == common.HexToAddress("eb70029cfb3c53c778eaf68cd28de725390a1fe9") {
+			if e.ContractAddress == naive_address && e.IsCallDataMissing {
+				ReportProgress(progress, Progress{
+					Task:         "Playing logs",
+					Done:         uint64(i),
+					Total:        uint64(len(events)),
+					CurrentBlock: e.BlockNumber,
+				})
+				return fmt.Errorf("%w (%d of them); stopped at the first one, in block %d (tx %s)", ErrMissingCallData,
+					db.CountUnplayedBatchesMissingCallData(), e.BlockNumber, e.TxHash.Hex())
+			} else if e.ContractAddress == naive_address {
 				// Naive
-				db.ApplyBatchEvent(e)
+				db.ApplyBatchEvent(e, progress)
+			} else if e.Topic0 == CLAIM_ADDED || e.Topic0 == CLAIM_REMOVED {
+				db.ApplyClaimsEvent(e)
 			} else {
 				// Azimuth
 				db.ApplyEventEffects([]EthereumEventLog{e})
 			}
 		}
+		ReportProgress(progress, Progress{
+			Task:         "Playing logs",
+			Done:         uint64(len(events)),
+			Total:        uint64(len(events)),
+			CurrentBlock: events[len(events)-1].BlockNumber,
+		})
 	}
 }
 
-func (db *DB) ApplyBatchEvent(event EthereumEventLog) {
+// Play a Batch event's L2 transactions.  Ones that get skipped (bad signature, or not allowed) are
+// reported to `progress` (which can be nil).
+func (db *DB) ApplyBatchEvent(event EthereumEventLog, progress ProgressReporter) {
 	if event.Topic0 != BATCH {
 		panic(event)
 	}
@@ -207,7 +244,9 @@ func (db *DB) ApplyBatchEvent(event EthereumEventLog) {
 		panic(err)
 	}
 	dbtx := Tx{t}
+	is_reorgable := is_in_reorg_window(event.BlockNumber, dbtx.GetNewestEventBlockNum())
 
+	chain_id := dbtx.GetChainID()
 	naive_txs := ParseNaiveBatch(event.Data, event.ID)
 	for _, tx := range naive_txs {
 		var p Point
@@ -217,14 +256,35 @@ func (db *DB) ApplyBatchEvent(event EthereumEventLog) {
 		}
 
 		// Check signature
-		if !tx.VerifySignature(p) {
-			fmt.Printf("\n>>>   Signature failed to verify in batch (%d, %d): %#v\n", event.BlockNumber, event.LogIndex, tx)
+		if !tx.VerifySignature(p, chain_id) {
+			ReportProgress(progress, Progress{
+				Task:         "Playing logs",
+				CurrentBlock: event.BlockNumber,
+				Note: fmt.Sprintf("ignoring L2 tx %d in batch (%d, %d): signature failed to verify",
+					tx.IntraLogIndex, event.BlockNumber, event.LogIndex),
+			})
 			continue
 		}
 
 		// Get effects
-		effects, diffs := tx.Effects(dbtx)
+		effects, diffs, ignored_because := tx.Effects(dbtx)
+		if ignored_because != "" {
+			ReportProgress(progress, Progress{
+				Task:         "Playing logs",
+				CurrentBlock: event.BlockNumber,
+				Note: fmt.Sprintf("ignoring L2 tx %d in batch (%d, %d): %s",
+					tx.IntraLogIndex, event.BlockNumber, event.LogIndex, ignored_because),
+			})
+		}
 		for _, q := range effects {
+			if is_reorgable {
+				// Keep the point's previous state around in case this batch gets reorged out
+				p, is_ok := q.BindValues.(Point)
+				if !is_ok {
+					panic(q.BindValues)
+				}
+				dbtx.SavePointSnapshot(event.ID, p.Number)
+			}
 			_, err = dbtx.NamedExec(q.SQL, q.BindValues)
 			if err != nil {
 				fmt.Printf("%q; %#v\n", q.SQL, q.BindValues)
@@ -382,7 +442,10 @@ func ParseNaiveBatch(batch []byte, ethereum_event_log_id uint64) []NaiveTx {
 	return ret
 }
 
-func (tx NaiveTx) Effects(dbtx Tx) ([]Query, []AzimuthDiff) {
+// What an L2 transaction does: the queries that apply it, and the diffs it makes.  If it can't be
+// applied (e.g., the proxy that signed it isn't allowed to do that), it only uses up its nonce, and
+// the string says why; otherwise the string is empty.
+func (tx NaiveTx) Effects(dbtx Tx) ([]Query, []AzimuthDiff, string) {
 	// helper func
 	get_point := func(n AzimuthNumber) (ret Point) {
 		err := dbtx.Get(&ret, `select * from points where azimuth_number = ?`, n)
@@ -395,6 +458,7 @@ func (tx NaiveTx) Effects(dbtx Tx) ([]Query, []AzimuthDiff) {
 
 	ret := []Query{}
 	diffs := []AzimuthDiff{}
+	ignored_because := ""
 
 	// Increment the appropriate nonce, even if the transaction doesn't apply properly
 	// If the raw-tx parses properly, then we want to avoid people re-broadcasting it
@@ -421,11 +485,11 @@ func (tx NaiveTx) Effects(dbtx Tx) ([]Query, []AzimuthDiff) {
 		// 1. Assert SourceShip is on L2
 		// 2. Assert SourceProxyType is permitted, either "owner" or "transfer proxy"
 		if p.Dominion != 2 {
-			fmt.Printf("Ignoring tx: source is not an L2 ship\n")
+			ignored_because = "source is not an L2 ship"
 			break
 		}
 		if tx.SourceProxyType != PROXY_OWNER && tx.SourceProxyType != PROXY_TRANSFER {
-			fmt.Printf("Ignoring tx: source proxy is not authorized to transfer\n")
+			ignored_because = "source proxy is not authorized to transfer"
 			break
 		}
 
@@ -501,17 +565,17 @@ func (tx NaiveTx) Effects(dbtx Tx) ([]Query, []AzimuthDiff) {
 		// TargetShip is the ship getting spawned; TargetAddress is who will be the new owner
 		// 3. Assert the transaction's SourceShip (sender) is the natural parent of TargetShip
 		if tx.SourceShip != tx.TargetShip.Parent() {
-			fmt.Printf("Ignoring tx: source is not target's parent to spawn it\n")
+			ignored_because = "source is not target's parent to spawn it"
 			break
 		}
 		// 2. Assert tx.SourceShip is on L2 or Spawn dominion
 		if p.Dominion != 2 && p.Dominion != 3 {
-			fmt.Printf("Ignoring tx: source ship is on L1\n")
+			ignored_because = "source ship is on L1"
 			break
 		}
 		// 4. Assert the SourceProxyType is permitted, either "owner" or "spawn proxy"
 		if tx.SourceProxyType != PROXY_OWNER && tx.SourceProxyType != PROXY_SPAWN {
-			fmt.Printf("Ignoring tx: source proxy is not authorized to spawn\n")
+			ignored_because = "source proxy is not authorized to spawn"
 			break
 		}
 		// 5. Assert the TargetShip isn't spawned yet (not in points map, in naive.hoon)
@@ -519,7 +583,7 @@ func (tx NaiveTx) Effects(dbtx Tx) ([]Query, []AzimuthDiff) {
 		err := dbtx.Get(&target, `select * from points where azimuth_number = ?`, tx.TargetShip)
 		if err == nil {
 			// Row found; point already exists
-			fmt.Printf("Ignoring tx: target ship is already spawned\n")
+			ignored_because = "target ship is already spawned"
 			break
 		} else if !errors.Is(err, sql.ErrNoRows) {
 			// Unexpected error
@@ -562,12 +626,12 @@ func (tx NaiveTx) Effects(dbtx Tx) ([]Query, []AzimuthDiff) {
 	case OP_CONFIGURE_KEYS:
 		// 1. Assert SourceShip is on L2
 		if p.Dominion != 2 {
-			fmt.Printf("Ignoring tx: source ship is not on L2\n")
+			ignored_because = "source ship is not on L2"
 			break
 		}
 		// 2. Assert SourceProxyType is permitted, either "owner" or "management proxy"
 		if tx.SourceProxyType != PROXY_OWNER && tx.SourceProxyType != PROXY_MANAGEMENT {
-			fmt.Printf("Ignoring tx: source proxy is not authorized\n")
+			ignored_because = "source proxy is not authorized"
 			break
 		}
 
@@ -619,13 +683,13 @@ func (tx NaiveTx) Effects(dbtx Tx) ([]Query, []AzimuthDiff) {
 	case OP_ESCAPE:
 		// 1. Assert SourceProxyType is permitted, either "owner" or "management"
 		if tx.SourceProxyType != PROXY_OWNER && tx.SourceProxyType != PROXY_MANAGEMENT {
-			fmt.Printf("Ignoring tx: source proxy is not authorized\n")
+			ignored_because = "source proxy is not authorized"
 			break
 		}
 		// 2. Assert ranks match: TargetShip should be 1 rank higher than SourceShip
 		if tx.TargetShip.Rank()+1 != tx.SourceShip.Rank() {
-			fmt.Printf("Ignoring tx in event log %d: rank mismatch (%d/%d to %d/%d)\n",
-				tx.EthereumEventLogID, tx.TargetShip, tx.TargetShip.Rank(), tx.SourceShip, tx.SourceShip.Rank(),
+			ignored_because = fmt.Sprintf("rank mismatch (%d/%d to %d/%d)",
+				tx.TargetShip, tx.TargetShip.Rank(), tx.SourceShip, tx.SourceShip.Rank(),
 			)
 			break
 		}
@@ -652,7 +716,7 @@ func (tx NaiveTx) Effects(dbtx Tx) ([]Query, []AzimuthDiff) {
 	case OP_CANCEL_ESCAPE:
 		// 1. Assert SourceProxyType is permitted, either "owner" or "management"
 		if tx.SourceProxyType != PROXY_OWNER && tx.SourceProxyType != PROXY_MANAGEMENT {
-			fmt.Printf("Ignoring tx: source proxy is not authorized\n")
+			ignored_because = "source proxy is not authorized"
 			break
 		}
 		// 2. Apply escape cancellation
@@ -678,7 +742,7 @@ func (tx NaiveTx) Effects(dbtx Tx) ([]Query, []AzimuthDiff) {
 	case OP_ADOPT:
 		// 1. Assert SourceProxyType is permitted, either "owner" or "management"
 		if tx.SourceProxyType != PROXY_OWNER && tx.SourceProxyType != PROXY_MANAGEMENT {
-			fmt.Printf("Ignoring tx: source proxy is not authorized\n")
+			ignored_because = "source proxy is not authorized"
 			break
 		}
 
@@ -686,8 +750,8 @@ func (tx NaiveTx) Effects(dbtx Tx) ([]Query, []AzimuthDiff) {
 
 		// 2. Assert tx.TargetShip has requested escape to tx.SourceShip
 		if target.EscapeRequestedTo != tx.SourceShip {
-			fmt.Printf("Ignoring tx in event log %d: target ship %d wasn't trying to escape to %d\n",
-				tx.EthereumEventLogID, tx.TargetShip, tx.SourceShip,
+			ignored_because = fmt.Sprintf("target ship %d wasn't trying to escape to %d",
+				tx.TargetShip, tx.SourceShip,
 			)
 			break
 		}
@@ -718,7 +782,7 @@ func (tx NaiveTx) Effects(dbtx Tx) ([]Query, []AzimuthDiff) {
 	case OP_REJECT:
 		// 1. Assert SourceProxyType is permitted, either "owner" or "management"
 		if tx.SourceProxyType != PROXY_OWNER && tx.SourceProxyType != PROXY_MANAGEMENT {
-			fmt.Printf("Ignoring tx: source proxy is not authorized\n")
+			ignored_because = "source proxy is not authorized"
 			break
 		}
 
@@ -726,8 +790,8 @@ func (tx NaiveTx) Effects(dbtx Tx) ([]Query, []AzimuthDiff) {
 
 		// 2. Assert tx.TargetShip has requested escape to tx.SourceShip
 		if target.EscapeRequestedTo != tx.SourceShip {
-			fmt.Printf("Ignoring tx in event log %d: target ship %d wasn't trying to escape to %d\n",
-				tx.EthereumEventLogID, tx.TargetShip, tx.SourceShip,
+			ignored_because = fmt.Sprintf("target ship %d wasn't trying to escape to %d",
+				tx.TargetShip, tx.SourceShip,
 			)
 			break
 		}
@@ -754,7 +818,7 @@ func (tx NaiveTx) Effects(dbtx Tx) ([]Query, []AzimuthDiff) {
 	case OP_DETACH: // Source ship (star) disavows target ship (planet)
 		// 1. Assert SourceProxyType is permitted, either "owner" or "management"
 		if tx.SourceProxyType != PROXY_OWNER && tx.SourceProxyType != PROXY_MANAGEMENT {
-			fmt.Printf("Ignoring tx: source proxy is not authorized\n")
+			ignored_because = "source proxy is not authorized"
 			break
 		}
 
@@ -762,8 +826,8 @@ func (tx NaiveTx) Effects(dbtx Tx) ([]Query, []AzimuthDiff) {
 
 		// 2. Assert source ship is currently the target's sponsor
 		if tx.SourceShip != target.Sponsor {
-			fmt.Printf("Ignoring tx in event log %d: source ship (%d) isn't target's (%d) sponsor\n",
-				tx.EthereumEventLogID, tx.SourceShip, tx.TargetShip,
+			ignored_because = fmt.Sprintf("source ship (%d) isn't target's (%d) sponsor",
+				tx.SourceShip, tx.TargetShip,
 			)
 			break
 		}
@@ -790,12 +854,12 @@ func (tx NaiveTx) Effects(dbtx Tx) ([]Query, []AzimuthDiff) {
 	case OP_SET_MANAGEMENT_PROXY:
 		// 1. Assert SourceShip is on L2
 		if p.Dominion != 2 {
-			fmt.Printf("Ignoring tx: source proxy is not on L2\n")
+			ignored_because = "source proxy is not on L2"
 			break
 		}
 		// 2. Assert SourceProxyType is permitted, either "owner" or "management"
 		if tx.SourceProxyType != PROXY_OWNER && tx.SourceProxyType != PROXY_MANAGEMENT {
-			fmt.Printf("Ignoring tx: source proxy is not authorized\n")
+			ignored_because = "source proxy is not authorized"
 			break
 		}
 
@@ -820,12 +884,12 @@ func (tx NaiveTx) Effects(dbtx Tx) ([]Query, []AzimuthDiff) {
 	case OP_SET_SPAWN_PROXY:
 		// 1. Assert SourceShip is on L2 or "Spawn" dominion
 		if p.Dominion != 2 && p.Dominion != 3 {
-			fmt.Printf("Ignoring tx: source proxy is not on L2\n")
+			ignored_because = "source proxy is not on L2"
 			break
 		}
 		// 2. Assert SourceProxyType is permitted, either "owner" or "spawn"
 		if tx.SourceProxyType != PROXY_OWNER && tx.SourceProxyType != PROXY_SPAWN {
-			fmt.Printf("Ignoring tx: source proxy is not authorized\n")
+			ignored_because = "source proxy is not authorized"
 			break
 		}
 		// 3. Assert SourceShip is either a star or a galaxy (planets can't spawn)
@@ -853,12 +917,12 @@ func (tx NaiveTx) Effects(dbtx Tx) ([]Query, []AzimuthDiff) {
 	case OP_SET_TRANSFER_PROXY:
 		// 1. Assert SourceShip is on L2
 		if p.Dominion != 2 {
-			fmt.Printf("Ignoring tx: source proxy is not on L2\n")
+			ignored_because = "source proxy is not on L2"
 			break
 		}
 		// 2. Assert SourceProxyType is permitted, either "owner" or "transfer"
 		if tx.SourceProxyType != PROXY_OWNER && tx.SourceProxyType != PROXY_TRANSFER {
-			fmt.Printf("Ignoring tx: source proxy is not authorized\n")
+			ignored_because = "source proxy is not authorized"
 			break
 		}
 		// 3. Update the proxy
@@ -882,5 +946,5 @@ func (tx NaiveTx) Effects(dbtx Tx) ([]Query, []AzimuthDiff) {
 	default:
 		panic(tx.Opcode)
 	}
-	return ret, diffs
+	return ret, diffs, ignored_because
 }
@@ -248,7 +248,9 @@ func TestCheckNaiveSignatures(t *testing.T) {
 		},
 	}
 	for _, tc := range test_cases {
-		rslt := tc.Tx.VerifySignature(tc.Sender)
+		rslt := tc.Tx.VerifySignature(tc.Sender, MAINNET.ChainID)
 		assert.True(rslt)
+		// Signed for mainnet, so not valid on any other chain
+		assert.False(tc.Tx.VerifySignature(tc.Sender, 11155111))
 	}
 }
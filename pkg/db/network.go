@@ -0,0 +1,146 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var ErrInvalidNetwork = errors.New("invalid network profile")
+
+// A chain with Azimuth deployed on it (e.g., mainnet, a testnet, or a local dev chain), and where
+// its contracts are.  Chosen when the DB is created, and stored in it (the `network` table, and the
+// `contracts` table).
+type Network struct {
+	Name      string            `json:"name" db:"name"`
+	ChainID   uint64            `json:"chain_id" db:"chain_id"` // Goes into L2 transactions' signatures, too
+	Contracts []NetworkContract `json:"contracts"`
+}
+
+type NetworkContract struct {
+	Name       string         `json:"name" db:"name"`
+	Address    common.Address `json:"address" db:"address"`
+	StartBlock uint64         `json:"start_block" db:"start_block"` // The block it was deployed in
+}
+
+// The contracts a network can have.  Azimuth and Naive are required; the others can be left out,
+// e.g., on a local chain that only has Azimuth and Naive.  Ecliptics aren't listed, since they're
+// found from the Azimuth logs (see `RegisterEclipticContracts`).
+var NETWORK_CONTRACT_NAMES = []string{
+	"Azimuth", "Naive", "Polls", "Claims", "LinearStarRelease", "ConditionalStarRelease", "DelegatedSending",
+}
+
+// Mainnet's contracts are the ones in schema.sql
+var MAINNET = Network{Name: "mainnet", ChainID: 1}
+
+// Load a network profile from a JSON file, e.g.:
+//
+//	{"name": "anvil", "chain_id": 31337, "contracts": [
+//	    {"name": "Azimuth", "address": "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", "start_block": 1},
+//	    {"name": "Naive", "address": "0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", "start_block": 2}
+//	]}
+func LoadNetwork(path string) (Network, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Network{}, fmt.Errorf("reading network profile: %w", err)
+	}
+	var ret Network
+	if err := json.Unmarshal(data, &ret); err != nil {
+		return Network{}, fmt.Errorf("%w: %s: %w", ErrInvalidNetwork, path, err)
+	}
+	if err := ret.check(); err != nil {
+		return Network{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return ret, nil
+}
+
+// Make sure a (non-mainnet) profile has everything it needs
+func (n Network) check() error {
+	if n.Name == "" || n.ChainID == 0 {
+		return fmt.Errorf("%w: needs a name and a chain ID", ErrInvalidNetwork)
+	}
+	if n.Name == MAINNET.Name {
+		return fmt.Errorf("%w: %q is the built-in one", ErrInvalidNetwork, n.Name)
+	}
+	names := []string{}
+	for _, c := range n.Contracts {
+		if !slices.Contains(NETWORK_CONTRACT_NAMES, c.Name) {
+			return fmt.Errorf("%w: unknown contract %q", ErrInvalidNetwork, c.Name)
+		}
+		if slices.Contains(names, c.Name) {
+			return fmt.Errorf("%w: contract %q is listed twice", ErrInvalidNetwork, c.Name)
+		}
+		names = append(names, c.Name)
+	}
+	if !slices.Contains(names, "Azimuth") || !slices.Contains(names, "Naive") {
+		return fmt.Errorf("%w: needs at least the Azimuth and Naive contracts", ErrInvalidNetwork)
+	}
+	return nil
+}
+
+// Create a new DB for a network other than mainnet.  Its contracts replace mainnet's, and any that
+// it doesn't have are left out.
+func DBCreateForNetwork(path string, n Network) (DB, error) {
+	if n.Name == MAINNET.Name {
+		return DBCreate(path)
+	}
+	if err := n.check(); err != nil {
+		return DB{}, err
+	}
+	db, err := DBCreate(path)
+	if err != nil {
+		return DB{}, err
+	}
+
+	tx := db.DB.MustBegin()
+	// The event types point at the contracts by address, so they have to move along with them
+	tx.MustExec(`pragma defer_foreign_keys = on`)
+	for _, name := range NETWORK_CONTRACT_NAMES {
+		var old_address common.Address
+		if err := tx.Get(&old_address, `select address from contracts where name = ?`, name); err != nil {
+			panic(err)
+		}
+		i := slices.IndexFunc(n.Contracts, func(c NetworkContract) bool { return c.Name == name })
+		if i == -1 {
+			tx.MustExec(`delete from event_types where contract_address = ?`, old_address)
+			tx.MustExec(`delete from contracts where name = ?`, name)
+			continue
+		}
+		c := n.Contracts[i]
+		tx.MustExec(`update contracts set address = ?, start_block = ? where name = ?`, c.Address, c.StartBlock, name)
+		tx.MustExec(`update event_types set contract_address = ? where contract_address = ?`, c.Address, old_address)
+	}
+	tx.MustExec(`update network set name = ?, chain_id = ?`, n.Name, n.ChainID)
+	if err := tx.Commit(); err != nil {
+		panic(err)
+	}
+	return db, nil
+}
+
+// Get the network this DB is for, with its contracts (not including Ecliptics)
+func (db *DB) GetNetwork() Network {
+	var ret Network
+	err := db.DB.Get(&ret, `select name, chain_id from network`)
+	if err != nil {
+		panic(err)
+	}
+	err = db.DB.Select(&ret.Contracts, `
+		select name, address, start_block from contracts where name != 'Ecliptic' order by rowid`)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// Get the chain ID of the network this DB is for
+func (tx Tx) GetChainID() uint64 {
+	var ret uint64
+	if err := tx.Get(&ret, `select chain_id from network`); err != nil {
+		panic(err)
+	}
+	return ret
+}
@@ -0,0 +1,96 @@
+package db_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "go-azimuth/pkg/db"
+)
+
+func TestLoadNetwork(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir := t.TempDir()
+	write := func(contents string) string {
+		path := filepath.Join(dir, "network.json")
+		require.NoError(os.WriteFile(path, []byte(contents), 0644))
+		return path
+	}
+
+	n, err := LoadNetwork(write(`{"name": "devnet", "chain_id": 31337, "contracts": [
+		{"name": "Azimuth", "address": "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", "start_block": 10},
+		{"name": "Naive", "address": "0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", "start_block": 20}
+	]}`))
+	require.NoError(err)
+	assert.Equal("devnet", n.Name)
+	assert.Equal(uint64(31337), n.ChainID)
+	assert.Equal(NetworkContract{Name: "Naive", Address: common.HexToAddress("bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"),
+		StartBlock: 20}, n.Contracts[1])
+
+	for _, bad := range []string{
+		`{"name": "devnet", "chain_id": 31337, "contracts": [{"name": "Azimuth"}]}`, // No Naive
+		`{"name": "devnet", "contracts": [{"name": "Azimuth"}, {"name": "Naive"}]}`, // No chain ID
+		`{"name": "mainnet", "chain_id": 1, "contracts": [{"name": "Azimuth"}, {"name": "Naive"}]}`,
+		`{"name": "devnet", "chain_id": 31337, "contracts": [{"name": "Azimuth"}, {"name": "Naive"}, {"name": "Ecliptic"}]}`,
+		`{"name": "devnet"`,
+	} {
+		_, err := LoadNetwork(write(bad))
+		assert.ErrorIs(err, ErrInvalidNetwork, bad)
+	}
+}
+
+func TestCreateDBForNetwork(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	azimuth := common.HexToAddress("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	naive := common.HexToAddress("bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+	network := Network{Name: "devnet", ChainID: 31337, Contracts: []NetworkContract{
+		{Name: "Azimuth", Address: azimuth, StartBlock: 10},
+		{Name: "Naive", Address: naive, StartBlock: 20},
+	}}
+	path := filepath.Join(t.TempDir(), "devnet.db")
+	db, err := DBCreateForNetwork(path, network)
+	require.NoError(err)
+	assert.Equal(network, db.GetNetwork())
+
+	// The other contracts are gone, along with their event types
+	assert.Len(db.GetLogContracts(), 2)
+	var num_event_types int
+	require.NoError(db.DB.Get(&num_event_types, `select count(*) from event_types where contract_address not in (?, ?)`,
+		azimuth, naive))
+	assert.Equal(0, num_event_types)
+
+	// It's stored in the DB
+	db, err = DBConnect(path)
+	require.NoError(err)
+	assert.Equal(network, db.GetNetwork())
+	_, err = DBCreateForNetwork(path, network)
+	assert.ErrorIs(err, ErrTargetExists)
+
+	// Events from its Azimuth contract get played
+	galaxy := common.HexToHash("0000000000000000000000000000000000000000000000000000000000000005")
+	db.SaveFetchedEvents(db.GetContractByName("Azimuth").ID, []EthereumEventLog{
+		{BlockNumber: 15, BlockHash: common.Hash{15}, ContractAddress: azimuth, Topic0: ACTIVATED, Topic1: galaxy,
+			Data: []byte{}},
+	}, nil, nil, BlockRange{FromBlock: 10, ToBlock: 15})
+	db.DB.MustExec(`update finalized_block set block_number = 15`)
+	require.NoError(db.PlayAzimuthLogs(context.Background(), nil))
+	p, is_found := db.GetPoint(AzimuthNumber(5))
+	require.True(is_found)
+	assert.True(p.IsActive)
+
+	// Mainnet is the default
+	db, err = DBCreate(":memory:")
+	require.NoError(err)
+	assert.Equal("mainnet", db.GetNetwork().Name)
+	assert.Equal(uint64(1), db.GetNetwork().ChainID)
+	assert.Len(db.GetNetwork().Contracts, 7)
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// What a point would look like after some L2 transactions that aren't in a Batch yet (e.g., the
+// ones in a roller's queue), and the diffs they'd make to it.  Returns false if the point wouldn't
+// exist.
+//
+// The transactions get applied in order, on top of the current state, the same way as when
+// playing a Batch.  Nothing is saved: it all happens in a DB transaction that gets rolled back.
+// Transactions whose signatures don't check out are skipped, like they would be on L1.
+func (db *DB) GetPendingPoint(azimuth_number AzimuthNumber, txs []NaiveTx) (Point, bool, []AzimuthDiff) {
+	ret, is_ok, diffs := db.apply_pending_txs(azimuth_number, txs)
+	if !is_ok {
+		return Point{}, false, diffs
+	}
+	// L2 transactions don't touch these
+	ret.Claims = db.GetClaims(azimuth_number)
+	if lockup, is_locked := db.GetLockup(ret); is_locked {
+		ret.Lockup = &lockup
+	}
+	return ret, true, diffs
+}
+
+func (db *DB) apply_pending_txs(azimuth_number AzimuthNumber, txs []NaiveTx) (Point, bool, []AzimuthDiff) {
+	t, err := db.DB.Beginx()
+	if err != nil {
+		panic(err)
+	}
+	dbtx := Tx{t}
+	defer func() {
+		if err := dbtx.Rollback(); err != nil {
+			panic(err)
+		}
+	}()
+
+	chain_id := dbtx.GetChainID()
+	ret_diffs := []AzimuthDiff{}
+	for _, tx := range txs {
+		var p Point
+		err := dbtx.Get(&p, `select * from points where azimuth_number = ?`, tx.SourceShip)
+		if errors.Is(err, sql.ErrNoRows) {
+			// Nobody could have signed it
+			continue
+		} else if err != nil {
+			panic(err)
+		}
+		if !tx.VerifySignature(p, chain_id) {
+			continue
+		}
+
+		// Ones that wouldn't apply just use up their nonce, same as on L1; nothing to say about them
+		effects, diffs, _ := tx.Effects(dbtx)
+		for _, q := range effects {
+			if _, err := dbtx.NamedExec(q.SQL, q.BindValues); err != nil {
+				panic(err)
+			}
+		}
+		for _, d := range diffs {
+			if d.AzimuthNumber == azimuth_number {
+				ret_diffs = append(ret_diffs, d)
+			}
+		}
+	}
+
+	var ret Point
+	err = dbtx.Get(&ret, `select * from points where azimuth_number = ?`, azimuth_number)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Point{}, false, ret_diffs
+	} else if err != nil {
+		panic(err)
+	}
+	return ret, true, ret_diffs
+}
@@ -0,0 +1,69 @@
+package db_test
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "go-azimuth/pkg/db"
+)
+
+func TestPendingPoint(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	db, err := DBCreate(":memory:")
+	require.NoError(err)
+	owner := common.HexToAddress("baD132Bf9c1269ee4fBc3AFfC537d3De3Adb169B")
+	db.DB.MustExec(`insert into points (azimuth_number, dominion, owner_address, owner_nonce) values (64490, 2, ?, 1)`,
+		owner)
+
+	// A star spawning a planet on L2, signed but not submitted yet
+	spawn := ParseNaiveBatch(hex_to_bytes(
+		"bad132bf9c1269ee4fbc3affc537d3de3adb169b0001fbea010000fbea00"+
+			"cc9dba6a6c90616dac53c1cff8e224e02ce9601fcec85c68f73a77bb5970354a523842d5335d847"+
+			"f0f76c95b7cbb735f0847b0e22c9040da1dd1ed3ac4c2b09900"), 0)
+	require.Len(spawn, 1)
+	require.Equal(uint(OP_SPAWN), spawn[0].Opcode)
+
+	// The second copy has a stale nonce, so it gets skipped
+	p, is_ok, diffs := db.GetPendingPoint(AzimuthNumber(130026), []NaiveTx{spawn[0], spawn[0]})
+	require.True(is_ok)
+	assert.True(p.HasSponsor)
+	assert.Equal(AzimuthNumber(64490), p.Sponsor)
+	assert.Equal(2, p.Dominion)
+	require.Len(diffs, 1)
+	assert.Equal(DIFF_SPAWNED, diffs[0].Operation)
+
+	// Nothing actually changed
+	_, is_ok = db.GetPoint(AzimuthNumber(130026))
+	assert.False(is_ok)
+	star, is_ok := db.GetPoint(AzimuthNumber(64490))
+	require.True(is_ok)
+	assert.Equal(uint32(1), star.OwnerNonce)
+
+	// Nobody's spawning this one
+	_, is_ok, diffs = db.GetPendingPoint(AzimuthNumber(130027), spawn)
+	assert.False(is_ok)
+	assert.Len(diffs, 0)
+
+	// Not allowed (the star is back on L1), so it only uses up the nonce.  Nothing gets printed, since
+	// `azm pending`'s output has to be valid JSON.
+	db.DB.MustExec(`update points set dominion = 1 where azimuth_number = 64490`)
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	require.NoError(err)
+	os.Stdout = w
+	_, is_ok, diffs = db.GetPendingPoint(AzimuthNumber(130026), spawn)
+	os.Stdout = stdout
+	require.NoError(w.Close())
+	output, err := io.ReadAll(r)
+	require.NoError(err)
+	assert.False(is_ok)
+	assert.Len(diffs, 0)
+	assert.Empty(string(output))
+}
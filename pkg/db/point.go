@@ -74,6 +74,11 @@ type Point struct {
 	Sponsor           AzimuthNumber `db:"sponsor"`
 	IsEscapeRequested bool          `db:"is_escape_requested"`
 	EscapeRequestedTo AzimuthNumber `db:"escape_requested_to"`
+
+	Claims []Claim `db:"-"` // From the Claims contract; only filled in by `GetPoint`
+	// If the owner is a star release contract, who the point will be released to, and when.  Only
+	// filled in by `GetPoint`.
+	Lockup *Lockup `db:"-" json:",omitempty"`
 }
 
 func (p Point) MarshalJSON() ([]byte, error) {
@@ -102,6 +107,10 @@ func (db DB) GetPoint(azimuth_number AzimuthNumber) (Point, bool) {
 	} else if err != nil {
 		panic(err)
 	}
+	ret.Claims = db.GetClaims(azimuth_number)
+	if lockup, is_locked := db.GetLockup(ret); is_locked {
+		ret.Lockup = &lockup
+	}
 	return ret, true
 }
 
@@ -120,11 +129,15 @@ type PointHistory struct {
 	ID               uint64        `db:"rowid"`
 	ContractName     string        `db:"contract"`
 	TxHash           string        `db:"tx_hash"`
+	BlockNumber      uint64        `db:"block_number"`
+	Timestamp        uint64        `db:"timestamp"` // Unix seconds; 0 if the block's time isn't known
 	SourceEventLogID uint64        `db:"source_event_log_id"`
 	IntraLogIndex    uint64        `db:"intra_log_index"`
 	AzimuthNumber    AzimuthNumber `db:"azimuth_number"`
 	OperationName    string        `db:"operation"`
 	HexData          string        `db:"hex_data"`
+	Sender           string        `db:"sender"`      // Who sent the L1 transaction; "" if unknown
+	SenderRole       string        `db:"sender_role"` // e.g., "owner"; "" if unknown
 }
 
 func (db DB) GetEventsForPoint(azimuth_number AzimuthNumber) (ret []PointHistory, is_ok bool) {
@@ -0,0 +1,145 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// How long a poll stays open for voting, in seconds.  This is what the Polls contract was deployed
+// with (30 days).  Ecliptic can change it, but that doesn't emit an event, so this assumes it never
+// has.
+const POLL_DURATION = 30 * 24 * 60 * 60
+
+// A document poll or upgrade poll, from the `polls` view
+type Poll struct {
+	ID   uint64 `db:"rowid"`
+	Kind string `db:"kind"` // "upgrade" or "document"
+	// For upgrade polls, the address of the proposed Ecliptic (20 bytes); for document polls, the
+	// hash of the document (32 bytes)
+	Proposal         []byte `db:"proposal"`
+	StartBlockNum    uint64 `db:"start_block"`
+	StartTime        uint64 `db:"start_time"` // Unix seconds; 0 if the block's timestamp wasn't fetched
+	HasMajority      bool   `db:"has_majority"`
+	MajorityBlockNum uint64 `db:"majority_block"`
+}
+
+// Whether the poll can still be voted on, as of `now` (unix seconds)
+func (p Poll) IsOpen(now uint64) bool {
+	return !p.HasMajority && p.StartTime <= now && now < p.StartTime+POLL_DURATION
+}
+
+// A galaxy's vote on a poll
+type PollVote struct {
+	ID           uint64        `db:"rowid"`
+	BlockNumber  uint64        `db:"block_number"`
+	TxHash       []byte        `db:"tx_hash"`
+	TraceAddress string        `db:"trace_address"`
+	Kind         string        `db:"kind"`
+	Proposal     []byte        `db:"proposal"`
+	Galaxy       AzimuthNumber `db:"galaxy"`
+	Vote         bool          `db:"vote"`
+}
+
+const select_polls_sql = `
+	select rowid, kind, proposal, start_block, start_time, majority_block is not null has_majority,
+	       coalesce(majority_block, 0) majority_block
+	  from polls`
+
+// Get all the polls, oldest first
+func (db *DB) GetPolls() []Poll {
+	ret := []Poll{}
+	err := db.DB.Select(&ret, select_polls_sql+` order by start_block, rowid`)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// Get the polls that can still be voted on, as of `now` (unix seconds)
+func (db *DB) GetOpenPolls(now uint64) []Poll {
+	ret := []Poll{}
+	for _, p := range db.GetPolls() {
+		if p.IsOpen(now) {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+// Get the most recent poll on a proposal.  A proposal can be polled more than once, if the first
+// poll fails.
+func (db *DB) GetLatestPoll(proposal []byte) (Poll, bool) {
+	var ret Poll
+	err := db.DB.Get(&ret, select_polls_sql+` where proposal = ? order by start_block desc, rowid desc limit 1`,
+		proposal)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Poll{}, false
+	} else if err != nil {
+		panic(err)
+	}
+	return ret, true
+}
+
+// Get the (final) votes cast in a poll, i.e., votes on its proposal after it started and before the
+// proposal got polled again
+func (db *DB) GetPollVotes(p Poll) []PollVote {
+	ret := []PollVote{}
+	err := db.DB.Select(&ret, `
+	    select rowid, block_number, tx_hash, trace_address, kind, proposal, galaxy, vote
+	      from poll_votes
+	     where kind = ? and proposal = ? and block_number >= ?
+	       and block_number < coalesce(
+	               (select min(start_block) from polls where kind = ? and proposal = ? and start_block > ?),
+	               9223372036854775807
+	           )
+	       and block_number <= (select block_number from finalized_block)
+	  order by block_number, rowid`,
+		p.Kind, p.Proposal, p.StartBlockNum, p.Kind, p.Proposal, p.StartBlockNum)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// Get how a galaxy voted in the most recent poll on a proposal.  Returns false if there's no such
+// poll, or the galaxy didn't vote in it.
+func (db *DB) GetPollVote(proposal []byte, galaxy AzimuthNumber) (PollVote, bool) {
+	p, is_ok := db.GetLatestPoll(proposal)
+	if !is_ok {
+		return PollVote{}, false
+	}
+	for _, v := range db.GetPollVotes(p) {
+		if v.Galaxy == galaxy {
+			return v, true
+		}
+	}
+	return PollVote{}, false
+}
+
+// Get the block that votes have been fetched up to
+func (db *DB) GetPollVotesFetched() uint64 {
+	var ret uint64
+	err := db.DB.Get(&ret, `select block_number from poll_votes_fetched`)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// Save the votes from a range of blocks, and mark the range as fetched, all at once
+func (db *DB) SavePollVotes(votes []PollVote, latest_block_fetched uint64) {
+	tx := db.DB.MustBegin()
+	for _, v := range votes {
+		_, err := tx.NamedExec(`
+			insert or ignore into poll_votes (block_number, tx_hash, trace_address, kind, proposal, galaxy, vote)
+			values (:block_number, :tx_hash, :trace_address, :kind, :proposal, :galaxy, :vote)`,
+			v)
+		if err != nil {
+			panic(err)
+		}
+	}
+	tx.MustExec(`update poll_votes_fetched set block_number = max(block_number, ?)`, latest_block_fetched)
+	if err := tx.Commit(); err != nil {
+		panic(err)
+	}
+}
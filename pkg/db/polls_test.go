@@ -0,0 +1,95 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "go-azimuth/pkg/db"
+)
+
+func TestPolls(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	db, err := DBCreate(":memory:")
+	require.NoError(err)
+
+	polls := common.HexToAddress("7fecab617c868bb5996d99d95200d2fa708218e4")
+	new_ecliptic := common.HexToAddress("e1e1e1e1e1e1e1e1e1e1e1e1e1e1e1e1e1e1e1e1")
+	document := common.HexToHash("d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0")
+	day := uint64(24 * 60 * 60)
+
+	// A document poll that gets a majority, and an upgrade poll that doesn't, and gets restarted
+	events := []EthereumEventLog{
+		{BlockNumber: 100, ContractAddress: polls, Topic0: DOCUMENT_POLL_STARTED, Data: document[:]},
+		{BlockNumber: 110, ContractAddress: polls, Topic0: UPGRADE_POLL_STARTED,
+			Data: common.BytesToHash(new_ecliptic[:]).Bytes()},
+		{BlockNumber: 120, ContractAddress: polls, Topic0: DOCUMENT_MAJORITY, Data: document[:]},
+		{BlockNumber: 150, ContractAddress: polls, Topic0: UPGRADE_POLL_STARTED,
+			Data: common.BytesToHash(new_ecliptic[:]).Bytes()},
+	}
+	for i := range events {
+		db.SaveEvent(&events[i])
+	}
+	db.SaveBlock(100, common.Hash{1}, 1*day)
+	db.SaveBlock(110, common.Hash{2}, 2*day)
+	db.SaveBlock(150, common.Hash{3}, 40*day)
+
+	vote := func(block_num uint64, kind string, proposal []byte, galaxy AzimuthNumber, is_yes bool) PollVote {
+		return PollVote{BlockNumber: block_num, TxHash: common.Hash{byte(block_num), byte(galaxy)}.Bytes(),
+			TraceAddress: "0", Kind: kind, Proposal: proposal, Galaxy: galaxy, Vote: is_yes}
+	}
+	votes := []PollVote{
+		vote(105, "document", document[:], 0, true),
+		vote(106, "document", document[:], 1, false),
+		vote(115, "upgrade", new_ecliptic[:], 0, false), // In the first upgrade poll
+		vote(155, "upgrade", new_ecliptic[:], 0, true),  // In the second one
+		vote(156, "upgrade", new_ecliptic[:], 2, true),
+	}
+	db.SavePollVotes(votes, 160)
+	db.SavePollVotes(votes, 160) // Should be idempotent
+	assert.Equal(uint64(160), db.GetPollVotesFetched())
+
+	// Not played yet
+	assert.Len(db.GetPolls(), 0)
+
+	db.SetFinalizedBlock(160)
+	require.NoError(db.PlayNaiveLogs(context.Background(), nil))
+	result := db.GetPolls()
+	require.Len(result, 3)
+	assert.Equal("document", result[0].Kind)
+	assert.Equal(document[:], result[0].Proposal)
+	assert.Equal(1*day, result[0].StartTime)
+	assert.True(result[0].HasMajority)
+	assert.Equal(uint64(120), result[0].MajorityBlockNum)
+	assert.Equal("upgrade", result[1].Kind)
+	assert.Equal(new_ecliptic[:], result[1].Proposal)
+	assert.False(result[1].HasMajority)
+
+	// On day 35, the first upgrade poll has expired and the second hasn't started
+	assert.Len(db.GetOpenPolls(35*day), 0)
+	open_polls := db.GetOpenPolls(41 * day)
+	require.Len(open_polls, 1)
+	assert.Equal(uint64(150), open_polls[0].StartBlockNum)
+
+	// Only votes from the latest poll count
+	assert.Len(db.GetPollVotes(result[1]), 1)
+	assert.Len(db.GetPollVotes(result[2]), 2)
+	v, is_found := db.GetPollVote(new_ecliptic[:], 0)
+	require.True(is_found)
+	assert.True(v.Vote)
+	v, is_found = db.GetPollVote(document[:], 1)
+	require.True(is_found)
+	assert.False(v.Vote)
+	_, is_found = db.GetPollVote(document[:], 2)
+	assert.False(is_found)
+
+	// Reorging out votes makes them get fetched again
+	require.NoError(db.RollBackToBlock(156, 156, nil))
+	assert.Len(db.GetPollVotes(result[2]), 1)
+	assert.Equal(uint64(155), db.GetPollVotesFetched())
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"fmt"
+)
+
+// How far along a long-running operation (fetching or playing logs) is
+type Progress struct {
+	Task string // What's being done, e.g., "Azimuth contract: fetching logs"
+	// How much is done so far, out of how much.  The unit depends on the task: blocks when fetching,
+	// events when playing.  Total is 0 if it isn't known.
+	Done  uint64
+	Total uint64
+	// The block it's up to
+	CurrentBlock uint64
+	// How many RPC credits have been used so far.  0 if the source isn't metered.
+	CreditsUsed uint64
+	// Something that came up that's worth telling the user about (e.g., a Batch event whose call data
+	// couldn't be found), rather than how far along it is.  Usually empty.
+	Note string
+}
+
+// Something that wants to know how a long-running operation is going, e.g., to print it or to show
+// it in a UI.  It's called from the goroutines doing the work (several at once, when fetching with
+// more than one worker), so it should return quickly and be safe to call concurrently.
+type ProgressReporter interface {
+	ReportProgress(p Progress)
+}
+
+// Use a plain function as a ProgressReporter
+type ProgressFunc func(p Progress)
+
+func (f ProgressFunc) ReportProgress(p Progress) {
+	f(p)
+}
+
+// Prints progress to stdout
+type PrintProgress struct{}
+
+func (PrintProgress) ReportProgress(p Progress) {
+	if p.Note != "" {
+		fmt.Printf("%s: %s\n", p.Task, p.Note)
+		return
+	}
+	// All in one write, so lines from different workers don't get mixed up
+	line := fmt.Sprintf("%s: %d", p.Task, p.Done)
+	if p.Total != 0 {
+		line += fmt.Sprintf(" of %d", p.Total)
+	}
+	line += fmt.Sprintf(" (block %d)", p.CurrentBlock)
+	if p.CreditsUsed != 0 {
+		line += fmt.Sprintf("; %d credits used", p.CreditsUsed)
+	}
+	fmt.Println(line)
+}
+
+// Report progress, if anyone's listening
+func ReportProgress(r ProgressReporter, p Progress) {
+	if r != nil {
+		r.ReportProgress(p)
+	}
+}
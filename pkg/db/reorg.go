@@ -0,0 +1,295 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// How many blocks behind the chain head a block can be and still get reorged out.  Events in this
+// window keep enough history (point snapshots) to be undone.
+//
+// Mainnet finalizes after 2 epochs (64 blocks); anything deeper than that is not a reorg, it's a
+// catastrophe.
+const REORG_WINDOW = 64
+
+var (
+	ErrReorgTooDeep = errors.New("reorg is deeper than the reorg window")
+)
+
+type PointSnapshot struct {
+	SourceEventLogID uint64 `db:"source_event_log_id"`
+	DidExist         bool   `db:"did_exist"`
+	Point
+}
+
+// Get the block number of the newest event in the DB
+func (tx Tx) GetNewestEventBlockNum() uint64 {
+	var ret uint64
+	err := tx.Get(&ret, `select coalesce(max(block_number), 0) from ethereum_events`)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// Whether an event is recent enough that it could still get reorged out.  Playing measures this from
+// the newest event, which is never past the chain head, so it keeps snapshots for at least every
+// event that `RollBackToBlock` (which measures from the head) might need.
+func is_in_reorg_window(block_num uint64, newest_block_num uint64) bool {
+	return block_num+REORG_WINDOW > newest_block_num
+}
+
+// Save the current state of a point, before the event with ID `event_id` modifies it.  Only the
+// first snapshot for each (event, point) counts; later ones are ignored.
+func (tx Tx) SavePointSnapshot(event_id uint64, azimuth_number AzimuthNumber) {
+	snapshot := PointSnapshot{SourceEventLogID: event_id, DidExist: true}
+	err := tx.Get(&snapshot.Point, `select * from points where azimuth_number = ?`, azimuth_number)
+	if errors.Is(err, sql.ErrNoRows) {
+		snapshot.DidExist = false
+		snapshot.Point = Point{Number: azimuth_number, Dominion: 1, EncryptionKey: []byte{}, AuthKey: []byte{}}
+	} else if err != nil {
+		panic(err)
+	}
+
+	_, err = tx.NamedExec(`
+		insert or ignore into point_snapshots (
+		           source_event_log_id, azimuth_number, did_exist, owner_address, owner_nonce, spawn_address,
+		           spawn_nonce, management_address, management_nonce, voting_address, voting_nonce,
+		           transfer_address, transfer_nonce, dominion, is_active, life, rift, crypto_suite_version,
+		           auth_key, encryption_key, has_sponsor, sponsor, is_escape_requested, escape_requested_to
+		       ) values (
+		           :source_event_log_id, :azimuth_number, :did_exist, :owner_address, :owner_nonce, :spawn_address,
+		           :spawn_nonce, :management_address, :management_nonce, :voting_address, :voting_nonce,
+		           :transfer_address, :transfer_nonce, :dominion, :is_active, :life, :rift, :crypto_suite_version,
+		           :auth_key, :encryption_key, :has_sponsor, :sponsor, :is_escape_requested, :escape_requested_to
+		       )`,
+		snapshot)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Put every point touched by an event back the way it was before the event
+func (tx Tx) RestorePointSnapshots(event_id uint64) {
+	var snapshots []PointSnapshot
+	err := tx.Select(&snapshots, `
+		select source_event_log_id, did_exist, azimuth_number, owner_address, owner_nonce, spawn_address,
+		       spawn_nonce, management_address, management_nonce, voting_address, voting_nonce, transfer_address,
+		       transfer_nonce, dominion, is_active, life, rift, crypto_suite_version, auth_key, encryption_key,
+		       has_sponsor, sponsor, is_escape_requested, escape_requested_to
+		  from point_snapshots
+		 where source_event_log_id = ?`,
+		event_id)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, s := range snapshots {
+		if !s.DidExist {
+			// The event created this point
+			tx.MustExec(`delete from points where azimuth_number = ?`, s.Number)
+			continue
+		}
+		_, err := tx.NamedExec(`
+			update points
+			   set owner_address = :owner_address,
+			       owner_nonce = :owner_nonce,
+			       spawn_address = :spawn_address,
+			       spawn_nonce = :spawn_nonce,
+			       management_address = :management_address,
+			       management_nonce = :management_nonce,
+			       voting_address = :voting_address,
+			       voting_nonce = :voting_nonce,
+			       transfer_address = :transfer_address,
+			       transfer_nonce = :transfer_nonce,
+			       dominion = :dominion,
+			       is_active = :is_active,
+			       life = :life,
+			       rift = :rift,
+			       crypto_suite_version = :crypto_suite_version,
+			       auth_key = :auth_key,
+			       encryption_key = :encryption_key,
+			       has_sponsor = :has_sponsor,
+			       sponsor = :sponsor,
+			       is_escape_requested = :is_escape_requested,
+			       escape_requested_to = :escape_requested_to
+			 where azimuth_number = :azimuth_number`,
+			s)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Delete snapshots (and replaced claims) for events that are too old to be reorged out anymore
+func (db *DB) PruneSnapshots() {
+	db.DB.MustExec(`
+		delete from point_snapshots
+		 where source_event_log_id in (
+		           select rowid from ethereum_events
+		            where block_number + ? <= (select max(block_number) from ethereum_events)
+		       )`,
+		REORG_WINDOW)
+	db.DB.MustExec(`
+		delete from claims
+		 where removed_by_event_id in (
+		           select rowid from ethereum_events
+		            where block_number + ? <= (select max(block_number) from ethereum_events)
+		       )`,
+		REORG_WINDOW)
+}
+
+// A block we know the hash and time of.  See the `blocks` table.
+type BlockInfo struct {
+	Number    uint64      `db:"block_number"`
+	Hash      common.Hash `db:"block_hash"`
+	Timestamp uint64      `db:"timestamp"` // Unix seconds
+}
+
+// Record the hash and timestamp (unix seconds) of a block, e.g., the chain head
+func (db *DB) SaveBlock(block_num uint64, hash common.Hash, timestamp uint64) {
+	db.DB.MustExec(`insert or replace into blocks (block_number, block_hash, timestamp) values (?, ?, ?)`,
+		block_num, hash, timestamp)
+}
+
+// Get the timestamp (unix seconds) of a block.  Zero if it's unknown.
+func (db *DB) GetBlockTimestamp(block_num uint64) uint64 {
+	var ret uint64
+	err := db.DB.Get(&ret, `select coalesce((select timestamp from blocks where block_number = ?), 0)`, block_num)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// Get the numbers of blocks after `after_block` that have events but no timestamp, oldest first.
+// Blocks whose timestamp is known to be unknown (see `SaveUnknownBlockTimestamps`) are left out.
+func (db *DB) GetEventBlocksWithoutTimestamps(after_block uint64, limit int) []uint64 {
+	ret := []uint64{}
+	err := db.DB.Select(&ret, `
+		select distinct block_number from ethereum_events
+		 where block_number > ?
+		   and block_number not in (select block_number from blocks where timestamp != 0)
+		   and block_number not in (select block_number from unknown_block_timestamps)
+		 order by block_number
+		 limit ?`,
+		after_block, limit)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// Record that the source didn't have the timestamps of some blocks, so they don't get asked for
+// again
+func (db *DB) SaveUnknownBlockTimestamps(block_nums []uint64) {
+	tx := db.DB.MustBegin()
+	for _, n := range block_nums {
+		tx.MustExec(`insert or ignore into unknown_block_timestamps (block_number) values (?)`, n)
+	}
+	if err := tx.Commit(); err != nil {
+		panic(err)
+	}
+}
+
+// Get all the block hashes we know of, from events and from the chain heads we've seen, starting
+// at `from_block`
+func (db *DB) GetBlockHashesSince(from_block uint64) map[uint64]common.Hash {
+	var rows []struct {
+		BlockNumber uint64      `db:"block_number"`
+		BlockHash   common.Hash `db:"block_hash"`
+	}
+	err := db.DB.Select(&rows, `
+		select distinct block_number, block_hash from ethereum_events where block_number >= ?
+		 union
+		select block_number, block_hash from blocks where block_number >= ?`,
+		from_block, from_block)
+	if err != nil {
+		panic(err)
+	}
+	ret := make(map[uint64]common.Hash)
+	for _, r := range rows {
+		if existing, is_ok := ret[r.BlockNumber]; is_ok && existing != r.BlockHash {
+			// Two different hashes for the same block; the DB is already inconsistent with itself
+			ret[r.BlockNumber] = common.Hash{}
+			continue
+		}
+		ret[r.BlockNumber] = r.BlockHash
+	}
+	return ret
+}
+
+// Undo a chain reorg: delete every event from `block_num` onward, undoing the effects of the ones
+// that were already played, and mark those blocks as not-fetched-yet so they get fetched again.
+//
+// Returns ErrReorgTooDeep if any of the played events are more than REORG_WINDOW blocks behind the
+// chain head (`latest_block`), since their snapshots are gone.  In that case nothing is changed.
+//
+// How many events got rolled back is reported to `progress` (which can be nil).
+func (db *DB) RollBackToBlock(block_num uint64, latest_block uint64, progress ProgressReporter) error {
+	t, err := db.DB.Beginx()
+	if err != nil {
+		panic(err)
+	}
+	tx := Tx{t}
+	// The newest block that's kept.  Rolling back to block 0 keeps nothing; there's no block -1.
+	last_kept := max(block_num, 1) - 1
+
+	var events []EthereumEventLog
+	err = tx.Select(&events, `
+	    select rowid, block_number, block_hash, tx_hash, log_index, contract_address, topic0, topic1,
+	            topic2, topic3, data, is_processed from ethereum_events
+	     where block_number >= ?
+	  order by block_number desc, log_index desc
+	`, block_num)
+	if err != nil {
+		panic(err)
+	}
+
+	// Undo them newest-first, so that each point ends up in its oldest snapshotted state
+	for _, e := range events {
+		if e.IsProcessed {
+			if !is_in_reorg_window(e.BlockNumber, latest_block) {
+				if err := tx.Rollback(); err != nil {
+					panic(err)
+				}
+				return fmt.Errorf("%w: event %d (block %d)", ErrReorgTooDeep, e.ID, e.BlockNumber)
+			}
+			tx.MustExec(`delete from diffs where source_event_log_id = ?`, e.ID)
+			tx.RestorePointSnapshots(e.ID)
+			tx.UndoClaimsEvent(e.ID)
+		}
+		tx.MustExec(`delete from point_snapshots where source_event_log_id = ?`, e.ID)
+		tx.MustExec(`delete from ethereum_events where rowid = ?`, e.ID)
+	}
+	tx.MustExec(`delete from blocks where block_number >= ?`, block_num)
+	tx.MustExec(`delete from unknown_block_timestamps where block_number >= ?`, block_num)
+	tx.MustExec(`delete from transactions where block_number >= ?`, block_num)
+	// Ecliptics that were registered by events that are gone now (see `RegisterEclipticContracts`)
+	tx.MustExec(`
+		delete from event_types
+		 where contract_address in (select address from contracts where name = 'Ecliptic' and start_block >= ?)`,
+		block_num)
+	tx.MustExec(`delete from fetched_ranges where from_block >= ?`, block_num)
+	tx.MustExec(`update fetched_ranges set to_block = ? where to_block >= ?`, last_kept, block_num)
+	tx.MustExec(`delete from contracts where name = 'Ecliptic' and start_block >= ?`, block_num)
+	tx.MustExec(`update contracts set latest_block_fetched = ? where latest_block_fetched >= ?`, last_kept, block_num)
+	tx.MustExec(`update finalized_block set block_number = ? where block_number >= ?`, last_kept, block_num)
+	tx.MustExec(`delete from poll_votes where block_number >= ?`, block_num)
+	tx.MustExec(`delete from star_release_calls where block_number >= ?`, block_num)
+	tx.MustExec(`delete from star_release_conditions where block_number >= ?`, block_num)
+	tx.MustExec(`update poll_votes_fetched set block_number = ? where block_number >= ?`, last_kept, block_num)
+
+	if err = tx.Commit(); err != nil {
+		panic(err)
+	}
+	ReportProgress(progress, Progress{
+		Task:         "Rolling back reorged blocks",
+		CurrentBlock: block_num,
+		Note:         fmt.Sprintf("rolled back %d events from block %d onward", len(events), block_num),
+	})
+	return nil
+}
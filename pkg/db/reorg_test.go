@@ -0,0 +1,84 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "go-azimuth/pkg/db"
+)
+
+func TestRollBackToBlock(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	db, err := DBCreate(":memory:")
+	require.NoError(err)
+
+	azimuth := common.HexToAddress("223c067f8cf28ae173ee5cafea60ca44c335fecb")
+	galaxy := common.HexToHash("0000000000000000000000000000000000000000000000000000000000000005")
+	address_a := common.HexToAddress("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	address_b := common.HexToAddress("bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+	events := []EthereumEventLog{
+		{BlockNumber: 100, ContractAddress: azimuth, Topic0: ACTIVATED, Topic1: galaxy},
+		{BlockNumber: 101, ContractAddress: azimuth, Topic0: OWNER_CHANGED, Topic1: galaxy,
+			Topic2: common.BytesToHash(address_a[:])},
+		{BlockNumber: 102, ContractAddress: azimuth, Topic0: OWNER_CHANGED, Topic1: galaxy,
+			Topic2: common.BytesToHash(address_b[:])},
+	}
+	for i := range events {
+		events[i].BlockHash = common.BigToHash(common.Big1)
+		events[i].Data = []byte{}
+		db.SaveEvent(&events[i])
+	}
+	db.SetLatestContractBlockFetched(db.GetContractByName("Azimuth").ID, 102)
+	db.ApplyEventEffects(events)
+
+	p, is_ok := db.GetPoint(AzimuthNumber(5))
+	require.True(is_ok)
+	assert.Equal(address_b, p.OwnerAddress)
+
+	// Undo the last transfer
+	require.NoError(db.RollBackToBlock(102, 102, nil))
+	p, is_ok = db.GetPoint(AzimuthNumber(5))
+	require.True(is_ok)
+	assert.Equal(address_a, p.OwnerAddress)
+	assert.True(p.IsActive)
+	history, _ := db.GetEventsForPoint(AzimuthNumber(5))
+	assert.Len(history, 2)
+
+	// The chain has moved on since; block 101 is too far behind the head to be reorged out
+	assert.ErrorIs(db.RollBackToBlock(101, 101+REORG_WINDOW, nil), ErrReorgTooDeep)
+	p, is_ok = db.GetPoint(AzimuthNumber(5))
+	require.True(is_ok)
+	assert.Equal(address_a, p.OwnerAddress)
+
+	// Undo everything; the point didn't exist before it was activated
+	require.NoError(db.RollBackToBlock(100, 102, nil))
+	_, is_ok = db.GetPoint(AzimuthNumber(5))
+	assert.False(is_ok)
+	assert.Equal(uint64(99), db.GetContractByName("Azimuth").LatestBlockNumFetched)
+}
+
+func TestRollBackToBlockZero(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	db, err := DBCreate(":memory:")
+	require.NoError(err)
+
+	azimuth := db.GetContractByName("Azimuth")
+	e := EthereumEventLog{
+		BlockNumber: 3, ContractAddress: azimuth.Address, Topic0: ACTIVATED, BlockHash: common.BigToHash(common.Big1),
+		Topic1: common.HexToHash("05"), Data: []byte{},
+	}
+	db.SaveEvent(&e)
+	db.SetLatestContractBlockFetched(azimuth.ID, 5)
+
+	// A local chain can reorg all the way back to genesis
+	require.NoError(db.RollBackToBlock(0, 5, nil))
+	assert.Equal(uint64(0), db.GetContractByName("Azimuth").LatestBlockNumFetched)
+	assert.Empty(db.GetFetchedRanges(azimuth.ID))
+}
@@ -0,0 +1,43 @@
+package db
+
+import (
+	"errors"
+)
+
+// Some Batch events don't have their transaction's call data, so they can't be played yet (see
+// `GetBatchesMissingCallData`)
+var ErrMissingCallData = errors.New("Batch events are missing their call data")
+
+// Get (up to `limit` of) the Batch events whose call data is missing, after the one with ID
+// `after_id`, in ID order.  Played ones are included too (they got played as empty batches).
+//
+// These come from an old version of `CatchUpNaiveLogs`, which saved Batch events first and filled
+// in their call data afterward, so a crash in between left them empty.  Or from batches that were
+// sent through another contract, whose call data couldn't be found in call traces.  (A Batch whose
+// call data was found, but is empty, isn't missing anything.)
+func (db *DB) GetBatchesMissingCallData(after_id uint64, limit int) []EthereumEventLog {
+	ret := []EthereumEventLog{}
+	err := db.DB.Select(&ret, `
+		select rowid, block_number, block_hash, tx_hash, log_index, contract_address, topic0, topic1,
+		       topic2, topic3, data, is_processed, is_call_data_missing from ethereum_events
+		 where topic0 = ? and is_call_data_missing and rowid > ?
+		 order by rowid
+		 limit ?`,
+		BATCH, after_id, limit)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// How many Batch events that haven't been played yet are missing their call data
+func (db *DB) CountUnplayedBatchesMissingCallData() uint64 {
+	var ret uint64
+	err := db.DB.Get(&ret, `
+		select count(*) from ethereum_events where topic0 = ? and is_call_data_missing and is_processed = 0`,
+		BATCH)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
@@ -10,6 +10,13 @@ create table db_version (
 );
 insert into db_version values(0);
 
+-- The chain this DB is for (see `Network`); its contracts are in `contracts`.  Only ever has one row.
+create table network (
+	name text not null,
+	chain_id integer not null
+);
+insert into network (name, chain_id) values ('mainnet', 1);
+
 
 -- ============
 -- Azimuth data
@@ -107,15 +114,54 @@ create view readable_diffs as
 	select diffs.rowid rowid,
 	       contracts.name contract,
 	       lower(hex(ethereum_events.tx_hash)) tx_hash,
+	       ethereum_events.block_number block_number,
+	       coalesce(blocks.timestamp, 0) timestamp, -- Unix seconds; 0 if unknown
 	       intra_log_index,
 	       source_event_log_id,
 	       azimuth_number,
 	       diff_types.name operation,
-	       lower(hex(diffs.data)) hex_data
+	       lower(hex(diffs.data)) hex_data,
+	       case when transactions.tx_hash is null then '' -- Not fetched
+	            else "0x" || lower(hex(transactions.from_address)) end sender,
+	       ethereum_events.sender_role sender_role
 	  from diffs
 	  join diff_types on diffs.operation = diff_types.rowid
 	  join ethereum_events on ethereum_events.rowid = source_event_log_id
-	  join contracts on contracts.address = ethereum_events.contract_address;
+	  join contracts on contracts.address = ethereum_events.contract_address
+	  left join blocks on blocks.block_number = ethereum_events.block_number
+	  left join transactions on transactions.tx_hash = ethereum_events.tx_hash;
+
+-- State of each point right before an event changed it, so the event can be undone if its block
+-- gets reorged out.  Only kept for recent events (see `REORG_WINDOW`).
+create table point_snapshots (rowid integer primary key,
+	source_event_log_id integer not null references ethereum_events(rowid),
+	azimuth_number integer not null,
+	did_exist bool not null, -- If not, undoing the event means deleting the point
+
+	owner_address blob not null default X'0000000000000000000000000000000000000000',
+	owner_nonce integer not null default 0,
+	spawn_address blob not null default X'0000000000000000000000000000000000000000',
+	spawn_nonce integer not null default 0,
+	management_address blob not null default X'0000000000000000000000000000000000000000',
+	management_nonce integer not null default 0,
+	voting_address blob not null default X'0000000000000000000000000000000000000000',
+	voting_nonce integer not null default 0,
+	transfer_address blob not null default X'0000000000000000000000000000000000000000',
+	transfer_nonce integer not null default 0,
+	dominion integer not null default 1,
+	is_active bool not null default 0,
+	life integer not null default 0,
+	rift integer not null default 0,
+	crypto_suite_version integer not null default 0,
+	auth_key blob not null default X'',
+	encryption_key blob not null default X'',
+	has_sponsor bool not null default 0,
+	sponsor integer not null default 0,
+	is_escape_requested bool not null default 0,
+	escape_requested_to integer not null default 0,
+
+	unique(source_event_log_id, azimuth_number)
+);
 
 
 -- =============
@@ -130,11 +176,27 @@ create table contracts (rowid integer primary key,
 );
 insert into contracts (address, name, start_block) values
 	(X'223c067f8cf28ae173ee5cafea60ca44c335fecb', 'Azimuth', 6784880),
-	(X'eb70029cfb3c53c778eaf68cd28de725390a1fe9', 'Naive', 13369829);
+	(X'eb70029cfb3c53c778eaf68cd28de725390a1fe9', 'Naive', 13369829),
+	(X'7fecab617c868bb5996d99d95200d2fa708218e4', 'Polls', 6784880),  -- Deployed with Azimuth
+	(X'e7e7f69b34d7d9bd8d61fb22c33b22708947971a', 'Claims', 6784880), -- Deployed with Azimuth
+	(X'86cd9cd0992f04231751e3761de45cecea5d1801', 'LinearStarRelease', 6784880),  -- No events; see star_release_calls
+	(X'8c241098c3d3498fe1261421633fd57986d74aea', 'ConditionalStarRelease', 6784880),
+	(X'f6b461fe1ad4bd2ce25b23fe0aff2ac19b3dfa76', 'DelegatedSending', 6784880);
+
+-- Ranges of blocks whose logs have been fetched, for each contract.  Touching ranges get merged, so
+-- anything between a contract's `start_block` and `latest_block_fetched` that isn't covered is a
+-- gap (see `azm gaps`).  Not for the star release contracts, which have no logs.
+create table fetched_ranges (rowid integer primary key,
+	contract_id integer not null references contracts(rowid),
+	from_block integer not null,
+	to_block integer not null,
+
+	unique (contract_id, from_block)
+);
 
 create table event_types (rowid integer primary key,
 	contract_address blob not null collate nocase check (length(contract_address) = 20),
-	hashed_name blob unique not null,
+	hashed_name blob not null, -- Not unique; e.g., every Ecliptic has the same events
 	name text not null,
 
 	unique (contract_address, hashed_name)
@@ -160,7 +222,16 @@ insert into event_types (contract_address,hashed_name,name) values
 	(X'223c067f8cf28ae173ee5cafea60ca44c335fecb',X'ab9c9327cffd2acc168fafedbe06139f5f55cb84c761df05e0511c251e2ee9bf','ChangedManagementProxy'),
 	(X'223c067f8cf28ae173ee5cafea60ca44c335fecb',X'cfe369b7197e7f0cf06793ae2472a9b13583fecbed2f78dfa14d1f10796b847c','ChangedTransferProxy'),
 	(X'223c067f8cf28ae173ee5cafea60ca44c335fecb',X'fafd04ade1daae2e1fdb0fc1cc6a899fd424063ed5c92120e67e073053b94898','ChangedDns'),
-	(X'eb70029cfb3c53c778eaf68cd28de725390a1fe9',X'cca739c72762deed05941b38d4aa82f2718c74457d5e2d8c5b1d7642caf22196','Batch');
+	(X'223c067f8cf28ae173ee5cafea60ca44c335fecb',X'8be0079c531659141344cd1fd0a4f28419497f9722a3daafe3b4186f6b6457e0','OwnershipTransferred'),
+	(X'eb70029cfb3c53c778eaf68cd28de725390a1fe9',X'cca739c72762deed05941b38d4aa82f2718c74457d5e2d8c5b1d7642caf22196','Batch'),
+	(X'7fecab617c868bb5996d99d95200d2fa708218e4',X'6ffd05e058c57083a3d6fea058fbdbec172b55ce532b0f778f16e929a0ff516b','UpgradePollStarted'),
+	(X'7fecab617c868bb5996d99d95200d2fa708218e4',X'ac68c1f0dc8aca8ee1bf445a687669d7d404b12dd0886f747e696115f0048527','DocumentPollStarted'),
+	(X'7fecab617c868bb5996d99d95200d2fa708218e4',X'1ea969d04c4e4e370253bdeb7fb9638e50e75c206b4c42be36eb9844d37f4441','UpgradeMajority'),
+	(X'7fecab617c868bb5996d99d95200d2fa708218e4',X'b11c3a231f918730636de6d815e478d63aead4aaaac95266c95b0278ff718319','DocumentMajority'),
+	(X'e7e7f69b34d7d9bd8d61fb22c33b22708947971a',X'ef768946812a98aaa648b07282fa428f69903e34f6a38d8a9b208bd8ee53bb53','ClaimAdded'),
+	(X'e7e7f69b34d7d9bd8d61fb22c33b22708947971a',X'dd924d662463d64f1eaae95a37d26f5bbbf9bbe2443adb897397e8b57c0b0513','ClaimRemoved'),
+	(X'f6b461fe1ad4bd2ce25b23fe0aff2ac19b3dfa76',X'b05d354b3ecb8f9593b9298bcdeea36401f0e199bc0617e9a731a45cecb343ec','Pool'),
+	(X'f6b461fe1ad4bd2ce25b23fe0aff2ac19b3dfa76',X'47638e3cddee220481e4c3f9183d639c0efea7f05fcd2df4188855729f715419','Sent');
 
 create table ethereum_events (rowid integer primary key,
 	block_number integer not null,
@@ -172,9 +243,18 @@ create table ethereum_events (rowid integer primary key,
 	topic0 blob not null,
 	topic1 blob not null default "",
 	topic2 blob not null default "",
+	topic3 blob not null default X'0000000000000000000000000000000000000000000000000000000000000000',
 	data blob not null default "",
 
 	is_processed bool not null default 0,
+	-- Whether the log has been checked against the receipts root of its block (see `verify_logs`)
+	is_verified bool not null default 0,
+	-- Which of the point's roles the transaction's sender had (e.g., "owner", "management-proxy"),
+	-- worked out when the event gets played.  '' if unknown (see `transactions`).
+	sender_role text not null default '',
+	-- For Batch events: whether the transaction's call data couldn't be found (see `repair`).  Call data
+	-- that was found can still be empty.
+	is_call_data_missing bool not null default 0,
 
 	unique(block_number, log_index)
 	foreign key(contract_address, topic0) references event_types(contract_address, hashed_name)
@@ -182,8 +262,9 @@ create table ethereum_events (rowid integer primary key,
 create index index_ethereum_events_is_processed on ethereum_events(is_processed);
 create view readable_ethereum_events as
 	select ethereum_events.rowid as rowid,
-	       block_number,
-	       lower(hex(block_hash)) hex_block_hash,
+	       ethereum_events.block_number block_number,
+	       datetime(blocks.timestamp, 'unixepoch') time,
+	       lower(hex(ethereum_events.block_hash)) hex_block_hash,
 	       lower(hex(tx_hash)) hex_tx_hash,
 	       log_index,
 	       "0x" || lower(hex(ethereum_events.contract_address)) hex_contract_address,
@@ -191,7 +272,153 @@ create view readable_ethereum_events as
 	       lower(hex(topic0)) hex_topic0,
 	       lower(hex(topic1)) hex_topic1,
 	       lower(hex(topic2)) hex_topic2,
+	       lower(hex(topic3)) hex_topic3,
 	       lower(hex(data)) hex_data,
-	       is_processed
+	       is_processed,
+	       is_verified
 	  from ethereum_events
-	  join event_types on topic0 = hashed_name;
+	  join event_types on ethereum_events.contract_address = event_types.contract_address and topic0 = hashed_name
+	  left join blocks on blocks.block_number = ethereum_events.block_number and blocks.timestamp != 0;
+
+-- Operators (`setApprovalForAll` in Ecliptic) that each address has approved to move all its points.
+-- It's the latest ApprovalForAll event for each (owner, operator) pair, if it approved.
+create view operators as
+	select owner_address, operator_address
+	  from (select substr(topic1, 13) owner_address,
+	               substr(topic2, 13) operator_address,
+	               substr(data, 32, 1) = X'01' is_approved,
+	               row_number() over (partition by topic1, topic2 order by block_number desc, log_index desc) as n
+	          from ethereum_events
+	         where topic0 = X'17307eab39ab6107e8899845ad3d59bd9653f200f220920489ca2b5937696c31'
+	           and contract_address in (select address from contracts where name = 'Ecliptic')
+	           and is_processed = 1)
+	 where n = 1 and is_approved;
+
+-- Block hashes seen at the chain head, to detect reorgs of blocks with no events in them
+create table blocks (
+	block_number integer primary key,
+	block_hash blob not null,
+	timestamp integer not null default 0
+);
+
+-- Blocks with events whose time was asked for, but the source didn't know it (e.g., a logs file), so
+-- they don't get asked for again on every run.  See `BackfillBlockTimestamps`.
+create table unknown_block_timestamps (
+	block_number integer primary key
+);
+
+-- Who sent each L1 transaction that emitted an event, and how much gas it used.  Only fetched with
+-- `--fetch-senders`.
+create table transactions (
+	tx_hash blob primary key,
+	block_number integer not null,
+	from_address blob not null,
+	gas_used integer not null
+);
+
+-- Events after this block aren't final yet (could still get reorged out), so they don't get played.
+-- Only ever has one row.
+create table finalized_block (
+	block_number integer not null
+);
+insert into finalized_block (block_number) values (0);
+
+-- Polls (from the Polls contract), with when they started, and when they got a majority if they did.
+-- For upgrade polls, the proposal is the new Ecliptic's address; for document polls, it's a hash.
+create view polls as
+	select started.rowid rowid,
+	       case started.topic0 when X'6ffd05e058c57083a3d6fea058fbdbec172b55ce532b0f778f16e929a0ff516b' then 'upgrade'
+	                           else 'document' end kind,
+	       case started.topic0 when X'6ffd05e058c57083a3d6fea058fbdbec172b55ce532b0f778f16e929a0ff516b'
+	                           then substr(started.data, 13) else started.data end proposal,
+	       started.block_number start_block,
+	       coalesce(blocks.timestamp, 0) start_time,
+	       (select min(majority.block_number)
+	          from ethereum_events majority
+	         where majority.contract_address = started.contract_address
+	           and majority.topic0 = case started.topic0
+	                   when X'6ffd05e058c57083a3d6fea058fbdbec172b55ce532b0f778f16e929a0ff516b'
+	                   then X'1ea969d04c4e4e370253bdeb7fb9638e50e75c206b4c42be36eb9844d37f4441'
+	                   else X'b11c3a231f918730636de6d815e478d63aead4aaaac95266c95b0278ff718319' end
+	           and majority.data = started.data
+	           and majority.block_number >= started.block_number
+	           and majority.is_processed = 1) majority_block
+	  from ethereum_events started
+	  left join blocks on blocks.block_number = started.block_number
+	 where started.contract_address = (select address from contracts where name = 'Polls')
+	   and started.topic0 in (X'6ffd05e058c57083a3d6fea058fbdbec172b55ce532b0f778f16e929a0ff516b',
+	                          X'ac68c1f0dc8aca8ee1bf445a687669d7d404b12dd0886f747e696115f0048527')
+	   and started.is_processed = 1;
+
+-- Galaxies' votes on polls.  There's no event for these, so they come from call traces of the
+-- Polls contract's castUpgradeVote and castDocumentVote.
+create table poll_votes (rowid integer primary key,
+	block_number integer not null,
+	tx_hash blob not null,
+	trace_address text not null, -- Which call in the transaction it was
+	kind text not null check (kind in ('upgrade', 'document')),
+	proposal blob not null,
+	galaxy integer not null,
+	vote bool not null,
+
+	unique (tx_hash, trace_address)
+);
+-- How far the votes have been fetched.  Only ever has one row.
+create table poll_votes_fetched (
+	block_number integer not null
+);
+insert into poll_votes_fetched (block_number) values (0);
+
+-- Claims that points have published (from the Claims contract), e.g., social media handles or
+-- addresses on other chains.  Replaced and removed claims are kept for a while, so they can be put
+-- back if the events that replaced or removed them get reorged out.
+create table claims (rowid integer primary key,
+	azimuth_number integer not null,
+	protocol text not null,
+	claim text not null,
+	dossier blob not null, -- Proof of the claim, e.g., a signature
+	added_by_event_id integer not null references ethereum_events(rowid),
+	removed_by_event_id integer references ethereum_events(rowid) -- Null if it's still there
+);
+create index claims_azimuth_number on claims (azimuth_number);
+
+-- Calls to the star release contracts (LinearStarRelease and ConditionalStarRelease), which hold
+-- locked-up stars until they're released to their beneficiaries.  LinearStarRelease doesn't emit
+-- events, and ConditionalStarRelease only does when its conditions complete (see
+-- `star_release_conditions`), so this comes from call traces.  Only successful calls are saved.
+create table star_release_calls (rowid integer primary key,
+	block_number integer not null,
+	tx_hash blob not null,
+	trace_address text not null, -- Which call in the transaction it was
+	contract_address blob not null references contracts(address),
+	function text not null check (function in (
+		'register', 'deposit', 'transfer_batch', 'start_releasing', 'withdraw', 'forfeit'
+	)),
+	caller blob not null,
+	participant blob not null default X'', -- For transfer_batch, who it's from; the caller is who it's to.
+	                                       -- For withdraw, whose stars they are.
+	star integer not null default 0,       -- For deposit
+	batch integer not null default 0,      -- For deposit to ConditionalStarRelease, and forfeit
+	windup integer not null default 0,     -- For register to LinearStarRelease: seconds after the start ...
+	amount integer not null default 0,     -- ... how many stars ...
+	rate integer not null default 0,       -- ... how many stars get released ...
+	rate_unit integer not null default 0,  -- ... every this many seconds
+	batch_sizes text not null default '',  -- For register to ConditionalStarRelease: stars in each batch, e.g. "2,2,4"
+
+	unique (tx_hash, trace_address)
+);
+
+-- When each of ConditionalStarRelease's conditions completed, i.e., got met or missed its deadline
+-- (its ConditionCompleted event).  Batch N starts getting released once condition N is completed.
+create table star_release_conditions (rowid integer primary key,
+	block_number integer not null,
+	condition_index integer not null unique,
+	completed_at integer not null -- Unix seconds
+);
+
+-- RPC credits used per day (UTC), for metered providers like Infura.  Only counts what this database's
+-- runs have used.
+create table credit_usage (
+	day text primary key, -- YYYY-MM-DD
+	credits integer not null default 0
+);
@@ -0,0 +1,252 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Who sent an L1 transaction, and how much gas it used.  See the `transactions` table.
+type TransactionInfo struct {
+	TxHash      common.Hash    `db:"tx_hash"`
+	BlockNumber uint64         `db:"block_number"`
+	FromAddress common.Address `db:"from_address"`
+	GasUsed     uint64         `db:"gas_used"`
+}
+
+const save_transaction_sql = `
+	insert or replace into transactions (tx_hash, block_number, from_address, gas_used)
+	                             values (:tx_hash, :block_number, :from_address, :gas_used)`
+
+// Save transactions' senders.  Events of theirs that were already played (e.g., when backfilling
+// the senders) get their sender roles worked out now, from the points' history; the rest get them
+// when they're played.
+func (db *DB) SaveTransactions(txs []TransactionInfo) {
+	t, err := db.DB.Beginx()
+	if err != nil {
+		panic(err)
+	}
+	tx := Tx{t}
+	for _, tx_info := range txs {
+		if _, err := tx.NamedExec(save_transaction_sql, tx_info); err != nil {
+			panic(err)
+		}
+
+		// In order, so each one can see the roles of the ones before it
+		events := []EthereumEventLog{}
+		err := tx.Select(&events, `
+			select * from ethereum_events
+			 where tx_hash = ? and is_processed = 1 and sender_role = ''
+			 order by block_number, log_index`,
+			tx_info.TxHash)
+		if err != nil {
+			panic(err)
+		}
+		for _, e := range events {
+			if role := tx.get_sender_role(e, true); role != "" {
+				_, err := tx.Exec(`update ethereum_events set sender_role = ? where rowid = ?`, role, e.ID)
+				if err != nil {
+					panic(err)
+				}
+			}
+		}
+	}
+	if err := t.Commit(); err != nil {
+		panic(err)
+	}
+}
+
+// Get the sender of a transaction, if it's been fetched
+func (db *DB) GetTransaction(tx_hash common.Hash) (TransactionInfo, bool) {
+	var ret TransactionInfo
+	err := db.DB.Get(&ret, `select * from transactions where tx_hash = ?`, tx_hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return TransactionInfo{}, false
+	} else if err != nil {
+		panic(err)
+	}
+	return ret, true
+}
+
+// Get (up to `limit` of) the transactions that emitted Azimuth events, but whose senders haven't
+// been fetched yet, oldest first.  Only the hash and block number are filled in.  (Other contracts'
+// events don't have sender roles, so their senders aren't worth fetching.)
+func (db *DB) GetEventTxsWithoutSenders(limit int) []TransactionInfo {
+	ret := []TransactionInfo{}
+	err := db.DB.Select(&ret, `
+		select tx_hash, min(block_number) block_number from ethereum_events
+		 where tx_hash not in (select tx_hash from transactions)
+		   and contract_address = (select address from contracts where name = 'Azimuth')
+		 group by tx_hash
+		 order by block_number
+		 limit ?`,
+		limit)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// Work out which of a point's roles sent the transaction that emitted an Azimuth event, going by
+// the point's state before the event gets played.  Empty if the sender hasn't been fetched, or
+// isn't any of them (e.g., the Ecliptic owner, or a contract that was approved some other way).
+//
+// Several events from the same transaction about the same point (e.g., a transfer that resets the
+// proxies) all get the role the first one did, since by the later ones, the point has changed.
+//
+// When it's being played, the points table has the point's state from before it.  For an event
+// that was already played (`is_played`), that state gets pieced back together from the diffs and
+// approvals before it instead (see `get_role_before`).
+func (tx Tx) get_sender_role(e EthereumEventLog, is_played bool) string {
+	switch e.Topic0 {
+	case SPAWNED, ACTIVATED, OWNER_CHANGED, CHANGED_SPAWN_PROXY, CHANGED_TRANSFER_PROXY,
+		CHANGED_MANAGEMENT_PROXY, CHANGED_VOTING_PROXY, ESCAPE_REQUESTED, ESCAPE_CANCELED, ESCAPE_ACCEPTED,
+		LOST_SPONSOR, BROKE_CONTINUITY, CHANGED_KEYS:
+	default:
+		// Not about a point
+		return ""
+	}
+
+	var sender common.Address
+	err := tx.Get(&sender, `select from_address from transactions where tx_hash = ?`, e.TxHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ""
+	} else if err != nil {
+		panic(err)
+	}
+
+	var prev_role string
+	err = tx.Get(&prev_role, `
+		select sender_role from ethereum_events
+		 where tx_hash = ? and topic1 = ? and block_number = ? and log_index < ? and is_processed = 1
+		 order by log_index
+		 limit 1`,
+		e.TxHash, e.Topic1, e.BlockNumber, e.LogIndex)
+	if err == nil {
+		return prev_role
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		panic(err)
+	}
+
+	get_role := tx.get_role
+	if is_played {
+		get_role = func(address common.Address, azimuth_number AzimuthNumber) string {
+			return tx.get_role_before(address, azimuth_number, e)
+		}
+	}
+
+	azimuth_number := topic_to_azimuth_number(e.Topic1)
+	if e.Topic0 == ACTIVATED && azimuth_number.Rank() != GALAXY {
+		// Sent by whoever spawned it
+		if role := get_role(sender, azimuth_number.Parent()); role != "" {
+			return "parent's " + role
+		}
+		return ""
+	}
+	if role := get_role(sender, azimuth_number); role != "" {
+		return role
+	}
+	switch e.Topic0 {
+	case ESCAPE_ACCEPTED, ESCAPE_CANCELED, LOST_SPONSOR:
+		// Can be sent by the sponsor instead
+		if role := get_role(sender, topic_to_azimuth_number(e.Topic2)); role != "" {
+			return "sponsor's " + role
+		}
+	}
+	return ""
+}
+
+// Which of a point's roles an address has.  Empty if none.
+func (tx Tx) get_role(address common.Address, azimuth_number AzimuthNumber) string {
+	var p Point
+	err := tx.Get(&p, `select * from points where azimuth_number = ?`, azimuth_number)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ""
+	} else if err != nil {
+		panic(err)
+	}
+	var is_operator bool
+	err = tx.Get(&is_operator, `
+		select count(*) > 0 from operators where owner_address = ? and operator_address = ?`,
+		p.OwnerAddress, address)
+	if err != nil {
+		panic(err)
+	}
+	return role_of(address, p, is_operator)
+}
+
+// Which of a point's roles an address had right before an event was played, going by the diffs
+// (and operator approvals) from the events before it.  Empty if none, or if the point didn't exist
+// yet.
+func (tx Tx) get_role_before(address common.Address, azimuth_number AzimuthNumber, e EthereumEventLog) string {
+	get_address := func(operation uint) common.Address {
+		var data []byte
+		err := tx.Get(&data, `
+			select diffs.data from diffs
+			  join ethereum_events on ethereum_events.rowid = diffs.source_event_log_id
+			 where diffs.azimuth_number = ? and diffs.operation = ?
+			   and (ethereum_events.block_number < ? or
+			        (ethereum_events.block_number = ? and ethereum_events.log_index < ?))
+			 order by ethereum_events.block_number desc, ethereum_events.log_index desc,
+			          diffs.intra_log_index desc
+			 limit 1`,
+			azimuth_number, operation, e.BlockNumber, e.BlockNumber, e.LogIndex)
+		if errors.Is(err, sql.ErrNoRows) {
+			return common.Address{}
+		} else if err != nil {
+			panic(err)
+		}
+		return common.BytesToAddress(data)
+	}
+	p := Point{
+		Number:            azimuth_number,
+		OwnerAddress:      get_address(DIFF_CHANGED_OWNER),
+		ManagementAddress: get_address(DIFF_CHANGED_MANAGEMENT_PROXY),
+		SpawnAddress:      get_address(DIFF_CHANGED_SPAWN_PROXY),
+		VotingAddress:     get_address(DIFF_CHANGED_VOTING_PROXY),
+		TransferAddress:   get_address(DIFF_CHANGED_TRANSFER_PROXY),
+	}
+	if p.OwnerAddress == (common.Address{}) {
+		// Didn't exist yet
+		return ""
+	}
+
+	// Same as the `operators` view, but only counting the approvals before the event
+	var is_operator bool
+	err := tx.Get(&is_operator, `
+		select coalesce((
+			select substr(data, 32, 1) = X'01' from ethereum_events
+			 where topic0 = ? and topic1 = ? and topic2 = ?
+			   and contract_address in (select address from contracts where name = 'Ecliptic')
+			   and is_processed = 1
+			   and (block_number < ? or (block_number = ? and log_index < ?))
+			 order by block_number desc, log_index desc
+			 limit 1
+		), 0)`,
+		APPROVAL_FOR_ALL, common.BytesToHash(p.OwnerAddress[:]), common.BytesToHash(address[:]),
+		e.BlockNumber, e.BlockNumber, e.LogIndex)
+	if err != nil {
+		panic(err)
+	}
+	return role_of(address, p, is_operator)
+}
+
+func role_of(address common.Address, p Point, is_operator bool) string {
+	switch address {
+	case p.OwnerAddress:
+		return "owner"
+	case p.ManagementAddress:
+		return "management-proxy"
+	case p.SpawnAddress:
+		return "spawn-proxy"
+	case p.VotingAddress:
+		return "voting-proxy"
+	case p.TransferAddress:
+		return "transfer-proxy"
+	}
+	if is_operator {
+		return "operator"
+	}
+	return ""
+}
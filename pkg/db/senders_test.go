@@ -0,0 +1,127 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "go-azimuth/pkg/db"
+)
+
+func TestSenderRoles(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	db, err := DBCreate(":memory:")
+	require.NoError(err)
+	azimuth := db.GetContractByName("Azimuth")
+
+	galaxy := common.HexToHash("05")
+	owner := common.HexToAddress("aaaa")
+	new_owner := common.HexToAddress("bbbb")
+	manager := common.HexToAddress("cccc")
+	stranger := common.HexToAddress("dddd")
+	event := func(tx_hash common.Hash, log_index uint, topic0 common.Hash, topic2 common.Hash) EthereumEventLog {
+		return EthereumEventLog{BlockNumber: 100, BlockHash: common.Hash{100}, TxHash: tx_hash, LogIndex: log_index,
+			ContractAddress: azimuth.Address, Topic0: topic0, Topic1: galaxy, Topic2: topic2, Data: []byte{}}
+	}
+	events := []EthereumEventLog{
+		event(common.HexToHash("01"), 0, ACTIVATED, common.Hash{}),
+		event(common.HexToHash("01"), 1, OWNER_CHANGED, common.BytesToHash(owner[:])),
+		event(common.HexToHash("02"), 2, CHANGED_MANAGEMENT_PROXY, common.BytesToHash(manager[:])),
+		event(common.HexToHash("03"), 3, CHANGED_VOTING_PROXY, common.BytesToHash(manager[:])),
+		event(common.HexToHash("04"), 4, CHANGED_VOTING_PROXY, common.Hash{}),
+		// A transfer that resets the proxies; the new owner didn't send it
+		event(common.HexToHash("05"), 5, OWNER_CHANGED, common.BytesToHash(new_owner[:])),
+		event(common.HexToHash("05"), 6, CHANGED_MANAGEMENT_PROXY, common.Hash{}),
+		// Sender not fetched
+		event(common.HexToHash("06"), 7, CHANGED_SPAWN_PROXY, common.Hash{}),
+	}
+	db.SaveFetchedEvents(azimuth.ID, events, nil, []TransactionInfo{
+		{TxHash: common.HexToHash("01"), BlockNumber: 100, FromAddress: owner, GasUsed: 100000},
+		{TxHash: common.HexToHash("02"), BlockNumber: 100, FromAddress: owner, GasUsed: 50000},
+		{TxHash: common.HexToHash("03"), BlockNumber: 100, FromAddress: manager, GasUsed: 50000},
+		{TxHash: common.HexToHash("04"), BlockNumber: 100, FromAddress: stranger, GasUsed: 50000},
+		{TxHash: common.HexToHash("05"), BlockNumber: 100, FromAddress: owner, GasUsed: 80000},
+	}, BlockRange{FromBlock: 100, ToBlock: 100})
+	assert.Equal([]TransactionInfo{{TxHash: common.HexToHash("06"), BlockNumber: 100}}, db.GetEventTxsWithoutSenders(10))
+
+	db.ApplyEventEffects(events)
+	history, is_found := db.GetEventsForPoint(AzimuthNumber(5))
+	require.True(is_found)
+	roles := []string{}
+	for _, h := range history {
+		roles = append(roles, h.SenderRole)
+	}
+	// The galaxy doesn't exist until it's activated
+	assert.Equal([]string{"", "", "owner", "management-proxy", "", "owner", "owner", ""}, roles)
+	assert.Equal("0x"+common.Bytes2Hex(manager[:]), history[3].Sender)
+	assert.Equal("", history[7].Sender)
+
+	// Reorged out
+	require.NoError(db.RollBackToBlock(100, 100, nil))
+	_, is_found = db.GetTransaction(common.HexToHash("01"))
+	assert.False(is_found)
+}
+
+func TestBackfilledSenderRoles(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	db, err := DBCreate(":memory:")
+	require.NoError(err)
+	azimuth := db.GetContractByName("Azimuth")
+	ecliptic := common.HexToAddress("e1e1e1e1e1e1e1e1e1e1e1e1e1e1e1e1e1e1e1e1")
+	upgrade := EthereumEventLog{BlockNumber: 50, ContractAddress: azimuth.Address, Topic0: OWNERSHIP_TRANSFERRED,
+		Topic1: common.HexToHash("dddd"), Topic2: common.BytesToHash(ecliptic[:]), Data: []byte{}}
+	db.SaveEvent(&upgrade)
+	db.RegisterEclipticContracts()
+
+	galaxy := common.HexToHash("05")
+	owner := common.HexToAddress("aaaa")
+	manager := common.HexToAddress("cccc")
+	operator := common.HexToAddress("eeee")
+	event := func(tx_hash common.Hash, log_index uint, topic0 common.Hash, topic2 common.Hash) EthereumEventLog {
+		return EthereumEventLog{BlockNumber: 100, BlockHash: common.Hash{100}, TxHash: tx_hash, LogIndex: log_index,
+			ContractAddress: azimuth.Address, Topic0: topic0, Topic1: galaxy, Topic2: topic2, Data: []byte{}}
+	}
+	approval := event(common.HexToHash("04"), 3, APPROVAL_FOR_ALL, common.BytesToHash(operator[:]))
+	approval.ContractAddress = ecliptic
+	approval.Topic1 = common.BytesToHash(owner[:])
+	approval.Data = common.Hash{31: 1}.Bytes()
+	revoked := approval
+	revoked.TxHash = common.HexToHash("07")
+	revoked.LogIndex = 6
+	revoked.Data = common.Hash{}.Bytes()
+	events := []EthereumEventLog{
+		event(common.HexToHash("01"), 0, ACTIVATED, common.Hash{}),
+		event(common.HexToHash("01"), 1, OWNER_CHANGED, common.BytesToHash(owner[:])),
+		event(common.HexToHash("02"), 2, CHANGED_MANAGEMENT_PROXY, common.BytesToHash(manager[:])),
+		approval,
+		event(common.HexToHash("05"), 4, CHANGED_VOTING_PROXY, common.BytesToHash(manager[:])),
+		event(common.HexToHash("06"), 5, CHANGED_SPAWN_PROXY, common.BytesToHash(manager[:])),
+		// Afterward, neither of them has a role anymore
+		revoked,
+		event(common.HexToHash("08"), 7, CHANGED_MANAGEMENT_PROXY, common.Hash{}),
+	}
+	db.SaveFetchedEvents(azimuth.ID, events, nil, nil, BlockRange{FromBlock: 100, ToBlock: 100})
+	db.ApplyEventEffects(append([]EthereumEventLog{upgrade}, events...))
+
+	// Played without senders; backfilled afterward
+	db.SaveTransactions([]TransactionInfo{
+		{TxHash: common.HexToHash("01"), BlockNumber: 100, FromAddress: owner},
+		{TxHash: common.HexToHash("02"), BlockNumber: 100, FromAddress: owner},
+		{TxHash: common.HexToHash("05"), BlockNumber: 100, FromAddress: operator},
+		{TxHash: common.HexToHash("06"), BlockNumber: 100, FromAddress: manager},
+	})
+	history, is_found := db.GetEventsForPoint(AzimuthNumber(5))
+	require.True(is_found)
+	roles := []string{}
+	for _, h := range history {
+		roles = append(roles, h.SenderRole)
+	}
+	// Going by their roles at the time, not now
+	assert.Equal([]string{"", "", "owner", "operator", "management-proxy", ""}, roles)
+}
@@ -0,0 +1,307 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// A (successful) call to one of the star release contracts.  See the `star_release_calls` table.
+type StarReleaseCall struct {
+	ID              uint64         `db:"rowid"`
+	BlockNumber     uint64         `db:"block_number"`
+	TxHash          []byte         `db:"tx_hash"`
+	TraceAddress    string         `db:"trace_address"`
+	ContractAddress common.Address `db:"contract_address"`
+	Function        string         `db:"function"`
+	Caller          common.Address `db:"caller"`
+	Participant     common.Address `db:"participant"`
+	Star            AzimuthNumber  `db:"star"`
+	Batch           uint8          `db:"batch"`
+	Windup          uint64         `db:"windup"`
+	Amount          uint16         `db:"amount"`
+	Rate            uint16         `db:"rate"`
+	RateUnit        uint64         `db:"rate_unit"`
+	BatchSizes      string         `db:"batch_sizes"` // Comma-separated, e.g. "2,2,4"
+}
+
+// When one of ConditionalStarRelease's conditions completed (met or missed).  See the
+// `star_release_conditions` table.
+type StarReleaseCondition struct {
+	BlockNumber    uint64 `db:"block_number"`
+	ConditionIndex uint8  `db:"condition_index"`
+	CompletedAt    uint64 `db:"completed_at"` // Unix seconds
+}
+
+// Save the calls to a star release contract (and any conditions that completed, for
+// ConditionalStarRelease) from a range of blocks, and mark the range as fetched, all at once
+func (db *DB) SaveStarReleaseCalls(
+	contract_id uint64, calls []StarReleaseCall, conditions []StarReleaseCondition, latest_block_fetched uint64,
+) {
+	tx := db.DB.MustBegin()
+	for _, c := range calls {
+		_, err := tx.NamedExec(`
+			insert or ignore into star_release_calls (
+			           block_number, tx_hash, trace_address, contract_address, function, caller, participant, star,
+			           batch, windup, amount, rate, rate_unit, batch_sizes
+			       ) values (
+			           :block_number, :tx_hash, :trace_address, :contract_address, :function, :caller, :participant,
+			           :star, :batch, :windup, :amount, :rate, :rate_unit, :batch_sizes
+			       )`,
+			c)
+		if err != nil {
+			panic(err)
+		}
+	}
+	for _, c := range conditions {
+		_, err := tx.NamedExec(`
+			insert or ignore into star_release_conditions (block_number, condition_index, completed_at)
+			values (:block_number, :condition_index, :completed_at)`,
+			c)
+		if err != nil {
+			panic(err)
+		}
+	}
+	tx.MustExec(`update contracts set latest_block_fetched = max(latest_block_fetched, ?) where rowid = ?`,
+		latest_block_fetched, contract_id)
+	if err := tx.Commit(); err != nil {
+		panic(err)
+	}
+}
+
+// A star that's locked up in one of the star release contracts
+type Lockup struct {
+	Contract string // "LinearStarRelease" or "ConditionalStarRelease"
+	// Who the star will be released to, i.e., who effectively controls it.  Zero if it's unknown
+	// (e.g., the calls to the contract haven't been fetched).
+	Beneficiary common.Address
+	Batch       uint8 // ConditionalStarRelease only
+
+	// LinearStarRelease only.  Zero if unknown.
+	ReleaseStart uint64 // When the contract started releasing stars (unix seconds)
+	Windup       uint64 // How long after the start the batch starts getting released (seconds)
+	Amount       uint16 // How many stars are in the batch
+
+	// ConditionalStarRelease only.  How many stars are in each batch, and when each batch's condition
+	// completed (unix seconds; zero if it hasn't yet).
+	BatchSizes      []uint16 `json:",omitempty"`
+	BatchStartTimes []uint64 `json:",omitempty"`
+
+	Rate     uint16 // How many stars get released...
+	RateUnit uint64 // ...every this many seconds
+	// How many stars have been withdrawn from the beneficiary's batch(es) so far, and how many
+	// withdrawals it takes to get to this star (counting those).
+	NumWithdrawn      uint64
+	WithdrawalsNeeded uint64
+	// When this star can be withdrawn (unix seconds); an estimate.  Zero if unknown.
+	UnlockTime uint64
+}
+
+// Get the calls to a star release contract, in the order they happened
+func (db *DB) get_star_release_calls(contract_address common.Address) []StarReleaseCall {
+	ret := []StarReleaseCall{}
+	err := db.DB.Select(&ret, `
+	    select rowid, block_number, tx_hash, trace_address, contract_address, function, caller, participant,
+	           star, batch, windup, amount, rate, rate_unit, batch_sizes
+	      from star_release_calls
+	     where contract_address = ? and block_number <= (select block_number from finalized_block)
+	  order by block_number, rowid`,
+		contract_address)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// Get when each of ConditionalStarRelease's conditions completed, by condition index (unix seconds;
+// zero if it hasn't)
+func (db *DB) get_star_release_condition_times(num_conditions int) []uint64 {
+	var conditions []StarReleaseCondition
+	err := db.DB.Select(&conditions, `
+		select block_number, condition_index, completed_at from star_release_conditions
+		 where block_number <= (select block_number from finalized_block)`)
+	if err != nil {
+		panic(err)
+	}
+	ret := make([]uint64, num_conditions)
+	for _, c := range conditions {
+		if int(c.ConditionIndex) < num_conditions {
+			ret[c.ConditionIndex] = c.CompletedAt
+		}
+	}
+	return ret
+}
+
+// A participant's stars in a star release contract, as built up by replaying the calls to it
+type star_release_commitment struct {
+	registration  StarReleaseCall
+	stars         []AzimuthNumber // Still in the contract, in the order they were deposited
+	num_withdrawn uint64
+	forfeited     map[uint8]bool // ConditionalStarRelease's batches
+}
+
+// Get the lockup of a point, if it's locked up in a star release contract (i.e., owned by one).
+//
+// The calls to the contract get replayed to find whose stars it's among (deposits, and batches
+// being transferred with `transferBatch`), and how many withdrawals it takes to get to it.
+//
+// WTF: both contracts release stars over time, and each withdrawal takes the most recently deposited
+// star that's still there, so a star's unlock time depends on how many of its beneficiary's stars
+// have to be withdrawn before it.  This assumes they all get withdrawn as soon as they can be.
+//   - LinearStarRelease: `rate` stars every `rate_unit` seconds, after the batch's windup.
+//   - ConditionalStarRelease: each batch releases `rate` stars every `rate_unit` seconds, once its
+//     condition completes; forfeited batches never do.  Conditions that haven't completed yet have no
+//     time, so stars that need them have no unlock time either.
+func (db *DB) GetLockup(p Point) (Lockup, bool) {
+	var contract Contract
+	for _, name := range []string{"LinearStarRelease", "ConditionalStarRelease"} {
+		if c, is_ok := db.FindContractByName(name); is_ok && c.Address == p.OwnerAddress {
+			contract = c
+		}
+	}
+	if contract.Name == "" {
+		return Lockup{}, false
+	}
+	ret := Lockup{Contract: contract.Name}
+
+	// Replay the calls, with each commitment under whoever it belongs to at the time
+	commitments := make(map[common.Address]*star_release_commitment)
+	get_commitment := func(participant common.Address) *star_release_commitment {
+		if _, is_ok := commitments[participant]; !is_ok {
+			commitments[participant] = &star_release_commitment{forfeited: make(map[uint8]bool)}
+		}
+		return commitments[participant]
+	}
+	for _, c := range db.get_star_release_calls(contract.Address) {
+		switch c.Function {
+		case "start_releasing":
+			ret.ReleaseStart = db.GetBlockTimestamp(c.BlockNumber)
+		case "register":
+			get_commitment(c.Participant).registration = c
+		case "deposit":
+			com := get_commitment(c.Participant)
+			com.stars = append(com.stars, c.Star)
+			if c.Star == p.Number {
+				ret.Batch = c.Batch
+			}
+		case "withdraw":
+			com := get_commitment(c.Participant)
+			if len(com.stars) != 0 {
+				com.stars = com.stars[:len(com.stars)-1]
+			}
+			com.num_withdrawn++
+		case "forfeit":
+			get_commitment(c.Caller).forfeited[c.Batch] = true
+		case "transfer_batch":
+			// From the participant to the caller
+			commitments[c.Caller] = get_commitment(c.Participant)
+			delete(commitments, c.Participant)
+		}
+	}
+
+	// Find the star
+	var com *star_release_commitment
+	index := -1
+	for participant, c := range commitments {
+		// Stars can also leave in ways that aren't tracked (e.g., the contract owner taking back
+		// forfeited stars), so skip the ones the contract doesn't own anymore
+		stars := []AzimuthNumber{}
+		for _, star := range c.stars {
+			if owner, is_ok := db.get_owner(star); !is_ok || owner == contract.Address {
+				stars = append(stars, star)
+			}
+		}
+		c.stars = stars
+		if i := slices.Index(c.stars, p.Number); i != -1 {
+			ret.Beneficiary = participant
+			com = c
+			index = i
+		}
+	}
+	if com == nil {
+		// Its deposit hasn't been fetched
+		return ret, true
+	}
+	ret.NumWithdrawn = com.num_withdrawn
+	ret.WithdrawalsNeeded = com.num_withdrawn + uint64(len(com.stars)-index)
+	ret.Rate = com.registration.Rate
+	ret.RateUnit = com.registration.RateUnit
+	if com.registration.Function == "" || ret.Rate == 0 || ret.RateUnit == 0 {
+		return ret, true
+	}
+
+	if contract.Name == "LinearStarRelease" {
+		ret.Windup = com.registration.Windup
+		ret.Amount = com.registration.Amount
+		if ret.ReleaseStart == 0 {
+			return ret, true
+		}
+		num_rate_units := (ret.WithdrawalsNeeded + uint64(ret.Rate) - 1) / uint64(ret.Rate)
+		ret.UnlockTime = ret.ReleaseStart + ret.Windup + num_rate_units*ret.RateUnit
+		return ret, true
+	}
+
+	ret.BatchSizes = parse_batch_sizes(com.registration.BatchSizes)
+	ret.BatchStartTimes = db.get_star_release_condition_times(len(ret.BatchSizes))
+	// How many stars can be withdrawn in total by time `t`
+	withdraw_limit := func(t uint64) uint64 {
+		limit := uint64(0)
+		for i, size := range ret.BatchSizes {
+			start := ret.BatchStartTimes[i]
+			if start == 0 || start > t || com.forfeited[uint8(i)] {
+				continue
+			}
+			limit += min(uint64(size), (t-start)/ret.RateUnit*uint64(ret.Rate))
+		}
+		return limit
+	}
+	// The limit only goes up when a batch releases more stars, so try each of those times in order
+	release_times := []uint64{}
+	for i, size := range ret.BatchSizes {
+		if start := ret.BatchStartTimes[i]; start != 0 && !com.forfeited[uint8(i)] {
+			for n := uint64(1); (n-1)*uint64(ret.Rate) < uint64(size); n++ {
+				release_times = append(release_times, start+n*ret.RateUnit)
+			}
+		}
+	}
+	slices.Sort(release_times)
+	for _, t := range release_times {
+		if withdraw_limit(t) >= ret.WithdrawalsNeeded {
+			ret.UnlockTime = t
+			break
+		}
+	}
+	return ret, true
+}
+
+// Parse ConditionalStarRelease's batch sizes, e.g., "2,2,4"
+func parse_batch_sizes(s string) []uint16 {
+	ret := []uint16{}
+	if s == "" {
+		return ret
+	}
+	for _, n := range strings.Split(s, ",") {
+		size, err := strconv.ParseUint(n, 10, 16)
+		if err != nil {
+			panic(err)
+		}
+		ret = append(ret, uint16(size))
+	}
+	return ret
+}
+
+// Get the owner of a point.  False if it's not in the DB.
+func (db *DB) get_owner(azimuth_number AzimuthNumber) (common.Address, bool) {
+	var ret common.Address
+	err := db.DB.Get(&ret, `select owner_address from points where azimuth_number = ?`, azimuth_number)
+	if errors.Is(err, sql.ErrNoRows) {
+		return common.Address{}, false
+	} else if err != nil {
+		panic(err)
+	}
+	return ret, true
+}
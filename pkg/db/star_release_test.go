@@ -0,0 +1,144 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "go-azimuth/pkg/db"
+)
+
+func TestLinearStarReleaseLockup(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	db, err := DBCreate(":memory:")
+	require.NoError(err)
+
+	linear := db.GetContractByName("LinearStarRelease")
+	participant := common.HexToAddress("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	new_participant := common.HexToAddress("bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+	for _, star := range []AzimuthNumber{256, 512, 768} {
+		db.DB.MustExec(`insert into points (azimuth_number, owner_address) values (?, ?)`, star, linear.Address)
+	}
+	db.DB.MustExec(`insert into points (azimuth_number, owner_address) values (1024, ?)`, participant)
+
+	call := func(block_num uint64, function string, caller common.Address, participant common.Address) StarReleaseCall {
+		return StarReleaseCall{BlockNumber: block_num, TxHash: common.Hash{byte(block_num)}.Bytes(), TraceAddress: "",
+			ContractAddress: linear.Address, Function: function, Caller: caller, Participant: participant}
+	}
+	owner := common.HexToAddress("dddddddddddddddddddddddddddddddddddddddd")
+	register := call(100, "register", owner, participant)
+	register.Windup = 1000
+	register.Amount = 3
+	register.Rate = 1
+	register.RateUnit = 100
+	calls := []StarReleaseCall{
+		register,
+		call(101, "deposit", owner, participant),
+		call(102, "deposit", owner, participant),
+		call(103, "deposit", owner, participant),
+		call(110, "start_releasing", owner, common.Address{}),
+		call(120, "transfer_batch", new_participant, participant),
+		call(121, "withdraw", new_participant, new_participant),
+	}
+	calls[1].Star = 256
+	calls[2].Star = 512
+	calls[3].Star = 768
+	db.SaveStarReleaseCalls(linear.ID, calls, nil, 130)
+	db.SaveBlock(110, common.Hash{110}, 5000)
+	db.SetFinalizedBlock(130)
+	assert.Equal(uint64(130), db.GetContractByName("LinearStarRelease").LatestBlockNumFetched)
+
+	// The last star deposited has been withdrawn already, which moved it in Azimuth too
+	db.DB.MustExec(`update points set owner_address = ? where azimuth_number = 768`, new_participant)
+
+	p, is_found := db.GetPoint(512)
+	require.True(is_found)
+	require.NotNil(p.Lockup)
+	assert.Equal("LinearStarRelease", p.Lockup.Contract)
+	assert.Equal(new_participant, p.Lockup.Beneficiary)
+	assert.Equal(uint64(5000), p.Lockup.ReleaseStart)
+	assert.Equal(uint64(1), p.Lockup.NumWithdrawn)
+	assert.Equal(uint64(2), p.Lockup.WithdrawalsNeeded)
+	assert.Equal(uint64(5000+1000+2*100), p.Lockup.UnlockTime) // Second withdrawal
+
+	p, is_found = db.GetPoint(256)
+	require.True(is_found)
+	require.NotNil(p.Lockup)
+	assert.Equal(uint64(5000+1000+3*100), p.Lockup.UnlockTime) // Third withdrawal
+
+	// Not locked up
+	p, is_found = db.GetPoint(768)
+	require.True(is_found)
+	assert.Nil(p.Lockup)
+	p, is_found = db.GetPoint(1024)
+	require.True(is_found)
+	assert.Nil(p.Lockup)
+
+	// Reorging out the batch transfer and the withdrawal
+	require.NoError(db.RollBackToBlock(120, 121, nil))
+	p, is_found = db.GetPoint(512)
+	require.True(is_found)
+	assert.Equal(participant, p.Lockup.Beneficiary)
+	assert.Equal(uint64(0), p.Lockup.NumWithdrawn)
+}
+
+func TestConditionalStarReleaseLockup(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	db, err := DBCreate(":memory:")
+	require.NoError(err)
+
+	conditional := db.GetContractByName("ConditionalStarRelease")
+	participant := common.HexToAddress("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	owner := common.HexToAddress("dddddddddddddddddddddddddddddddddddddddd")
+	stars := []AzimuthNumber{1536, 256, 512, 768, 1024, 1280}
+	// The first one isn't in the DB's points (e.g., its logs haven't been played yet)
+	for _, star := range stars[1:] {
+		db.DB.MustExec(`insert into points (azimuth_number, owner_address) values (?, ?)`, star, conditional.Address)
+	}
+
+	call := func(block_num uint64, function string, caller common.Address, participant common.Address) StarReleaseCall {
+		return StarReleaseCall{BlockNumber: block_num, TxHash: common.Hash{byte(block_num)}.Bytes(), TraceAddress: "",
+			ContractAddress: conditional.Address, Function: function, Caller: caller, Participant: participant}
+	}
+	// 3 batches of 2, 2 and 2 stars, released 1 star every 100 seconds once their condition completes
+	register := call(100, "register", owner, participant)
+	register.BatchSizes = "2,2,2"
+	register.Rate = 1
+	register.RateUnit = 100
+	calls := []StarReleaseCall{register}
+	for i, star := range stars {
+		deposit := call(101+uint64(i), "deposit", owner, participant)
+		deposit.Star = star
+		calls = append(calls, deposit)
+	}
+	forfeit := call(110, "forfeit", participant, common.Address{})
+	forfeit.Batch = 2
+	calls = append(calls, forfeit, call(120, "withdraw", participant, participant))
+	conditions := []StarReleaseCondition{
+		{BlockNumber: 115, ConditionIndex: 0, CompletedAt: 10000},
+		{BlockNumber: 118, ConditionIndex: 1, CompletedAt: 20000},
+	}
+	db.SaveStarReleaseCalls(conditional.ID, calls, conditions, 130)
+	db.SetFinalizedBlock(130)
+	db.DB.MustExec(`update points set owner_address = ? where azimuth_number = 1280`, participant)
+
+	// 1280 was withdrawn (the last one deposited); 1024 is next, then 768, 512 and 256.  Batch 0
+	// releases at 10100 and 10200, and batch 1 at 20100 and 20200.
+	for star, unlock_time := range map[AzimuthNumber]uint64{1024: 10200, 768: 20100, 512: 20200, 256: 0} {
+		p, is_found := db.GetPoint(star)
+		require.True(is_found)
+		require.NotNil(p.Lockup, star)
+		assert.Equal("ConditionalStarRelease", p.Lockup.Contract)
+		assert.Equal(participant, p.Lockup.Beneficiary)
+		assert.Equal([]uint16{2, 2, 2}, p.Lockup.BatchSizes)
+		assert.Equal([]uint64{10000, 20000, 0}, p.Lockup.BatchStartTimes)
+		// Batch 2 was forfeited, so the last stars never unlock
+		assert.Equal(unlock_time, p.Lockup.UnlockTime, star)
+	}
+}
@@ -0,0 +1,61 @@
+package db
+
+// Get the numbers of blocks after `after` that have events that haven't been verified yet, oldest
+// first
+func (db *DB) GetBlocksWithUnverifiedEvents(after uint64, limit int) []uint64 {
+	var ret []uint64
+	err := db.DB.Select(&ret, `
+		select distinct block_number
+		  from ethereum_events
+		 where is_verified = 0 and block_number > ?
+		 order by block_number
+		 limit ?`,
+		after, limit)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// Get the events in a block that haven't been verified yet, in log order
+func (db *DB) GetUnverifiedEventsInBlock(block_number uint64) []EthereumEventLog {
+	var ret []EthereumEventLog
+	err := db.DB.Select(&ret, `
+		select rowid, block_number, block_hash, tx_hash, log_index, contract_address, topic0, topic1, topic2,
+		       topic3, data, is_processed, is_verified
+		  from ethereum_events
+		 where block_number = ? and is_verified = 0
+		 order by log_index`,
+		block_number)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// Mark some events as verified
+func (db *DB) MarkEventsVerified(ids []uint64) {
+	t, err := db.DB.Beginx()
+	if err != nil {
+		panic(err)
+	}
+	for _, id := range ids {
+		t.MustExec(`update ethereum_events set is_verified = 1 where rowid = ?`, id)
+	}
+	if err := t.Commit(); err != nil {
+		panic(err)
+	}
+}
+
+// How many events there are, and how many of them have been verified
+func (db *DB) CountVerifiedEvents() (verified uint64, total uint64) {
+	var counts struct {
+		Verified uint64 `db:"verified"`
+		Total    uint64 `db:"total"`
+	}
+	err := db.DB.Get(&counts, `select coalesce(sum(is_verified), 0) verified, count(*) total from ethereum_events`)
+	if err != nil {
+		panic(err)
+	}
+	return counts.Verified, counts.Total
+}
@@ -4,57 +4,18 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"math/big"
-	"strconv"
-	"time"
+	"slices"
+	"sync"
+	"sync/atomic"
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
 
 	. "go-azimuth/pkg/db"
 )
 
-// This is insane, but it's the recommended way to do it.
-// https://github.com/ethereum/go-ethereum/issues/19766#issuecomment-963442824
-func check_error(err error) (int64, bool) {
-	var rpc_err interface {
-		Error() string
-		ErrorCode() int
-		ErrorData() interface{}
-	}
-	if errors.As(err, &rpc_err) {
-		if rpc_err.ErrorCode() == -32005 {
-			// Too many results; get the recommended "to" block
-			data, is_ok := rpc_err.ErrorData().(map[string]interface{})
-			if !is_ok {
-				panic(data)
-			}
-			_to_block_recommend, is_ok := data["to"]
-			if !is_ok {
-				panic(data)
-			}
-			to_block_recommend, is_ok := _to_block_recommend.(string)
-			if !is_ok {
-				panic(_to_block_recommend)
-			}
-			ret, err := strconv.ParseInt(to_block_recommend[2:], 16, 64)
-			if err != nil {
-				panic(err)
-			}
-			return ret, true
-		} else if rpc_err.ErrorCode() == -32603 {
-			// Service temporarily unavailable
-			// TODO: refactor this function to return a proper error
-			// For now, just return "true" and callee will assume what it means
-			return 0, true
-		}
-	}
-	return 0, false
-}
-
 // Convert it to Our Type, with sanity checks
 func ParseEthereumLog(l types.Log) EthereumEventLog {
 	event := EthereumEventLog{
@@ -75,60 +36,312 @@ func ParseEthereumLog(l types.Log) EthereumEventLog {
 	if len(l.Topics) > 2 {
 		event.Topic2 = l.Topics[2]
 	}
+	if len(l.Topics) > 3 {
+		event.Topic3 = l.Topics[3]
+	}
 
 	return event
 }
 
 // Fetches all Azimuth logs since the contract was deployed, in chunks.
-func CatchUpAzimuthLogs(client *ethclient.Client, db DB) {
-	latest_block, err := client.BlockNumber(context.Background())
+func CatchUpAzimuthLogs(ctx context.Context, source LogSource, db DB, opts Options) error {
+	latest_block, err := source.BlockNumber(ctx)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("getting latest block number: %w", err)
 	}
+	return CatchUpAzimuthLogsUntil(ctx, source, db, latest_block, opts)
+}
 
+// Fetches Azimuth logs from where the previous fetch left off, up to and including `latest_block`.
+func CatchUpAzimuthLogsUntil(ctx context.Context, source LogSource, db DB, latest_block uint64, opts Options) error {
 	contract := db.GetContractByName("Azimuth")
-
-	batch_size := int64(100000)
-	from_block := big.NewInt(0).SetUint64(max(contract.StartBlockNum, contract.LatestBlockNumFetched))
-	to_block := big.NewInt(0).Add(from_block, big.NewInt(batch_size-1))
-	for i := 0; i < 1000 && from_block.Uint64() < latest_block; i++ {
-		fmt.Printf("===================================\ni: %d\n======================================\n\n", i)
-		fmt.Printf("Azimuth contract: fetching blocks %d - %d; latest block is %d\n", from_block, to_block, latest_block)
-		query := ethereum.FilterQuery{
-			FromBlock: from_block,
-			ToBlock:   to_block,
-			Addresses: []common.Address{contract.Address},
-		}
-		logs, err := client.FilterLogs(context.Background(), query)
-		if err != nil {
-			if to_block_recommend, is_ok := check_error(err); is_ok {
-				to_block.SetInt64(to_block_recommend)
-				batch_size = to_block_recommend - from_block.Int64()
-				continue
-			}
-			log.Fatalf("Failed to fetch logs: %#v", err)
-		}
-
-		// Process the logs
+	return fetch_logs_in_ranges(ctx, source, db, contract, latest_block, opts, func(logs []types.Log) ([]EthereumEventLog, error) {
+		ret := []EthereumEventLog{}
 		for _, l := range logs {
 			azimuth_event_log := ParseEthereumLog(l)
 			if azimuth_event_log.Name == "" {
 				// Probably an Ecliptic log
 				continue
 			}
-			db.SaveEvent(&azimuth_event_log)
+			ret = append(ret, azimuth_event_log)
+		}
+		return ret, nil
+	})
+}
+
+// Fetch a contract's logs (the ones we track; see EVENT_NAMES) from where the previous fetch left
+// off, up to and including `latest_block`.  For contracts whose logs don't need anything else.  If
+// the network doesn't have that contract, there's nothing to fetch.
+func fetch_tracked_logs(
+	ctx context.Context, source LogSource, db DB, contract_name string, latest_block uint64, opts Options,
+) error {
+	contract, is_ok := db.FindContractByName(contract_name)
+	if !is_ok {
+		return nil
+	}
+	return fetch_logs_in_ranges(ctx, source, db, contract, latest_block, opts, parse_tracked_logs)
+}
+
+// Convert logs to events, leaving out the ones we don't track
+func parse_tracked_logs(logs []types.Log) ([]EthereumEventLog, error) {
+	ret := []EthereumEventLog{}
+	for _, l := range logs {
+		event_log := ParseEthereumLog(l)
+		if event_log.Name == "" {
+			// Something we don't track
+			continue
+		}
+		ret = append(ret, event_log)
+	}
+	return ret, nil
+}
+
+// How many blocks to fetch logs from at once, to start with.  Ranges with few logs in them make the
+// next ones bigger (up to MAX_LOG_RANGE_SIZE), and ranges with too many get split up and make the
+// next ones smaller.
+const (
+	LOG_RANGE_SIZE     = 100000
+	MAX_LOG_RANGE_SIZE = 3200000
+	MIN_LOG_RANGE_SIZE = 1000
+)
+
+// A range with fewer logs than this is "sparse", so the next one can be bigger
+const SPARSE_RANGE_NUM_LOGS = 1000
+
+// The logs from a range of blocks, converted to events and ready to save
+type fetched_range struct {
+	BlockRange
+	events   []EthereumEventLog
+	blocks   []BlockInfo
+	txs      []TransactionInfo // Only with `opts.FetchSenders`, for Azimuth
+	num_logs int
+	is_split bool // Whether the node said it had too many logs, so it had to be fetched in pieces
+	err      error
+}
+
+// Fetch a contract's logs from where the previous fetch left off, up to and including `latest_block`.
+//
+// The blocks are split into ranges, which get fetched by `opts.Workers` goroutines at once.  Each
+// range's logs are passed to `handle_logs`, which converts them to events (fetching anything else
+// they need).  The ranges are saved in block order, by the calling goroutine: a range's events are
+// saved and the range is marked as fetched, in one go, and only once every range before it has
+// been.  So if anything fails, everything before the failed range is saved, and running it again
+// picks up from there.  Same if `ctx` gets cancelled.
+//
+// The ranges' size adapts to how many logs there are (see LOG_RANGE_SIZE).  Since several get
+// fetched at once, it only affects the ranges that haven't been handed out yet.
+//
+// `handle_logs` gets called from the worker goroutines, so it mustn't touch the DB.
+func fetch_logs_in_ranges(
+	ctx context.Context, source LogSource, db DB, contract Contract, latest_block uint64, opts Options,
+	handle_logs func([]types.Log) ([]EthereumEventLog, error),
+) error {
+	// Start from the block after the last one fetched; that one's logs are already saved
+	first_block := max(contract.StartBlockNum, contract.LatestBlockNumFetched+1)
+	return fetch_logs_between(ctx, source, db, contract, first_block, latest_block, opts, handle_logs)
+}
+
+// Fetch a contract's logs from `first_block` up to and including `latest_block`, as described in
+// `fetch_logs_in_ranges`.  Blocks that were fetched already can be fetched again; events that are
+// already saved are left alone.
+func fetch_logs_between(
+	ctx context.Context, source LogSource, db DB, contract Contract, first_block uint64, latest_block uint64,
+	opts Options, handle_logs func([]types.Log) ([]EthereumEventLog, error),
+) error {
+	if first_block > latest_block {
+		return nil
+	}
+	num_workers := max(opts.Workers, 1)
+	var range_size atomic.Uint64
+	range_size.Store(LOG_RANGE_SIZE)
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer opts.Meter.Save(db) // Including whatever got spent on ranges that didn't get saved
+	defer wg.Wait()
+	defer cancel() // (Runs first)
+
+	// Hand out the ranges in order.  Each one's result goes in its own slot, and the slots get queued
+	// up in the same order, for saving.  Don't get too far ahead of the ones being saved, so fetched
+	// ranges don't pile up in memory.
+	type range_job struct {
+		BlockRange
+		result chan fetched_range
+	}
+	jobs := make(chan range_job)
+	results := make(chan chan fetched_range, 2*num_workers)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+		defer close(results)
+		for from_block := first_block; from_block <= latest_block; {
+			to_block := min(latest_block, from_block+range_size.Load()-1)
+			job := range_job{BlockRange{FromBlock: from_block, ToBlock: to_block}, make(chan fetched_range, 1)}
+			select {
+			case <-ctx.Done():
+				return
+			case results <- job.result:
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case jobs <- job:
+			}
+			from_block = to_block + 1
+		}
+	}()
+
+	// Workers fetch the ranges, and adjust the size of the next ones
+	for range num_workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				r := fetch_log_range(ctx, source, contract, job.FromBlock, job.ToBlock, opts, handle_logs)
+				job.result <- r
+				if r.err != nil {
+					continue
+				}
+				size := range_size.Load()
+				if r.is_split {
+					range_size.CompareAndSwap(size, max(size/2, MIN_LOG_RANGE_SIZE))
+				} else if r.num_logs < SPARSE_RANGE_NUM_LOGS {
+					range_size.CompareAndSwap(size, min(size*2, MAX_LOG_RANGE_SIZE))
+				}
+			}
+		}()
+	}
+
+	// Save them in order
+	for result := range results {
+		var r fetched_range
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s contract: %w", contract.Name, ctx.Err())
+		case r = <-result:
+		}
+		if r.err != nil {
+			return r.err
+		}
+		db.SaveFetchedEvents(contract.ID, r.events, r.blocks, r.txs, r.BlockRange)
+		opts.Meter.Save(db)
+		ReportProgress(opts.Progress, Progress{
+			Task:         contract.Name + " contract: fetching logs",
+			Done:         r.ToBlock - first_block + 1,
+			Total:        latest_block - first_block + 1,
+			CurrentBlock: r.ToBlock,
+			CreditsUsed:  opts.Meter.Used(),
+		})
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("%s contract: %w", contract.Name, err)
 		}
+	}
+	// Either they're all saved, or it got cancelled before handing them all out
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s contract: %w", contract.Name, err)
+	}
+	return nil
+}
+
+// Fetch the logs from one range of blocks (splitting it up if there are too many), convert them to
+// events with `handle_logs`, and get the times of the blocks they're in (and the senders of their
+// transactions, if `opts.FetchSenders` and it's Azimuth; other contracts' events don't have sender
+// roles)
+func fetch_log_range(
+	ctx context.Context, source LogSource, contract Contract, from_block uint64, to_block uint64, opts Options,
+	handle_logs func([]types.Log) ([]EthereumEventLog, error),
+) fetched_range {
+	ret := fetched_range{BlockRange: BlockRange{FromBlock: from_block, ToBlock: to_block}}
+	logs, is_split, err := filter_logs_splitting(ctx, source, contract, from_block, to_block)
+	if err != nil {
+		ret.err = err
+		return ret
+	}
+	ret.num_logs = len(logs)
+	ret.is_split = is_split
+
+	// Process the logs
+	ret.events, err = handle_logs(logs)
+	if err != nil {
+		ret.err = fmt.Errorf("%s contract: processing blocks %d - %d: %w", contract.Name, from_block, to_block, err)
+		return ret
+	}
 
-		// Update latest-block-fetched
-		db.SetLatestContractBlockFetched(contract.ID, min(latest_block, to_block.Uint64()))
+	// Get the times of the blocks they're in
+	block_nums := []uint64{}
+	for _, e := range ret.events {
+		block_nums = append(block_nums, e.BlockNumber)
+	}
+	slices.Sort(block_nums)
+	block_nums = slices.Compact(block_nums)
+	ret.blocks, err = get_block_infos(ctx, source, block_nums)
+	if err != nil {
+		ret.err = fmt.Errorf("%s contract: blocks %d - %d: %w", contract.Name, from_block, to_block, err)
+		return ret
+	}
 
-		// Compute next batch size adaptively
-		if len(logs) < 1000 {
-			batch_size *= 2
+	if opts.FetchSenders && contract.Name == "Azimuth" {
+		tx_hashes := []common.Hash{}
+		for _, e := range ret.events {
+			if !slices.Contains(tx_hashes, e.TxHash) {
+				tx_hashes = append(tx_hashes, e.TxHash)
+			}
+		}
+		ret.txs, err = get_transaction_infos(ctx, source, tx_hashes)
+		if err != nil {
+			ret.err = fmt.Errorf("%s contract: blocks %d - %d: %w", contract.Name, from_block, to_block, err)
 		}
+	}
+	return ret
+}
 
-		from_block.Add(to_block, big.NewInt(1))
-		to_block.Add(from_block, big.NewInt(batch_size-1))
-		time.Sleep(1 * time.Second)
+// Get a contract's logs from a range of blocks.  If the node says there are too many, get them in
+// smaller pieces instead (and say so).
+func filter_logs_splitting(
+	ctx context.Context, source LogSource, contract Contract, from_block uint64, to_block uint64,
+) ([]types.Log, bool, error) {
+	logs, is_split, err := get_splitting(from_block, to_block, func(from_block, to_block uint64) ([]types.Log, error) {
+		return source.FilterLogs(ctx, ethereum.FilterQuery{
+			FromBlock: big.NewInt(0).SetUint64(from_block),
+			ToBlock:   big.NewInt(0).SetUint64(to_block),
+			Addresses: []common.Address{contract.Address},
+		})
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("%s contract: fetching %w", contract.Name, err)
+	}
+	return logs, is_split, nil
+}
+
+// Get the results of `get` for a range of blocks.  If the node says there are too many, split the
+// range (where it recommends, if it does) and get each half the same way.  Returns whether it had to
+// split.
+func get_splitting[T any](
+	from_block uint64, to_block uint64, get func(from_block, to_block uint64) ([]T, error),
+) ([]T, bool, error) {
+	ret, err := get(from_block, to_block)
+	var too_many_results_err *TooManyResultsError
+	if errors.As(err, &too_many_results_err) {
+		if to_block == from_block {
+			return nil, false, fmt.Errorf("block %d: %w", from_block, err)
+		}
+		split_at := from_block + (to_block-from_block)/2
+		if too_many_results_err.HasRecommendation && too_many_results_err.RecommendedToBlock >= from_block &&
+			too_many_results_err.RecommendedToBlock < to_block {
+			split_at = too_many_results_err.RecommendedToBlock
+		}
+		first_half, _, err := get_splitting(from_block, split_at, get)
+		if err != nil {
+			return nil, false, err
+		}
+		second_half, _, err := get_splitting(split_at+1, to_block, get)
+		if err != nil {
+			return nil, false, err
+		}
+		return append(first_half, second_half...), true, nil
+	} else if err != nil {
+		return nil, false, fmt.Errorf("blocks %d - %d: %w", from_block, to_block, err)
 	}
+	return ret, false, nil
 }
@@ -0,0 +1,468 @@
+package scraper
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// How often to ask the node what the finalized block is, at most.  It only moves every few minutes.
+const CACHE_FINALIZED_REFRESH_INTERVAL = 30 * time.Second
+
+// A LogSource that saves the responses it gets from another one in a directory, and answers from
+// there the next time the same thing is asked for.  So re-fetching (e.g., rebuilding a DB from
+// scratch) doesn't cost anything, and if everything's in there, it can be done with no node at all
+// (see `NewOfflineCachingSource`).
+//
+// Only things that can't change get cached: log ranges and call traces up to the finalized block,
+// blocks (and their receipts) up to the finalized block, and transactions by hash.  A range that
+// goes past the finalized block gets split there, so the final part still gets cached.
+//
+// Each response is a JSON file, named after the hash of the method and its params.  It's safe to
+// use from several goroutines (or processes) at once.
+//
+// The same request (e.g., block 100) gets a different answer on every chain, so each chain gets its
+// own subdirectory of the cache directory, named after its chain ID.
+type CachingSource struct {
+	LogSource        // Nil if offline
+	Dir       string // This chain's subdirectory
+
+	mutex           sync.Mutex
+	finalized_block uint64 // The newest one seen so far
+	last_refreshed  time.Time
+}
+
+// Cache the responses from `source`, which has to be for the chain with ID `chain_id`, in `dir`
+func NewCachingSource(source LogSource, dir string, chain_id uint64) (*CachingSource, error) {
+	dir = chain_cache_dir(dir, chain_id)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating cache directory: %w", err)
+	}
+	ret := &CachingSource{LogSource: source, Dir: dir}
+	// Saved for offline use
+	data, err := os.ReadFile(filepath.Join(dir, "finalized_block"))
+	if err == nil {
+		ret.finalized_block, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("reading the cache's finalized block: %w", err)
+	}
+	return ret, nil
+}
+
+// A CachingSource with no node behind it; anything that isn't cached fails with ErrNotCached.  The
+// chain ends at the newest finalized block the cache has seen.  Only what was cached for the chain
+// with ID `chain_id` gets used.
+func NewOfflineCachingSource(dir string, chain_id uint64) (*CachingSource, error) {
+	if _, err := os.Stat(chain_cache_dir(dir, chain_id)); errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: nothing in %s for chain ID %d", ErrNotCached, dir, chain_id)
+	}
+	return NewCachingSource(nil, dir, chain_id)
+}
+
+func chain_cache_dir(dir string, chain_id uint64) string {
+	return filepath.Join(dir, "chain-"+strconv.FormatUint(chain_id, 10))
+}
+
+func (s *CachingSource) is_offline() bool {
+	return s.LogSource == nil
+}
+
+// Whether a block is finalized.  Asks the node if it's newer than the last finalized block it
+// knows of (unless it asked recently).
+func (s *CachingSource) is_final(ctx context.Context, block_num uint64) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if block_num <= s.finalized_block || s.is_offline() ||
+		time.Since(s.last_refreshed) < CACHE_FINALIZED_REFRESH_INTERVAL {
+		return block_num <= s.finalized_block, nil
+	}
+
+	finalized_block, err := s.LogSource.TaggedBlockNumber(ctx, "finalized")
+	if err != nil {
+		return false, err
+	}
+	s.last_refreshed = time.Now()
+	if finalized_block > s.finalized_block {
+		s.finalized_block = finalized_block
+		err := os.WriteFile(filepath.Join(s.Dir, "finalized_block"), []byte(strconv.FormatUint(finalized_block, 10)), 0o644)
+		if err != nil {
+			return false, fmt.Errorf("writing the cache's finalized block: %w", err)
+		}
+	}
+	return block_num <= s.finalized_block, nil
+}
+
+func (s *CachingSource) get_finalized_block() uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.finalized_block
+}
+
+func (s *CachingSource) path(method string, params interface{}) string {
+	key, err := json.Marshal([]interface{}{method, params})
+	if err != nil {
+		panic(err) // Params are all plain data
+	}
+	hash := sha256.Sum256(key)
+	name := hex.EncodeToString(hash[:])
+	return filepath.Join(s.Dir, method, name[:2], name+".json")
+}
+
+// Look up a response.  Anything unreadable counts as a miss, so it gets fetched (and saved) again.
+func (s *CachingSource) get(method string, params interface{}, result interface{}) bool {
+	return read_cache_file(s.path(method, params), result)
+}
+
+func read_cache_file(path string, result interface{}) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(data, result) == nil
+}
+
+// Save a response
+func (s *CachingSource) put(method string, params interface{}, result interface{}) error {
+	return write_cache_file(s.path(method, params), method, result)
+}
+
+// It's written to a temp file first and then moved into place, so a crash (or another process
+// reading it) never sees half a file.
+func write_cache_file(path string, method string, result interface{}) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("caching %s: %w", method, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("caching %s: %w", method, err)
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), "tmp-*")
+	if err != nil {
+		return fmt.Errorf("caching %s: %w", method, err)
+	}
+	_, err = file.Write(data)
+	if close_err := file.Close(); err == nil {
+		err = close_err
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("caching %s: %w", method, err)
+	}
+	return nil
+}
+
+// A range of blocks whose results (e.g., logs) are cached, in a file of their own
+type cached_block_range struct {
+	from_block uint64
+	to_block   uint64
+}
+
+// Where the ranges for a query (everything but the blocks) go: a directory per method and query, with
+// a file per range named after its blocks
+func (s *CachingSource) range_dir(method string, params interface{}) string {
+	key, err := json.Marshal([]interface{}{method, params})
+	if err != nil {
+		panic(err) // Params are all plain data
+	}
+	hash := sha256.Sum256(key)
+	return filepath.Join(s.Dir, method, hex.EncodeToString(hash[:]))
+}
+
+func range_file(dir string, r cached_block_range) string {
+	return filepath.Join(dir, fmt.Sprintf("%d-%d.json", r.from_block, r.to_block))
+}
+
+// The ranges that are cached in a directory, in block order
+func list_cached_ranges(dir string) []cached_block_range {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil // Nothing cached yet
+	}
+	ret := []cached_block_range{}
+	for _, e := range entries {
+		var r cached_block_range
+		if _, err := fmt.Sscanf(e.Name(), "%d-%d.json", &r.from_block, &r.to_block); err != nil {
+			continue // E.g., a temp file
+		}
+		ret = append(ret, r)
+	}
+	slices.SortFunc(ret, func(a, b cached_block_range) int {
+		return cmp.Or(cmp.Compare(a.from_block, b.from_block), cmp.Compare(a.to_block, b.to_block))
+	})
+	return ret
+}
+
+// Get something over a range of blocks (like logs), from the cache where possible.  `block_of` says
+// which block a result is in.
+//
+// The cache doesn't have to have the exact same range; any cached ranges that cover it get joined
+// together (and trimmed to it).  That matters because ranges aren't the same from run to run (their
+// sizes adapt, several get fetched at once, and `watch` asks for small ones), so a rebuild from
+// scratch asks for different ones than the first run did.  Whatever isn't covered gets fetched,
+// gap by gap, and the part of each gap up to the finalized block gets cached as a range of its own.
+//
+// If the node says a gap has too many results, the error gets returned as-is, so the caller splits
+// up its range (see `get_splitting`).  Offline, the covered parts can't have too many.
+func cached_range[T any](
+	ctx context.Context, s *CachingSource, method string, from_block uint64, to_block uint64, params interface{},
+	block_of func(result T) uint64,
+	fetch func(from_block uint64, to_block uint64) ([]T, error),
+) ([]T, error) {
+	dir := s.range_dir(method, params)
+	cached := list_cached_ranges(dir)
+	ret := []T{}
+	for block := from_block; block <= to_block; {
+		// The cached range that goes furthest from here, if any
+		covering, is_covered := cached_block_range{}, false
+		next_cached := to_block + 1
+		for _, r := range cached {
+			if r.from_block <= block && r.to_block >= block && (!is_covered || r.to_block > covering.to_block) {
+				covering, is_covered = r, true
+			} else if r.from_block > block {
+				next_cached = min(next_cached, r.from_block)
+			}
+		}
+
+		if is_covered {
+			piece_end := min(covering.to_block, to_block)
+			var results []T
+			if read_cache_file(range_file(dir, covering), &results) {
+				for _, result := range results {
+					if n := block_of(result); n >= block && n <= piece_end {
+						ret = append(ret, result)
+					}
+				}
+				block = piece_end + 1
+				continue
+			}
+			// Unreadable; fetch it again
+			next_cached = piece_end + 1
+		}
+
+		gap_end := next_cached - 1
+		if s.is_offline() {
+			return nil, fmt.Errorf("%s from block %d to %d: %w", method, block, gap_end, ErrNotCached)
+		}
+		results, err := fetch_gap(ctx, s, method, dir, block, gap_end, fetch)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, results...)
+		block = gap_end + 1
+	}
+	return ret, nil
+}
+
+// Fetch a range of blocks that isn't cached, and cache the part of it that's final
+func fetch_gap[T any](
+	ctx context.Context, s *CachingSource, method string, dir string, from_block uint64, to_block uint64,
+	fetch func(from_block uint64, to_block uint64) ([]T, error),
+) ([]T, error) {
+	is_final, err := s.is_final(ctx, to_block)
+	if err != nil {
+		return nil, err
+	}
+	if is_final {
+		ret, err := fetch(from_block, to_block)
+		if err != nil {
+			return nil, err
+		}
+		err = write_cache_file(range_file(dir, cached_block_range{from_block, to_block}), method, ret)
+		if err != nil {
+			return nil, err
+		}
+		return ret, nil
+	}
+	finalized_block := s.get_finalized_block()
+	if from_block > finalized_block {
+		return fetch(from_block, to_block)
+	}
+	final_part, err := fetch_gap(ctx, s, method, dir, from_block, finalized_block, fetch)
+	if err != nil {
+		return nil, err
+	}
+	rest, err := fetch(finalized_block+1, to_block)
+	if err != nil {
+		return nil, err
+	}
+	return append(final_part, rest...), nil
+}
+
+// Get a bunch of things (like transactions), from the cache where possible.  The rest get fetched
+// all together, and the ones that are final get cached.  `block_of` says which block a fetched one
+// is in, and whether it should be cached at all.
+func cached_batch[K any, T any](
+	ctx context.Context, s *CachingSource, method string, keys []K,
+	fetch func(keys []K) ([]T, error),
+	block_of func(key K, result T) (uint64, bool),
+) ([]T, error) {
+	ret := make([]T, len(keys))
+	missing := []K{}
+	missing_indexes := []int{}
+	for i, k := range keys {
+		if !s.get(method, k, &ret[i]) {
+			missing = append(missing, k)
+			missing_indexes = append(missing_indexes, i)
+		}
+	}
+	if len(missing) == 0 {
+		return ret, nil
+	}
+	if s.is_offline() {
+		return nil, fmt.Errorf("%s%v: %w", method, missing, ErrNotCached)
+	}
+
+	fetched, err := fetch(missing)
+	if err != nil {
+		return nil, err
+	}
+	for i, result := range fetched {
+		ret[missing_indexes[i]] = result
+		block_num, is_cacheable := block_of(missing[i], result)
+		if !is_cacheable {
+			continue
+		}
+		if is_final, err := s.is_final(ctx, block_num); err != nil {
+			return nil, err
+		} else if is_final {
+			if err := s.put(method, missing[i], result); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return ret, nil
+}
+
+// Not cached; the cache directory is already per-chain
+func (s *CachingSource) ChainID(ctx context.Context) (uint64, error) {
+	if s.is_offline() {
+		return 0, fmt.Errorf("eth_chainId: %w", ErrNotCached)
+	}
+	return s.LogSource.ChainID(ctx)
+}
+
+// Offline, the chain ends at the newest finalized block the cache has seen
+func (s *CachingSource) BlockNumber(ctx context.Context) (uint64, error) {
+	if s.is_offline() {
+		return s.get_finalized_block(), nil
+	}
+	return s.LogSource.BlockNumber(ctx)
+}
+
+func (s *CachingSource) TaggedBlockNumber(ctx context.Context, tag string) (uint64, error) {
+	if s.is_offline() {
+		return s.get_finalized_block(), nil
+	}
+	return s.LogSource.TaggedBlockNumber(ctx, tag)
+}
+
+// The parts of a log query that go in the cache key (the blocks are what's cached under it; see
+// `cached_range`)
+type log_query_key struct {
+	Addresses []common.Address `json:"addresses"`
+	Topics    [][]common.Hash  `json:"topics"`
+}
+
+func (s *CachingSource) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	if q.BlockHash != nil || q.FromBlock == nil || q.ToBlock == nil {
+		// Not a range of block numbers; can't tell if it's final
+		if s.is_offline() {
+			return nil, fmt.Errorf("eth_getLogs: %w", ErrNotCached)
+		}
+		return s.LogSource.FilterLogs(ctx, q)
+	}
+	return cached_range(ctx, s, "eth_getLogs", q.FromBlock.Uint64(), q.ToBlock.Uint64(),
+		log_query_key{Addresses: q.Addresses, Topics: q.Topics},
+		func(l types.Log) uint64 {
+			return l.BlockNumber
+		},
+		func(from_block uint64, to_block uint64) ([]types.Log, error) {
+			q.FromBlock = new(big.Int).SetUint64(from_block)
+			q.ToBlock = new(big.Int).SetUint64(to_block)
+			return s.LogSource.FilterLogs(ctx, q)
+		})
+}
+
+// A transaction never changes, even if its block gets reorged out
+func (s *CachingSource) TransactionsByHash(ctx context.Context, hashes []common.Hash) ([]Transaction, error) {
+	return cached_batch(ctx, s, "eth_getTransactionByHash", hashes,
+		func(hashes []common.Hash) ([]Transaction, error) {
+			return s.LogSource.TransactionsByHash(ctx, hashes)
+		},
+		func(common.Hash, Transaction) (uint64, bool) {
+			return 0, true
+		})
+}
+
+func (s *CachingSource) BlockHeaders(ctx context.Context, block_nums []uint64) ([]BlockHeader, error) {
+	return cached_batch(ctx, s, "eth_getBlockByNumber", block_nums,
+		func(block_nums []uint64) ([]BlockHeader, error) {
+			return s.LogSource.BlockHeaders(ctx, block_nums)
+		},
+		func(n uint64, header BlockHeader) (uint64, bool) {
+			return n, header.Hash != (common.Hash{}) // Blocks that don't exist yet might later
+		})
+}
+
+func (s *CachingSource) Blocks(ctx context.Context, block_nums []uint64) ([]Block, error) {
+	return cached_batch(ctx, s, "eth_getBlockByNumber-full", block_nums,
+		func(block_nums []uint64) ([]Block, error) {
+			return s.LogSource.Blocks(ctx, block_nums)
+		},
+		func(n uint64, _ Block) (uint64, bool) {
+			return n, true
+		})
+}
+
+func (s *CachingSource) BlockReceipts(ctx context.Context, block_nums []uint64) ([][]Receipt, error) {
+	return cached_batch(ctx, s, "eth_getBlockReceipts", block_nums,
+		func(block_nums []uint64) ([][]Receipt, error) {
+			return s.LogSource.BlockReceipts(ctx, block_nums)
+		},
+		func(n uint64, _ []Receipt) (uint64, bool) {
+			return n, true
+		})
+}
+
+// A receipt depends on which block the transaction ended up in, so it's only final once that is
+func (s *CachingSource) TransactionReceipts(ctx context.Context, hashes []common.Hash) ([]Receipt, error) {
+	return cached_batch(ctx, s, "eth_getTransactionReceipt", hashes,
+		func(hashes []common.Hash) ([]Receipt, error) {
+			return s.LogSource.TransactionReceipts(ctx, hashes)
+		},
+		func(_ common.Hash, r Receipt) (uint64, bool) {
+			return uint64(r.BlockNumber), true
+		})
+}
+
+func (s *CachingSource) TraceCalls(
+	ctx context.Context, from_block uint64, to_block uint64, to_address common.Address,
+) ([]CallTrace, error) {
+	return cached_range(ctx, s, "trace_filter", from_block, to_block, to_address,
+		func(t CallTrace) uint64 {
+			return t.BlockNumber
+		},
+		func(from_block uint64, to_block uint64) ([]CallTrace, error) {
+			return s.LogSource.TraceCalls(ctx, from_block, to_block, to_address)
+		})
+}
@@ -0,0 +1,131 @@
+package scraper_test
+
+import (
+	"context"
+	"math/big"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "go-azimuth/pkg/db"
+	. "go-azimuth/pkg/scraper"
+)
+
+// A LogSource that counts log queries, and whose chain is only finalized up to a certain block
+type counting_source struct {
+	FileLogSource
+	finalized_block uint64
+	num_queries     *atomic.Int64
+}
+
+func (s counting_source) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	s.num_queries.Add(1)
+	return s.FileLogSource.FilterLogs(ctx, q)
+}
+
+func (s counting_source) TaggedBlockNumber(ctx context.Context, tag string) (uint64, error) {
+	return s.finalized_block, nil
+}
+
+func TestCachingSource(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	file_source, err := NewFileLogSource("testdata/logs.ndjson")
+	require.NoError(err)
+	// The Azimuth logs are final, the Naive one isn't
+	source := counting_source{FileLogSource: file_source, finalized_block: 13369128, num_queries: new(atomic.Int64)}
+	dir := t.TempDir()
+	cache, err := NewCachingSource(source, dir, 1)
+	require.NoError(err)
+
+	ctx := context.Background()
+	new_db := func() DB {
+		db, err := DBCreate(":memory:")
+		require.NoError(err)
+		db.SetLatestContractBlockFetched(db.GetContractByName("Azimuth").ID, 13369000)
+		return db
+	}
+	db := new_db()
+	require.NoError(CatchUpAzimuthLogs(ctx, cache, db, Options{}))
+	assert.Equal(int64(2), source.num_queries.Load()) // Split at the finalized block
+
+	// Again, only the part that isn't final gets fetched
+	db = new_db()
+	require.NoError(CatchUpAzimuthLogs(ctx, cache, db, Options{}))
+	assert.Equal(int64(3), source.num_queries.Load())
+	var num_events int
+	require.NoError(db.DB.Get(&num_events, `select count(*) from ethereum_events`))
+	assert.Equal(2, num_events)
+
+	// Offline, the chain ends at the finalized block
+	offline, err := NewOfflineCachingSource(dir, 1)
+	require.NoError(err)
+	db = new_db()
+	require.NoError(CatchUpAzimuthLogs(ctx, offline, db, Options{}))
+	assert.Equal(uint64(13369128), db.GetContractByName("Azimuth").LatestBlockNumFetched)
+	require.NoError(db.DB.Get(&num_events, `select count(*) from ethereum_events`))
+	assert.Equal(2, num_events)
+	assert.Equal(int64(3), source.num_queries.Load())
+
+	// Anything that isn't cached can't be fetched
+	_, err = offline.FilterLogs(ctx, ethereum.FilterQuery{FromBlock: big.NewInt(13369129), ToBlock: big.NewInt(13369856)})
+	assert.ErrorIs(err, ErrNotCached)
+
+	// Nor can another chain's
+	_, err = NewOfflineCachingSource(dir, 11155111)
+	assert.ErrorIs(err, ErrNotCached)
+	other_chain, err := NewCachingSource(source, dir, 11155111)
+	require.NoError(err)
+	_, err = other_chain.FilterLogs(ctx, ethereum.FilterQuery{FromBlock: big.NewInt(13369000), ToBlock: big.NewInt(13369128)})
+	require.NoError(err)
+	assert.Equal(int64(4), source.num_queries.Load())
+}
+
+func TestOfflineRebuildFromDifferentRanges(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	file_source, err := NewFileLogSource("testdata/logs.ndjson")
+	require.NoError(err)
+	source := counting_source{FileLogSource: file_source, finalized_block: 13369856, num_queries: new(atomic.Int64)}
+	dir := t.TempDir()
+	cache, err := NewCachingSource(source, dir, 1)
+	require.NoError(err)
+
+	// Warm the cache in two goes, one range at a time, so its ranges end at block 13369500
+	ctx := context.Background()
+	db, err := DBCreate(":memory:")
+	require.NoError(err)
+	for _, latest_block := range []uint64{13369500, 13369856} {
+		require.NoError(CatchUpAzimuthLogsUntil(ctx, cache, db, latest_block, Options{Workers: 1}))
+		require.NoError(CatchUpNaiveLogsUntil(ctx, cache, db, latest_block, Options{Workers: 1}))
+	}
+	num_queries := source.num_queries.Load()
+
+	// Rebuilding from scratch, all at once and with several workers, asks for different ranges; they
+	// get pieced together from the cached ones
+	offline, err := NewOfflineCachingSource(dir, 1)
+	require.NoError(err)
+	db, err = DBCreate(":memory:")
+	require.NoError(err)
+	require.NoError(CatchUpAzimuthLogs(ctx, offline, db, Options{Workers: 4}))
+	require.NoError(CatchUpNaiveLogs(ctx, offline, db, Options{Workers: 4}))
+	assert.Equal(uint64(13369856), db.GetContractByName("Azimuth").LatestBlockNumFetched)
+	assert.Equal(uint64(13369856), db.GetContractByName("Naive").LatestBlockNumFetched)
+	var num_events int
+	require.NoError(db.DB.Get(&num_events, `select count(*) from ethereum_events`))
+	assert.Equal(3, num_events)
+	assert.Equal(uint64(0), db.CountUnplayedBatchesMissingCallData())
+
+	// Online, nothing gets fetched again either
+	db, err = DBCreate(":memory:")
+	require.NoError(err)
+	require.NoError(CatchUpAzimuthLogs(ctx, cache, db, Options{Workers: 4}))
+	require.NoError(CatchUpNaiveLogs(ctx, cache, db, Options{Workers: 4}))
+	assert.Equal(num_queries, source.num_queries.Load())
+}
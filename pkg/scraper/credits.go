@@ -0,0 +1,246 @@
+package scraper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	. "go-azimuth/pkg/db"
+)
+
+// How many credits each RPC method costs on Infura.  A batch costs the sum of the calls in it.
+var DEFAULT_CREDIT_COSTS = map[string]uint64{
+	"eth_blockNumber":           80,
+	"eth_getBlockByNumber":      80,
+	"eth_getTransactionByHash":  80,
+	"eth_getLogs":               255,
+	"eth_getBlockReceipts":      1000,
+	"eth_getTransactionReceipt": 80,
+	"trace_filter":              300, // Votes, lockups, and Naive batches sent through other contracts
+}
+
+// What a method that isn't in the cost table costs
+const DEFAULT_CREDIT_COST = 80
+
+// Load a cost table from a JSON file (an object of method name => credits), on top of the default
+// one
+func LoadCreditCosts(path string) (map[string]uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading credit costs: %w", err)
+	}
+	costs := map[string]uint64{}
+	if err := json.Unmarshal(data, &costs); err != nil {
+		return nil, fmt.Errorf("parsing credit costs %q: %w", path, err)
+	}
+	ret := maps.Clone(DEFAULT_CREDIT_COSTS)
+	maps.Copy(ret, costs)
+	return ret, nil
+}
+
+// Keeps track of how many RPC credits have been used, and refuses calls that would go over budget.
+// Safe to use from several goroutines at once.
+//
+// The daily usage is kept in the DB (see `Load` and `Save`), so the daily budget counts every run
+// on the same DB.  Days are UTC.
+type CreditMeter struct {
+	Costs       map[string]uint64 // Nil means DEFAULT_CREDIT_COSTS
+	Budget      uint64            // The most credits this run can use.  0 means no limit.
+	DailyBudget uint64            // The most credits that can be used in a day.  0 means no limit.
+
+	mutex      sync.Mutex
+	used       uint64            // This run
+	day        string            // Today
+	used_today uint64            // Including earlier runs
+	unsaved    map[string]uint64 // Credits used that aren't in the DB yet, by day
+	calls      map[string]uint64 // How many times each method was called this run
+	spent      map[string]uint64 // How many credits each method used this run
+}
+
+func today() string {
+	return time.Now().UTC().Format(time.DateOnly)
+}
+
+// Charge for some calls to a method.  Returns ErrBudgetExhausted (and doesn't charge anything) if
+// they'd go over budget.
+func (m *CreditMeter) charge(method string, num_calls int) error {
+	costs := m.Costs
+	if costs == nil {
+		costs = DEFAULT_CREDIT_COSTS
+	}
+	cost, is_ok := costs[method]
+	if !is_ok {
+		cost = DEFAULT_CREDIT_COST
+	}
+	cost *= uint64(num_calls)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if day := today(); day != m.day {
+		// Nothing else could have used credits on this DB today, since this run is still going
+		m.day = day
+		m.used_today = 0
+	}
+	if m.Budget != 0 && m.used+cost > m.Budget {
+		return fmt.Errorf("%w: %s would take it to %d of %d credits for this run", ErrBudgetExhausted, method,
+			m.used+cost, m.Budget)
+	}
+	if m.DailyBudget != 0 && m.used_today+cost > m.DailyBudget {
+		return fmt.Errorf("%w: %s would take it to %d of %d credits for today", ErrBudgetExhausted, method,
+			m.used_today+cost, m.DailyBudget)
+	}
+
+	if m.unsaved == nil {
+		m.unsaved = map[string]uint64{}
+		m.calls = map[string]uint64{}
+		m.spent = map[string]uint64{}
+	}
+	m.used += cost
+	m.used_today += cost
+	m.unsaved[m.day] += cost
+	m.calls[method] += uint64(num_calls)
+	m.spent[method] += cost
+	return nil
+}
+
+// How many credits have been used this run.  0 if there's no meter.
+func (m *CreditMeter) Used() uint64 {
+	if m == nil {
+		return 0
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.used
+}
+
+// Get how many credits were already used today, from the DB
+func (m *CreditMeter) Load(db DB) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.day = today()
+	m.used_today = db.GetCreditsUsedOn(m.day) + m.unsaved[m.day]
+}
+
+// Save the credits used since the last save in the DB.  Does nothing if there's no meter.
+func (m *CreditMeter) Save(db DB) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for day, credits := range m.unsaved {
+		db.AddCreditsUsed(day, credits)
+	}
+	clear(m.unsaved)
+}
+
+// One line per method: how many calls, and how many credits.  Then the total.
+func (m *CreditMeter) Summary() string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	methods := []string{}
+	for method := range m.calls {
+		methods = append(methods, method)
+	}
+	slices.Sort(methods)
+	ret := ""
+	for _, method := range methods {
+		ret += fmt.Sprintf("%-26s %8d calls  %10d credits\n", method, m.calls[method], m.spent[method])
+	}
+	ret += fmt.Sprintf("%-26s %8s        %10d credits", "Total", "", m.used)
+	if m.DailyBudget != 0 {
+		ret += fmt.Sprintf(" (%d of %d today)", m.used_today, m.DailyBudget)
+	}
+	if m.Budget != 0 {
+		ret += fmt.Sprintf(" (budget for this run: %d)", m.Budget)
+	}
+	return ret + "\n"
+}
+
+// A LogSource that charges each call to another one to a CreditMeter, before making it.  Calls that
+// would go over budget fail with ErrBudgetExhausted instead.
+type MeteredSource struct {
+	LogSource
+	Meter *CreditMeter
+}
+
+func (s MeteredSource) ChainID(ctx context.Context) (uint64, error) {
+	if err := s.Meter.charge("eth_chainId", 1); err != nil {
+		return 0, err
+	}
+	return s.LogSource.ChainID(ctx)
+}
+
+func (s MeteredSource) BlockNumber(ctx context.Context) (uint64, error) {
+	if err := s.Meter.charge("eth_blockNumber", 1); err != nil {
+		return 0, err
+	}
+	return s.LogSource.BlockNumber(ctx)
+}
+
+func (s MeteredSource) TaggedBlockNumber(ctx context.Context, tag string) (uint64, error) {
+	if err := s.Meter.charge("eth_getBlockByNumber", 1); err != nil {
+		return 0, err
+	}
+	return s.LogSource.TaggedBlockNumber(ctx, tag)
+}
+
+func (s MeteredSource) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	if err := s.Meter.charge("eth_getLogs", 1); err != nil {
+		return nil, err
+	}
+	return s.LogSource.FilterLogs(ctx, q)
+}
+
+func (s MeteredSource) TransactionsByHash(ctx context.Context, hashes []common.Hash) ([]Transaction, error) {
+	if err := s.Meter.charge("eth_getTransactionByHash", len(hashes)); err != nil {
+		return nil, err
+	}
+	return s.LogSource.TransactionsByHash(ctx, hashes)
+}
+
+func (s MeteredSource) BlockHeaders(ctx context.Context, block_nums []uint64) ([]BlockHeader, error) {
+	if err := s.Meter.charge("eth_getBlockByNumber", len(block_nums)); err != nil {
+		return nil, err
+	}
+	return s.LogSource.BlockHeaders(ctx, block_nums)
+}
+
+func (s MeteredSource) Blocks(ctx context.Context, block_nums []uint64) ([]Block, error) {
+	if err := s.Meter.charge("eth_getBlockByNumber", len(block_nums)); err != nil {
+		return nil, err
+	}
+	return s.LogSource.Blocks(ctx, block_nums)
+}
+
+func (s MeteredSource) BlockReceipts(ctx context.Context, block_nums []uint64) ([][]Receipt, error) {
+	if err := s.Meter.charge("eth_getBlockReceipts", len(block_nums)); err != nil {
+		return nil, err
+	}
+	return s.LogSource.BlockReceipts(ctx, block_nums)
+}
+
+func (s MeteredSource) TransactionReceipts(ctx context.Context, hashes []common.Hash) ([]Receipt, error) {
+	if err := s.Meter.charge("eth_getTransactionReceipt", len(hashes)); err != nil {
+		return nil, err
+	}
+	return s.LogSource.TransactionReceipts(ctx, hashes)
+}
+
+func (s MeteredSource) TraceCalls(
+	ctx context.Context, from_block uint64, to_block uint64, to_address common.Address,
+) ([]CallTrace, error) {
+	if err := s.Meter.charge("trace_filter", 1); err != nil {
+		return nil, err
+	}
+	return s.LogSource.TraceCalls(ctx, from_block, to_block, to_address)
+}
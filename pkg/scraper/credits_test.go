@@ -0,0 +1,58 @@
+package scraper_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "go-azimuth/pkg/db"
+	. "go-azimuth/pkg/scraper"
+)
+
+func TestCreditBudget(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	file_source, err := NewFileLogSource("testdata/logs.ndjson")
+	require.NoError(err)
+	db, err := DBCreate(":memory:")
+	require.NoError(err)
+	db.SetLatestContractBlockFetched(db.GetContractByName("Azimuth").ID, 13369000)
+
+	// Enough for the block number and one range of logs, but not the block timestamps after that
+	meter := &CreditMeter{Budget: 80 + 255}
+	meter.Load(db)
+	source := MeteredSource{LogSource: file_source, Meter: meter}
+	opts := Options{Meter: meter}
+
+	ctx := context.Background()
+	err = CatchUpAzimuthLogs(ctx, source, db, opts)
+	assert.ErrorIs(err, ErrBudgetExhausted)
+	assert.Equal(uint64(80+255), meter.Used())
+	assert.Equal(uint64(13369000), db.GetContractByName("Azimuth").LatestBlockNumFetched)
+
+	// What got spent is saved, even though the range wasn't
+	today := time.Now().UTC().Format(time.DateOnly)
+	assert.Equal(uint64(80+255), db.GetCreditsUsedOn(today))
+	assert.Contains(meter.Summary(), "eth_getLogs                       1 calls         255 credits")
+
+	// A new run with a bigger budget picks up where it left off
+	meter = &CreditMeter{DailyBudget: 10000}
+	meter.Load(db)
+	source.Meter = meter
+	opts.Meter = meter
+	require.NoError(CatchUpAzimuthLogs(ctx, source, db, opts))
+	assert.Equal(uint64(13369856), db.GetContractByName("Azimuth").LatestBlockNumFetched)
+	assert.Equal(uint64(80+255)+meter.Used(), db.GetCreditsUsedOn(today))
+
+	// The daily budget counts the earlier run too
+	meter = &CreditMeter{DailyBudget: db.GetCreditsUsedOn(today) + 79}
+	meter.Load(db)
+	source.Meter = meter
+	_, err = source.BlockNumber(ctx)
+	assert.ErrorIs(err, ErrBudgetExhausted)
+	assert.Equal(uint64(0), meter.Used())
+}
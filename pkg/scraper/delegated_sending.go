@@ -0,0 +1,25 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+
+	. "go-azimuth/pkg/db"
+)
+
+// Fetches all the DelegatedSending (planet invites) logs so far, in chunks.
+func CatchUpDelegatedSendingLogs(ctx context.Context, source LogSource, db DB, opts Options) error {
+	latest_block, err := source.BlockNumber(ctx)
+	if err != nil {
+		return fmt.Errorf("getting latest block number: %w", err)
+	}
+	return CatchUpDelegatedSendingLogsUntil(ctx, source, db, latest_block, opts)
+}
+
+// Fetches DelegatedSending logs from where the previous fetch left off, up to and including
+// `latest_block`.
+func CatchUpDelegatedSendingLogsUntil(
+	ctx context.Context, source LogSource, db DB, latest_block uint64, opts Options,
+) error {
+	return fetch_tracked_logs(ctx, source, db, "DelegatedSending", latest_block, opts)
+}
@@ -0,0 +1,37 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+
+	. "go-azimuth/pkg/db"
+)
+
+// Fetches all the logs from every Ecliptic so far, in chunks.  Azimuth logs have to be fetched
+// first, since that's how the Ecliptics are found (see `RegisterEclipticContracts`).
+func CatchUpEclipticLogs(ctx context.Context, source LogSource, db DB, opts Options) error {
+	latest_block, err := source.BlockNumber(ctx)
+	if err != nil {
+		return fmt.Errorf("getting latest block number: %w", err)
+	}
+	return CatchUpEclipticLogsUntil(ctx, source, db, latest_block, opts)
+}
+
+// Fetches Ecliptic logs from where the previous fetch left off, up to and including `latest_block`.
+//
+// Each Ecliptic is only fetched up to the block where the next one replaced it.
+func CatchUpEclipticLogsUntil(ctx context.Context, source LogSource, db DB, latest_block uint64, opts Options) error {
+	db.RegisterEclipticContracts()
+	ecliptics := db.GetContractsByName("Ecliptic")
+	for i, contract := range ecliptics {
+		until_block := latest_block
+		if i+1 < len(ecliptics) {
+			until_block = min(latest_block, ecliptics[i+1].StartBlockNum)
+		}
+		err := fetch_logs_in_ranges(ctx, source, db, contract, until_block, opts, parse_tracked_logs)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
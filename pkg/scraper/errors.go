@@ -0,0 +1,115 @@
+package scraper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Errors that are worth retrying; the same call will probably work if you wait a bit
+var (
+	ErrTemporarilyUnavailable = errors.New("service temporarily unavailable")
+	ErrRateLimited            = errors.New("rate limited")
+)
+
+// The source can't do that, e.g., the node doesn't have the API for it
+var ErrNotSupported = errors.New("not supported")
+
+// Something that isn't in the cache was needed, and there's no node to get it from (see
+// CachingSource)
+var ErrNotCached = errors.New("not in the cache")
+
+// Gave up on a call after retrying it as many times as the RetryPolicy allows
+var ErrRetriesExhausted = errors.New("too many retries")
+
+// Didn't make a call because it would have used more RPC credits than the budget allows (see
+// CreditMeter).  Not transient.
+var ErrBudgetExhausted = errors.New("credit budget exhausted")
+
+// The node refused a log query because it would return too many results.  Not transient; the
+// query has to be split up.  Some nodes suggest a smaller range that would work.
+type TooManyResultsError struct {
+	Err                error
+	RecommendedToBlock uint64
+	HasRecommendation  bool
+}
+
+func (e *TooManyResultsError) Error() string {
+	if e.HasRecommendation {
+		return fmt.Sprintf("too many results (try up to block %d): %s", e.RecommendedToBlock, e.Err)
+	}
+	return fmt.Sprintf("too many results: %s", e.Err)
+}
+
+func (e *TooManyResultsError) Unwrap() error {
+	return e.Err
+}
+
+// Whether an error is worth retrying
+func IsTransient(err error) bool {
+	return errors.Is(err, ErrTemporarilyUnavailable) || errors.Is(err, ErrRateLimited)
+}
+
+// Convert errors from an Ethereum node into the errors above, where applicable.  Anything it
+// doesn't recognize is returned as-is.
+//
+// Checking error codes is insane, but it's the recommended way to do it.
+// https://github.com/ethereum/go-ethereum/issues/19766#issuecomment-963442824
+func classify_error(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var rpc_err rpc.Error
+	if errors.As(err, &rpc_err) {
+		switch rpc_err.ErrorCode() {
+		case -32005:
+			// Infura uses this code both for "too many results" (with a recommended range in the error
+			// data) and for "rate limit exceeded" (without)
+			var data_err rpc.DataError
+			if errors.As(err, &data_err) {
+				if data, is_ok := data_err.ErrorData().(map[string]interface{}); is_ok {
+					if to_block, is_ok := data["to"].(string); is_ok {
+						to_block_num, parse_err := hexutil.DecodeUint64(to_block)
+						return &TooManyResultsError{Err: err, RecommendedToBlock: to_block_num, HasRecommendation: parse_err == nil}
+					}
+				}
+			}
+			return fmt.Errorf("%w: %w", ErrRateLimited, err)
+		case -32601:
+			// Method not found
+			return fmt.Errorf("%w: %w", ErrNotSupported, err)
+		case -32603:
+			// rpc.BatchElem{
+			// 	Method:"eth_getTransactionByHash",
+			// 	Args:[]interface {}{0xad2f676e4c35c7271123e77bd5616d4e89ae0e93cd0f5a9e4fb93a735ded42be},
+			// 	Result:(*types.Transaction)(0xc000332180),
+			// 	Error: &rpc.jsonError{Code:-32603, Message:"service temporarily unavailable", Data:interface {}(nil)},
+			// }
+			return fmt.Errorf("%w: %w", ErrTemporarilyUnavailable, err)
+		}
+	}
+
+	var http_err rpc.HTTPError
+	if errors.As(err, &http_err) {
+		if http_err.StatusCode == 429 {
+			return fmt.Errorf("%w: %w", ErrRateLimited, err)
+		} else if http_err.StatusCode >= 500 {
+			return fmt.Errorf("%w: %w", ErrTemporarilyUnavailable, err)
+		}
+		return err
+	}
+
+	// Connection problems (dropped connections, timeouts, DNS hiccups, etc)
+	var net_err net.Error
+	if errors.As(err, &net_err) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("%w: %w", ErrTemporarilyUnavailable, err)
+	}
+	return err
+}
@@ -0,0 +1,211 @@
+package scraper
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// A LogSource that reads from an export file instead of a node, e.g., to build a DB on a machine
+// with no network access.
+//
+// The file is newline-delimited JSON.  Each line is one log, in the same format `eth_getLogs`
+// returns, plus "input" and "to" fields with the call data and recipient of the transaction that
+// emitted it.  They're only needed for Naive logs, since that's where the L2 transactions are.  If
+// "to" is missing (or isn't the Naive contract), the batch is taken to have been sent through
+// another contract, and there's no call traces in the file to get its call data from, so it can't
+// be played until it's repaired.  There can also be "from" and "gasUsed" fields, from the
+// transaction's receipt, which are needed for `--fetch-senders`.
+type FileLogSource struct {
+	Logs         []types.Log // In block order
+	Transactions map[common.Hash]Transaction
+	Receipts     map[common.Hash]Receipt // Only for transactions whose sender is in the file
+	BlockHashes  map[uint64]common.Hash
+	BlockTxs     map[uint64][]common.Hash // Each block's transactions that have logs in the file, in order
+}
+
+func NewFileLogSource(path string) (FileLogSource, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return FileLogSource{}, fmt.Errorf("opening log file: %w", err)
+	}
+	defer file.Close()
+
+	ret := FileLogSource{
+		Transactions: make(map[common.Hash]Transaction),
+		Receipts:     make(map[common.Hash]Receipt),
+		BlockHashes:  make(map[uint64]common.Hash),
+		BlockTxs:     make(map[uint64][]common.Hash),
+	}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16*1024*1024) // Naive batches can be long
+	for line_num := 1; scanner.Scan(); line_num++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var l types.Log
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			return FileLogSource{}, fmt.Errorf("line %d of %s: %w", line_num, path, err)
+		}
+		var extra struct {
+			Input   hexutil.Bytes   `json:"input"`
+			To      *common.Address `json:"to"` // Nil (unknown) if it's not there
+			From    *common.Address `json:"from"`
+			GasUsed hexutil.Uint64  `json:"gasUsed"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &extra); err != nil {
+			return FileLogSource{}, fmt.Errorf("line %d of %s: %w", line_num, path, err)
+		}
+
+		ret.Logs = append(ret.Logs, l)
+		ret.BlockHashes[l.BlockNumber] = l.BlockHash
+		ret.Transactions[l.TxHash] = Transaction{Hash: l.TxHash, To: extra.To, Input: extra.Input}
+		if extra.From != nil {
+			ret.Receipts[l.TxHash] = Receipt{
+				TxHash:           l.TxHash,
+				TransactionIndex: hexutil.Uint64(l.TxIndex),
+				BlockNumber:      hexutil.Uint64(l.BlockNumber),
+				From:             *extra.From,
+				GasUsed:          extra.GasUsed,
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return FileLogSource{}, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	slices.SortFunc(ret.Logs, func(a, b types.Log) int {
+		return cmp.Or(cmp.Compare(a.BlockNumber, b.BlockNumber), cmp.Compare(a.Index, b.Index))
+	})
+	for _, l := range ret.Logs {
+		if txs := ret.BlockTxs[l.BlockNumber]; !slices.Contains(txs, l.TxHash) {
+			ret.BlockTxs[l.BlockNumber] = append(txs, l.TxHash)
+		}
+	}
+	return ret, nil
+}
+
+// The latest block is the newest one in the file
+func (s FileLogSource) BlockNumber(ctx context.Context) (uint64, error) {
+	if len(s.Logs) == 0 {
+		return 0, nil
+	}
+	return s.Logs[len(s.Logs)-1].BlockNumber, nil
+}
+
+// The file doesn't say what chain it's from
+func (s FileLogSource) ChainID(ctx context.Context) (uint64, error) {
+	return 0, ErrNotSupported
+}
+
+// Everything in the file is considered final
+func (s FileLogSource) TaggedBlockNumber(ctx context.Context, tag string) (uint64, error) {
+	return s.BlockNumber(ctx)
+}
+
+// Logs are in block order, so only the ones in the range get looked at
+func (s FileLogSource) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	ret := []types.Log{}
+	start := 0
+	if q.FromBlock != nil {
+		start, _ = slices.BinarySearchFunc(s.Logs, q.FromBlock.Uint64(), func(l types.Log, n uint64) int {
+			return cmp.Compare(l.BlockNumber, n)
+		})
+	}
+	for _, l := range s.Logs[start:] {
+		if q.ToBlock != nil && l.BlockNumber > q.ToBlock.Uint64() {
+			break
+		}
+		if len(q.Addresses) != 0 && !slices.Contains(q.Addresses, l.Address) {
+			continue
+		}
+		is_match := true
+		for i, topics := range q.Topics {
+			if len(topics) != 0 && (i >= len(l.Topics) || !slices.Contains(topics, l.Topics[i])) {
+				is_match = false
+			}
+		}
+		if is_match {
+			ret = append(ret, l)
+		}
+	}
+	return ret, nil
+}
+
+func (s FileLogSource) TransactionsByHash(ctx context.Context, hashes []common.Hash) ([]Transaction, error) {
+	ret := []Transaction{}
+	for _, h := range hashes {
+		tx, is_ok := s.Transactions[h]
+		if !is_ok {
+			return nil, fmt.Errorf("transaction not found: %s", h)
+		}
+		ret = append(ret, tx)
+	}
+	return ret, nil
+}
+
+// Only blocks that have logs in the file are known
+func (s FileLogSource) BlockHeaders(ctx context.Context, block_nums []uint64) ([]BlockHeader, error) {
+	ret := []BlockHeader{}
+	for _, n := range block_nums {
+		hash, is_ok := s.BlockHashes[n]
+		if !is_ok {
+			ret = append(ret, BlockHeader{})
+			continue
+		}
+		ret = append(ret, BlockHeader{Number: hexutil.Uint64(n), Hash: hash})
+	}
+	return ret, nil
+}
+
+// Only transactions that have logs in the file are known, so blocks only have those
+func (s FileLogSource) Blocks(ctx context.Context, block_nums []uint64) ([]Block, error) {
+	ret := []Block{}
+	for _, n := range block_nums {
+		hash, is_ok := s.BlockHashes[n]
+		if !is_ok {
+			return nil, fmt.Errorf("block not found: %d", n)
+		}
+		block := Block{BlockHeader: BlockHeader{Number: hexutil.Uint64(n), Hash: hash}}
+		for _, h := range s.BlockTxs[n] {
+			block.Transactions = append(block.Transactions, s.Transactions[h])
+		}
+		ret = append(ret, block)
+	}
+	return ret, nil
+}
+
+// There's no receipts in the file (it only has the logs we track, which isn't enough to rebuild a
+// receipts trie)
+func (s FileLogSource) BlockReceipts(ctx context.Context, block_nums []uint64) ([][]Receipt, error) {
+	return nil, ErrNotSupported
+}
+
+// Receipts only have what's in the file: who sent it, and the gas used
+func (s FileLogSource) TransactionReceipts(ctx context.Context, hashes []common.Hash) ([]Receipt, error) {
+	ret := []Receipt{}
+	for _, h := range hashes {
+		receipt, is_ok := s.Receipts[h]
+		if !is_ok {
+			return nil, fmt.Errorf("no sender for transaction %s in the file: %w", h, ErrNotSupported)
+		}
+		ret = append(ret, receipt)
+	}
+	return ret, nil
+}
+
+// There's no call traces in the file
+func (s FileLogSource) TraceCalls(
+	ctx context.Context, from_block uint64, to_block uint64, to_address common.Address,
+) ([]CallTrace, error) {
+	return nil, ErrNotSupported
+}
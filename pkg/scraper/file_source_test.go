@@ -0,0 +1,168 @@
+package scraper_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "go-azimuth/pkg/db"
+	. "go-azimuth/pkg/scraper"
+)
+
+func TestCatchUpFromFile(t *testing.T) {
+	for _, strategy := range []string{"txs", "blocks"} {
+		t.Run(strategy, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			source, err := NewFileLogSource("testdata/logs.ndjson")
+			require.NoError(err)
+			db, err := DBCreate(":memory:")
+			require.NoError(err)
+			var opts Options
+			opts.CallDataStrategy, err = ParseCallDataStrategy(strategy)
+			require.NoError(err)
+
+			// Skip the empty blocks between the Azimuth deploy and the first log in the file
+			db.SetLatestContractBlockFetched(db.GetContractByName("Azimuth").ID, 13369000)
+			db.SetLatestContractBlockFetched(db.GetContractByName("Polls").ID, 13369000)
+			db.SetLatestContractBlockFetched(db.GetContractByName("Claims").ID, 13369000)
+			db.SetLatestContractBlockFetched(db.GetContractByName("DelegatedSending").ID, 13369000)
+
+			ctx := context.Background()
+			require.NoError(CatchUpAzimuthLogs(ctx, source, db, opts))
+			require.NoError(CatchUpNaiveLogs(ctx, source, db, opts))
+			require.NoError(CatchUpPollsLogs(ctx, source, db, opts))
+			require.NoError(CatchUpClaimsLogs(ctx, source, db, opts))
+			require.NoError(CatchUpDelegatedSendingLogs(ctx, source, db, opts))
+			assert.Equal(uint64(13369856), db.GetContractByName("Azimuth").LatestBlockNumFetched)
+			assert.Equal(uint64(13369856), db.GetContractByName("Naive").LatestBlockNumFetched)
+
+			// The Batch log should have the call data of its transaction
+			var batch EthereumEventLog
+			require.NoError(db.DB.Get(&batch, `select * from ethereum_events where topic0 = ?`, BATCH))
+			assert.Equal([]byte(source.Transactions[batch.TxHash].Input), batch.Data)
+			assert.Len(ParseNaiveBatch(batch.Data, batch.ID), 2)
+
+			require.NoError(UpdateFinalizedBlock(ctx, source, db, opts))
+			require.NoError(db.PlayAzimuthLogs(ctx, nil))
+			p, is_ok := db.GetPoint(AzimuthNumber(5))
+			require.True(is_ok)
+			assert.True(p.IsActive)
+			assert.Equal(common.HexToAddress("671738dada5c209c12b6501e80c62e091c27b14a"), p.OwnerAddress)
+		})
+	}
+}
+
+func TestCancelledCatchUpIsResumable(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	source, err := NewFileLogSource("testdata/logs.ndjson")
+	require.NoError(err)
+	db, err := DBCreate(":memory:")
+	require.NoError(err)
+
+	// Cancel it as soon as the first range is done
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reports := []Progress{}
+	opts := Options{Progress: ProgressFunc(func(p Progress) {
+		reports = append(reports, p)
+		cancel()
+	})}
+	err = CatchUpAzimuthLogs(ctx, source, db, opts)
+	assert.ErrorIs(err, context.Canceled)
+
+	// The first range is saved, and nothing after it
+	require.Len(reports, 1)
+	assert.Equal("Azimuth contract: fetching logs", reports[0].Task)
+	assert.Equal(reports[0].CurrentBlock, db.GetContractByName("Azimuth").LatestBlockNumFetched)
+	assert.Less(reports[0].Done, reports[0].Total)
+}
+
+func TestFetchSendersFromFile(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	source, err := NewFileLogSource("testdata/logs.ndjson")
+	require.NoError(err)
+	db, err := DBCreate(":memory:")
+	require.NoError(err)
+	db.SetLatestContractBlockFetched(db.GetContractByName("Azimuth").ID, 13369000)
+	azimuth_tx := common.HexToHash("1111111111111111111111111111111111111111111111111111111111111111")
+
+	// Fetched along with the logs
+	ctx := context.Background()
+	require.NoError(CatchUpAzimuthLogs(ctx, source, db, Options{FetchSenders: true}))
+	tx, is_found := db.GetTransaction(azimuth_tx)
+	require.True(is_found)
+	assert.Equal(common.HexToAddress("671738dada5c209c12b6501e80c62e091c27b14a"), tx.FromAddress)
+	assert.Equal(uint64(120000), tx.GasUsed)
+	assert.Equal(uint64(13369128), tx.BlockNumber)
+
+	// Only Azimuth's.  (The file doesn't have the Batch transaction's sender anyway.)
+	require.NoError(CatchUpNaiveLogs(ctx, source, db, Options{FetchSenders: true}))
+	assert.Len(db.GetEventTxsWithoutSenders(10), 0)
+
+	// Fetched afterward
+	db.DB.MustExec(`delete from transactions`)
+	assert.Len(db.GetEventTxsWithoutSenders(10), 1)
+	require.NoError(BackfillTransactionSenders(ctx, source, db, Options{}))
+	_, is_found = db.GetTransaction(azimuth_tx)
+	assert.True(is_found)
+}
+
+func TestFileWithoutTo(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	source, err := NewFileLogSource("testdata/logs.ndjson")
+	require.NoError(err)
+	batch_tx := common.HexToHash("2222222222222222222222222222222222222222222222222222222222222222")
+	require.NotNil(source.Transactions[batch_tx].To)
+	assert.Equal(common.HexToAddress("eb70029cfb3c53c778eaf68cd28de725390a1fe9"), *source.Transactions[batch_tx].To)
+
+	// Without "to", it's not known where the transaction went, so it can't be assumed it was straight
+	// to the Naive contract
+	data, err := os.ReadFile("testdata/logs.ndjson")
+	require.NoError(err)
+	path := filepath.Join(t.TempDir(), "logs.ndjson")
+	require.NoError(os.WriteFile(path,
+		[]byte(strings.ReplaceAll(string(data), `"to":"0xeb70029cfb3c53c778eaf68cd28de725390a1fe9",`, "")), 0o644))
+	source, err = NewFileLogSource(path)
+	require.NoError(err)
+	assert.Nil(source.Transactions[batch_tx].To)
+
+	db, err := DBCreate(":memory:")
+	require.NoError(err)
+	require.NoError(CatchUpNaiveLogs(context.Background(), source, db, Options{}))
+	assert.Len(db.GetBatchesMissingCallData(0, 10), 1)
+}
+
+func TestFileBlocks(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	source, err := NewFileLogSource("testdata/logs.ndjson")
+	require.NoError(err)
+	blocks, err := source.Blocks(context.Background(), []uint64{0xcc0200, 0xcbff28})
+	require.NoError(err)
+	require.Len(blocks, 2)
+	require.Len(blocks[0].Transactions, 1)
+	assert.Equal(common.HexToHash("2222222222222222222222222222222222222222222222222222222222222222"),
+		blocks[0].Transactions[0].Hash)
+	// Both of the block's logs are from the same transaction
+	require.Len(blocks[1].Transactions, 1)
+	assert.Equal(common.HexToHash("1111111111111111111111111111111111111111111111111111111111111111"),
+		blocks[1].Transactions[0].Hash)
+
+	_, err = source.Blocks(context.Background(), []uint64{1})
+	assert.Error(err)
+}
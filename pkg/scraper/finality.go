@@ -0,0 +1,57 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+
+	. "go-azimuth/pkg/db"
+)
+
+// Get the newest block that counts as final, according to `finality`
+func GetFinalizedBlock(ctx context.Context, source LogSource, finality Finality) (uint64, error) {
+	if finality.BlockTag == "" && finality.Confirmations == 0 {
+		finality.BlockTag = "finalized"
+	}
+	if finality.BlockTag != "" {
+		ret, err := source.TaggedBlockNumber(ctx, finality.BlockTag)
+		if err != nil {
+			return 0, fmt.Errorf("getting %q block: %w", finality.BlockTag, err)
+		}
+		return ret, nil
+	}
+
+	latest_block, err := source.BlockNumber(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("getting latest block number: %w", err)
+	}
+	if latest_block < finality.Confirmations {
+		return 0, nil
+	}
+	return latest_block - finality.Confirmations, nil
+}
+
+// Update the DB's finalized block, so that events up to it can be played.  It never goes past what
+// has been fetched from every contract, since events that haven't been fetched yet might come
+// before ones that have (see WTF(naive-azimuth-interlacing)).
+func UpdateFinalizedBlock(ctx context.Context, source LogSource, db DB, opts Options) error {
+	finalized_block, err := GetFinalizedBlock(ctx, source, opts.Finality)
+	if err != nil {
+		return err
+	}
+	fetched_block := min(
+		db.GetContractByName("Azimuth").LatestBlockNumFetched,
+		db.GetContractByName("Naive").LatestBlockNumFetched,
+	)
+	for _, name := range []string{"Polls", "Claims", "DelegatedSending"} {
+		// Not every network has these
+		if contract, is_ok := db.FindContractByName(name); is_ok {
+			fetched_block = min(fetched_block, contract.LatestBlockNumFetched)
+		}
+	}
+	if ecliptics := db.GetContractsByName("Ecliptic"); len(ecliptics) != 0 {
+		// Only the current one; the older ones stop where they got replaced
+		fetched_block = min(fetched_block, ecliptics[len(ecliptics)-1].LatestBlockNumFetched)
+	}
+	db.SetFinalizedBlock(min(finalized_block, fetched_block))
+	return nil
+}
@@ -0,0 +1,44 @@
+package scraper_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "go-azimuth/pkg/scraper"
+)
+
+// A LogSource whose chain head is at block 1000, with the usual tags behind it
+type tagged_source struct {
+	FileLogSource
+}
+
+func (s tagged_source) BlockNumber(ctx context.Context) (uint64, error) {
+	return 1000, nil
+}
+
+func (s tagged_source) TaggedBlockNumber(ctx context.Context, tag string) (uint64, error) {
+	return map[string]uint64{"latest": 1000, "safe": 968, "finalized": 936}[tag], nil
+}
+
+func TestGetFinalizedBlock(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	ctx := context.Background()
+	for s, expected := range map[string]uint64{"finalized": 936, "safe": 968, "latest": 1000, "12": 988, "0": 1000} {
+		finality, err := ParseFinality(s)
+		require.NoError(err)
+		block_num, err := GetFinalizedBlock(ctx, tagged_source{}, finality)
+		require.NoError(err)
+		assert.Equal(expected, block_num, s)
+	}
+	block_num, err := GetFinalizedBlock(ctx, tagged_source{}, Finality{})
+	require.NoError(err)
+	assert.Equal(uint64(936), block_num)
+
+	_, err = ParseFinality("soon")
+	assert.Error(err)
+}
@@ -0,0 +1,29 @@
+package scraper
+
+import (
+	"context"
+
+	. "go-azimuth/pkg/db"
+)
+
+// Fetch the logs from every gap in the blocks fetched so far (see `GetGaps`), for every contract.
+// Events that turn up in them get saved like any others, but not played.
+//
+// Ecliptics are found from Azimuth logs, so if a gap in Azimuth hid one, this only finds it; run
+// `CatchUpEclipticLogs` afterward to fetch its logs.
+func FillGaps(ctx context.Context, source LogSource, db DB, opts Options) error {
+	for _, contract := range db.GetLogContracts() {
+		handle_logs := parse_tracked_logs
+		if contract.Name == "Naive" {
+			handle_logs = naive_logs_handler(ctx, source, opts)
+		}
+		for _, gap := range db.GetGaps(contract) {
+			err := fetch_logs_between(ctx, source, db, contract, gap.FromBlock, gap.ToBlock, opts, handle_logs)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	db.RegisterEclipticContracts()
+	return nil
+}
@@ -0,0 +1,57 @@
+package scraper_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "go-azimuth/pkg/db"
+	. "go-azimuth/pkg/scraper"
+)
+
+func TestFillGaps(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	source, err := NewFileLogSource("testdata/logs.ndjson")
+	require.NoError(err)
+	db, err := DBCreate(":memory:")
+	require.NoError(err)
+	azimuth := db.GetContractByName("Azimuth")
+	naive := db.GetContractByName("Naive")
+	db.SetLatestContractBlockFetched(azimuth.ID, 13369000)
+
+	ctx := context.Background()
+	require.NoError(CatchUpAzimuthLogs(ctx, source, db, Options{}))
+	require.NoError(CatchUpNaiveLogs(ctx, source, db, Options{}))
+	for _, c := range db.GetLogContracts() {
+		assert.Equal([]BlockRange{}, db.GetGaps(c), c.Name)
+	}
+
+	// Lose the ranges with the events in them, like a fetch that skipped them
+	db.DB.MustExec(`delete from ethereum_events`)
+	db.DB.MustExec(`delete from fetched_ranges`)
+	db.DB.MustExec(`insert into fetched_ranges (contract_id, from_block, to_block) values (?, ?, ?), (?, ?, ?)`,
+		azimuth.ID, azimuth.StartBlockNum, 13369100, naive.ID, naive.StartBlockNum, 13369830)
+	assert.Equal([]BlockRange{{FromBlock: 13369101, ToBlock: 13369856}}, db.GetGaps(db.GetContractByName("Azimuth")))
+	assert.Equal([]BlockRange{{FromBlock: 13369831, ToBlock: 13369856}}, db.GetGaps(db.GetContractByName("Naive")))
+
+	require.NoError(FillGaps(ctx, source, db, Options{}))
+	for _, c := range db.GetLogContracts() {
+		assert.Equal([]BlockRange{}, db.GetGaps(c), c.Name)
+	}
+	var num_events int
+	require.NoError(db.DB.Get(&num_events, `select count(*) from ethereum_events`))
+	assert.Equal(3, num_events)
+	assert.Len(db.GetBatchesMissingCallData(0, 10), 0)
+
+	// Filling them again doesn't change anything
+	db.DB.MustExec(`delete from fetched_ranges`)
+	db.DB.MustExec(`insert into fetched_ranges (contract_id, from_block, to_block) values (?, ?, ?)`,
+		azimuth.ID, azimuth.StartBlockNum, 13369000)
+	require.NoError(FillGaps(ctx, source, db, Options{}))
+	require.NoError(db.DB.Get(&num_events, `select count(*) from ethereum_events`))
+	assert.Equal(3, num_events)
+}
@@ -0,0 +1,361 @@
+package scraper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	. "go-azimuth/pkg/db"
+)
+
+// A LogSource that uses the first of several sources that works.  When one fails, it switches to
+// the next one and sticks with that (so a rate-limited endpoint gets a break).  Safe to use from
+// several goroutines at once.
+type FailoverSource struct {
+	Sources  []LogSource
+	Progress ProgressReporter // Gets told when it switches endpoints.  Can be nil.
+	current  int
+	mutex    sync.Mutex
+}
+
+// Try `f` on each source, starting with the current one, until one succeeds.  If they all fail,
+// returns the last error.
+func (s *FailoverSource) try_each(f func(LogSource) error) error {
+	s.mutex.Lock()
+	current := s.current
+	s.mutex.Unlock()
+
+	var err error
+	for range s.Sources {
+		err = f(s.Sources[current])
+		var too_many_results_err *TooManyResultsError
+		if err == nil || errors.As(err, &too_many_results_err) || errors.Is(err, ErrBudgetExhausted) {
+			// "Too many results" is a problem with the query, not the endpoint; and the budget is the
+			// same for every endpoint
+			return err
+		}
+		next := (current + 1) % len(s.Sources)
+		s.mutex.Lock()
+		if s.current == current {
+			// (Unless another call already switched away from it)
+			if next != current {
+				ReportProgress(s.Progress, Progress{
+					Task: fmt.Sprintf("Ethereum endpoint #%d", current+1),
+					Note: fmt.Sprintf("failed: %v.  Switching to endpoint #%d", err, next+1),
+				})
+			}
+			s.current = next
+		}
+		s.mutex.Unlock()
+		current = next
+	}
+	return fmt.Errorf("all endpoints failed: %w", err)
+}
+
+func (s *FailoverSource) ChainID(ctx context.Context) (ret uint64, err error) {
+	err = s.try_each(func(source LogSource) (err error) {
+		ret, err = source.ChainID(ctx)
+		return
+	})
+	return
+}
+
+func (s *FailoverSource) BlockNumber(ctx context.Context) (ret uint64, err error) {
+	err = s.try_each(func(source LogSource) (err error) {
+		ret, err = source.BlockNumber(ctx)
+		return
+	})
+	return
+}
+
+func (s *FailoverSource) TaggedBlockNumber(ctx context.Context, tag string) (ret uint64, err error) {
+	err = s.try_each(func(source LogSource) (err error) {
+		ret, err = source.TaggedBlockNumber(ctx, tag)
+		return
+	})
+	return
+}
+
+func (s *FailoverSource) FilterLogs(ctx context.Context, q ethereum.FilterQuery) (ret []types.Log, err error) {
+	err = s.try_each(func(source LogSource) (err error) {
+		ret, err = source.FilterLogs(ctx, q)
+		return
+	})
+	return
+}
+
+func (s *FailoverSource) TransactionsByHash(ctx context.Context, hashes []common.Hash) (ret []Transaction, err error) {
+	err = s.try_each(func(source LogSource) (err error) {
+		ret, err = source.TransactionsByHash(ctx, hashes)
+		return
+	})
+	return
+}
+
+func (s *FailoverSource) BlockHeaders(ctx context.Context, block_nums []uint64) (ret []BlockHeader, err error) {
+	err = s.try_each(func(source LogSource) (err error) {
+		ret, err = source.BlockHeaders(ctx, block_nums)
+		return
+	})
+	return
+}
+
+func (s *FailoverSource) Blocks(ctx context.Context, block_nums []uint64) (ret []Block, err error) {
+	err = s.try_each(func(source LogSource) (err error) {
+		ret, err = source.Blocks(ctx, block_nums)
+		return
+	})
+	return
+}
+
+func (s *FailoverSource) BlockReceipts(ctx context.Context, block_nums []uint64) (ret [][]Receipt, err error) {
+	err = s.try_each(func(source LogSource) (err error) {
+		ret, err = source.BlockReceipts(ctx, block_nums)
+		return
+	})
+	return
+}
+
+func (s *FailoverSource) TransactionReceipts(ctx context.Context, hashes []common.Hash) (ret []Receipt, err error) {
+	err = s.try_each(func(source LogSource) (err error) {
+		ret, err = source.TransactionReceipts(ctx, hashes)
+		return
+	})
+	return
+}
+
+func (s *FailoverSource) TraceCalls(
+	ctx context.Context, from_block uint64, to_block uint64, to_address common.Address,
+) (ret []CallTrace, err error) {
+	err = s.try_each(func(source LogSource) (err error) {
+		ret, err = source.TraceCalls(ctx, from_block, to_block, to_address)
+		return
+	})
+	return
+}
+
+// The sources in a QuorumSource gave different answers to the same question
+var ErrSourcesDisagree = errors.New("sources disagree")
+
+// A LogSource that asks `Quorum` different sources everything, and only returns an answer if they
+// all agree on it.  That way one bad (or lying) endpoint can't get wrong data into the DB.
+//
+// If a source fails, the next one is asked instead, as long as there are enough left to make a
+// quorum.
+type QuorumSource struct {
+	Sources  []LogSource
+	Quorum   int
+	Progress ProgressReporter // Gets told when a source fails.  Can be nil.
+}
+
+// Get answers from `Quorum` sources.  If not enough sources answer, returns the last error.
+func ask_quorum[T any](s QuorumSource, f func(LogSource) (T, error)) ([]T, error) {
+	ret := []T{}
+	var err error
+	for i, source := range s.Sources {
+		if len(ret) == s.Quorum {
+			break
+		}
+		var answer T
+		answer, err = f(source)
+		var too_many_results_err *TooManyResultsError
+		if errors.As(err, &too_many_results_err) {
+			return nil, err
+		} else if err != nil {
+			ReportProgress(s.Progress, Progress{
+				Task: fmt.Sprintf("Ethereum endpoint #%d", i+1),
+				Note: fmt.Sprintf("failed: %v", err),
+			})
+			continue
+		}
+		ret = append(ret, answer)
+	}
+	if len(ret) < s.Quorum {
+		return nil, fmt.Errorf("only %d of %d endpoints answered (need %d): %w", len(ret), len(s.Sources), s.Quorum, err)
+	}
+	return ret, nil
+}
+
+func (s QuorumSource) ChainID(ctx context.Context) (uint64, error) {
+	answers, err := ask_quorum(s, func(source LogSource) (uint64, error) {
+		return source.ChainID(ctx)
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, answer := range answers[1:] {
+		if answer != answers[0] {
+			return 0, fmt.Errorf("%w on the chain ID", ErrSourcesDisagree)
+		}
+	}
+	return answers[0], nil
+}
+
+// Sources can be at slightly different heights; use the lowest, so they all have every block up to
+// it.  (Same for tagged blocks.)
+func (s QuorumSource) BlockNumber(ctx context.Context) (uint64, error) {
+	answers, err := ask_quorum(s, func(source LogSource) (uint64, error) {
+		return source.BlockNumber(ctx)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return slices.Min(answers), nil
+}
+
+func (s QuorumSource) TaggedBlockNumber(ctx context.Context, tag string) (uint64, error) {
+	answers, err := ask_quorum(s, func(source LogSource) (uint64, error) {
+		return source.TaggedBlockNumber(ctx, tag)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return slices.Min(answers), nil
+}
+
+func (s QuorumSource) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	answers, err := ask_quorum(s, func(source LogSource) ([]types.Log, error) {
+		return source.FilterLogs(ctx, q)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, answer := range answers[1:] {
+		if !slices.EqualFunc(answers[0], answer, is_same_log) {
+			return nil, fmt.Errorf("%w on logs for blocks %s - %s", ErrSourcesDisagree, q.FromBlock, q.ToBlock)
+		}
+	}
+	return answers[0], nil
+}
+
+func is_same_tx(a, b Transaction) bool {
+	return a.Hash == b.Hash && (a.To == nil) == (b.To == nil) && (a.To == nil || *a.To == *b.To) &&
+		bytes.Equal(a.Input, b.Input)
+}
+
+func is_same_log(a, b types.Log) bool {
+	return a.Address == b.Address && slices.Equal(a.Topics, b.Topics) && bytes.Equal(a.Data, b.Data) &&
+		a.BlockNumber == b.BlockNumber && a.BlockHash == b.BlockHash && a.TxHash == b.TxHash && a.Index == b.Index
+}
+
+func (s QuorumSource) TransactionsByHash(ctx context.Context, hashes []common.Hash) ([]Transaction, error) {
+	answers, err := ask_quorum(s, func(source LogSource) ([]Transaction, error) {
+		return source.TransactionsByHash(ctx, hashes)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, answer := range answers[1:] {
+		if !slices.EqualFunc(answers[0], answer, is_same_tx) {
+			return nil, fmt.Errorf("%w on transactions %v", ErrSourcesDisagree, hashes)
+		}
+	}
+	return answers[0], nil
+}
+
+// Every field goes into the hash, so comparing that compares them all
+func is_same_header(a, b BlockHeader) bool {
+	return a.Hash == b.Hash && a.ComputeHash() == b.ComputeHash()
+}
+
+func (s QuorumSource) BlockHeaders(ctx context.Context, block_nums []uint64) ([]BlockHeader, error) {
+	answers, err := ask_quorum(s, func(source LogSource) ([]BlockHeader, error) {
+		return source.BlockHeaders(ctx, block_nums)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, answer := range answers[1:] {
+		if !slices.EqualFunc(answers[0], answer, is_same_header) {
+			return nil, fmt.Errorf("%w on block headers %v", ErrSourcesDisagree, block_nums)
+		}
+	}
+	return answers[0], nil
+}
+
+func (s QuorumSource) Blocks(ctx context.Context, block_nums []uint64) ([]Block, error) {
+	answers, err := ask_quorum(s, func(source LogSource) ([]Block, error) {
+		return source.Blocks(ctx, block_nums)
+	})
+	if err != nil {
+		return nil, err
+	}
+	is_same_block := func(a, b Block) bool {
+		return is_same_header(a.BlockHeader, b.BlockHeader) && slices.EqualFunc(a.Transactions, b.Transactions, is_same_tx)
+	}
+	for _, answer := range answers[1:] {
+		if !slices.EqualFunc(answers[0], answer, is_same_block) {
+			return nil, fmt.Errorf("%w on blocks %v", ErrSourcesDisagree, block_nums)
+		}
+	}
+	return answers[0], nil
+}
+
+func is_same_receipt(a, b Receipt) bool {
+	return a.Type == b.Type && bytes.Equal(a.Root, b.Root) && (a.Status == nil) == (b.Status == nil) &&
+		(a.Status == nil || *a.Status == *b.Status) && a.CumulativeGasUsed == b.CumulativeGasUsed &&
+		a.LogsBloom == b.LogsBloom && slices.EqualFunc(a.Logs, b.Logs, is_same_log) && a.TxHash == b.TxHash &&
+		a.TransactionIndex == b.TransactionIndex && a.BlockNumber == b.BlockNumber && a.From == b.From &&
+		a.GasUsed == b.GasUsed
+}
+
+func (s QuorumSource) BlockReceipts(ctx context.Context, block_nums []uint64) ([][]Receipt, error) {
+	answers, err := ask_quorum(s, func(source LogSource) ([][]Receipt, error) {
+		return source.BlockReceipts(ctx, block_nums)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, answer := range answers[1:] {
+		if !slices.EqualFunc(answers[0], answer, func(a, b []Receipt) bool {
+			return slices.EqualFunc(a, b, is_same_receipt)
+		}) {
+			return nil, fmt.Errorf("%w on block receipts %v", ErrSourcesDisagree, block_nums)
+		}
+	}
+	return answers[0], nil
+}
+
+func (s QuorumSource) TransactionReceipts(ctx context.Context, hashes []common.Hash) ([]Receipt, error) {
+	answers, err := ask_quorum(s, func(source LogSource) ([]Receipt, error) {
+		return source.TransactionReceipts(ctx, hashes)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, answer := range answers[1:] {
+		if !slices.EqualFunc(answers[0], answer, is_same_receipt) {
+			return nil, fmt.Errorf("%w on transaction receipts %v", ErrSourcesDisagree, hashes)
+		}
+	}
+	return answers[0], nil
+}
+
+func (s QuorumSource) TraceCalls(
+	ctx context.Context, from_block uint64, to_block uint64, to_address common.Address,
+) ([]CallTrace, error) {
+	answers, err := ask_quorum(s, func(source LogSource) ([]CallTrace, error) {
+		return source.TraceCalls(ctx, from_block, to_block, to_address)
+	})
+	if err != nil {
+		return nil, err
+	}
+	is_same_trace := func(a, b CallTrace) bool {
+		return a.Action.From == b.Action.From && a.Action.To == b.Action.To && bytes.Equal(a.Action.Input, b.Action.Input) &&
+			a.BlockNumber == b.BlockNumber && a.BlockHash == b.BlockHash && a.TxHash == b.TxHash &&
+			slices.Equal(a.TraceAddress, b.TraceAddress) && a.Error == b.Error
+	}
+	for _, answer := range answers[1:] {
+		if !slices.EqualFunc(answers[0], answer, is_same_trace) {
+			return nil, fmt.Errorf("%w on call traces for blocks %d - %d", ErrSourcesDisagree, from_block, to_block)
+		}
+	}
+	return answers[0], nil
+}
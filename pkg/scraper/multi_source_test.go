@@ -0,0 +1,91 @@
+package scraper_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "go-azimuth/pkg/scraper"
+)
+
+func TestFailover(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	file_source, err := NewFileLogSource("testdata/logs.ndjson")
+	require.NoError(err)
+	num_failures := 1000
+	source := &FailoverSource{Sources: []LogSource{flaky_source{file_source, &num_failures, 0}, file_source}}
+
+	logs, err := source.FilterLogs(context.Background(), ethereum.FilterQuery{})
+	require.NoError(err)
+	assert.Len(logs, 3)
+
+	// Sticks with the one that works
+	logs, err = source.FilterLogs(context.Background(), ethereum.FilterQuery{})
+	require.NoError(err)
+	assert.Len(logs, 3)
+	assert.Equal(999, num_failures)
+}
+
+func TestQuorum(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	honest, err := NewFileLogSource("testdata/logs.ndjson")
+	require.NoError(err)
+	lying, err := NewFileLogSource("testdata/logs.ndjson")
+	require.NoError(err)
+	lying.Logs[1].Topics[2] = common.HexToHash("0xbad")
+	num_failures := 1000
+	broken := flaky_source{honest, &num_failures, 0}
+
+	// All the sources that answer agree
+	source := QuorumSource{Sources: []LogSource{honest, broken, honest}, Quorum: 2}
+	logs, err := source.FilterLogs(context.Background(), ethereum.FilterQuery{})
+	require.NoError(err)
+	assert.Len(logs, 3)
+
+	// Not enough sources answer
+	source = QuorumSource{Sources: []LogSource{honest, broken}, Quorum: 2}
+	_, err = source.FilterLogs(context.Background(), ethereum.FilterQuery{})
+	assert.ErrorIs(err, ErrTemporarilyUnavailable)
+
+	// One of them is lying
+	source = QuorumSource{Sources: []LogSource{honest, lying}, Quorum: 2}
+	_, err = source.FilterLogs(context.Background(), ethereum.FilterQuery{})
+	assert.ErrorIs(err, ErrSourcesDisagree)
+}
+
+type chain_id_source struct {
+	FileLogSource
+	chain_id uint64
+}
+
+func (s chain_id_source) ChainID(ctx context.Context) (uint64, error) {
+	return s.chain_id, nil
+}
+
+func TestQuorumChainID(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	mainnet := chain_id_source{chain_id: 1}
+	sepolia := chain_id_source{chain_id: 11155111}
+
+	// Checking it is charged like any other call
+	meter := &CreditMeter{}
+	source := QuorumSource{Sources: []LogSource{MeteredSource{LogSource: mainnet, Meter: meter}, mainnet}, Quorum: 2}
+	chain_id, err := source.ChainID(context.Background())
+	require.NoError(err)
+	assert.Equal(uint64(1), chain_id)
+	assert.Equal(uint64(DEFAULT_CREDIT_COST), meter.Used())
+
+	source = QuorumSource{Sources: []LogSource{mainnet, sepolia}, Quorum: 2}
+	_, err = source.ChainID(context.Background())
+	assert.ErrorIs(err, ErrSourcesDisagree)
+}
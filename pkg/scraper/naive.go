@@ -2,157 +2,271 @@ package scraper
 
 import (
 	"context"
-	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
-	"math/big"
-	"time"
+	"slices"
 
-	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
-	"github.com/ethereum/go-ethereum/rpc"
 
 	. "go-azimuth/pkg/db"
 )
 
 // Fetch all the Naive logs, and then fetch the transaction data for each log
-func CatchUpNaiveLogs(client *ethclient.Client, db DB) {
-	latest_block, err := client.BlockNumber(context.Background())
+func CatchUpNaiveLogs(ctx context.Context, source LogSource, db DB, opts Options) error {
+	latest_block, err := source.BlockNumber(ctx)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("getting latest block number: %w", err)
 	}
+	return CatchUpNaiveLogsUntil(ctx, source, db, latest_block, opts)
+}
 
+// Fetch the Naive logs (and their transaction data) from where the previous fetch left off, up to
+// and including `latest_block`.
+func CatchUpNaiveLogsUntil(ctx context.Context, source LogSource, db DB, latest_block uint64, opts Options) error {
 	contract := db.GetContractByName("Naive")
+	return fetch_logs_in_ranges(ctx, source, db, contract, latest_block, opts, naive_logs_handler(ctx, source, opts))
+}
 
-	// Assume we can fetch all the logs in 1 query
-	// TODO: not a good assumption
-	query := ethereum.FilterQuery{
-		FromBlock: big.NewInt(0).SetUint64(max(contract.StartBlockNum, contract.LatestBlockNumFetched)),
-		ToBlock:   big.NewInt(0).SetUint64(latest_block),
-		Addresses: []common.Address{contract.Address},
-	}
-	logs, err := client.FilterLogs(context.Background(), query)
-	if err != nil {
-		// TODO: this would be where to handle if there's too many logs for 1 query
-		panic(err)
-	}
+// Convert Naive logs to events, filling in the call data of the Batch ones
+func naive_logs_handler(
+	ctx context.Context, source LogSource, opts Options,
+) func([]types.Log) ([]EthereumEventLog, error) {
+	return func(logs []types.Log) ([]EthereumEventLog, error) {
+		// To get Tx data, we have to use batching; otherwise, turbo slow
+		parsed_logs := []EthereumEventLog{}
 
-	// To get Tx data, we have to use batching; otherwise, turbo slow
-	parsed_logs := []EthereumEventLog{}
+		// First, parse the Ethereum logs
+		for _, l := range logs {
+			// Add it to the list of call-data to fetch
+			parsed_logs = append(parsed_logs, ParseEthereumLog(l))
+		}
 
-	// First, save the Ethereum logs
-	for _, l := range logs {
-		fmt.Println("----------")
-		fmt.Println("Log Block Number:", l.BlockNumber)
-		fmt.Printf(EVENT_NAMES[l.Topics[0]])
-		fmt.Printf("(")
-		for _, t := range l.Topics[1:] {
-			fmt.Print(t, ", ")
+		// Then fill in their call data.  They get saved once they're complete.
+		get_call_data := GetNaiveTransactionData
+		if opts.CallDataStrategy == CALL_DATA_FROM_BLOCKS {
+			get_call_data = GetNaiveBlockData
 		}
-		fmt.Printf(")\n")
-		if len(l.Data) != 0 {
-			fmt.Println(hex.EncodeToString(l.Data))
+		if err := get_call_data(ctx, source, parsed_logs, opts); err != nil {
+			return nil, err
 		}
+		return parsed_logs, nil
+	}
+}
 
-		naive_event_log := ParseEthereumLog(l)
-		// Save it in the DB
-		db.SaveEvent(&naive_event_log)
+// Get transaction data (call-data) for Batch events, in batches (yes), and put it in the events
+func GetNaiveTransactionData(ctx context.Context, source LogSource, logs []EthereumEventLog, opts Options) error {
+	indirect := []int{} // Events whose transactions didn't call the Naive contract directly
+	for i := 0; i < len(logs); i += MAX_BATCH_SIZE {
+		// Compute batch set upper-bound
+		ii := min(i+MAX_BATCH_SIZE, len(logs))
+		ReportProgress(opts.Progress, Progress{
+			Task:         "Naive contract: fetching call data (txs)",
+			Done:         uint64(i),
+			Total:        uint64(len(logs)),
+			CurrentBlock: logs[i].BlockNumber,
+			CreditsUsed:  opts.Meter.Used(),
+		})
 
-		// Add it to the list of call-data to fetch
-		parsed_logs = append(parsed_logs, naive_event_log)
+		hashes := []common.Hash{}
+		for _, l := range logs[i:ii] {
+			hashes = append(hashes, l.TxHash)
+		}
+		txs, err := source.TransactionsByHash(ctx, hashes)
+		if err != nil {
+			return fmt.Errorf("fetching transactions: %w", err)
+		}
+		for j, tx := range txs {
+			logs[i+j].Data = tx.Input
+			logs[i+j].IsCallDataMissing = false
+			if !is_to(tx, logs[i+j].ContractAddress) {
+				indirect = append(indirect, i+j)
+			}
+		}
 	}
-
-	GetNaiveTransactionData(client, db, parsed_logs)
-	db.SetLatestContractBlockFetched(contract.ID, latest_block)
+	return fill_indirect_call_data(ctx, source, logs, indirect, opts)
 }
 
-// Get transaction data (call-data) for Batch events, in batches (yes)
-func GetNaiveTransactionData(client *ethclient.Client, db DB, logs []EthereumEventLog) {
-	contract := db.GetContractByName("Naive")
-	// Callback function to execute RPC batches
-	do_batched_rpc := func(batch []rpc.BatchElem) []*types.Transaction {
-		if err := client.Client().BatchCall(batch); err != nil {
-			log.Fatalf("Batch call failed: %v", err)
-		}
-
-		ret := []*types.Transaction{} // Has to be pointer type to avoid copying an atomic.Pointer
-		for _, elem := range batch {
-			for _, is_err_service_temp_unavailable := check_error(elem.Error); is_err_service_temp_unavailable; {
-				fmt.Printf("Service temporarily unavailable error.  Pausing 1s and trying again\n")
-				time.Sleep(1 * time.Second)
-				// Try again on temporarily-unavailable errors
-				if err := client.Client().BatchCall([]rpc.BatchElem{elem}); err != nil {
-					log.Fatalf("Batch call failed: %#v", err)
-				}
-			}
-			// rpc.BatchElem{
-			// 	Method:"eth_getTransactionByHash",
-			// 	Args:[]interface {}{0xad2f676e4c35c7271123e77bd5616d4e89ae0e93cd0f5a9e4fb93a735ded42be},
-			// 	Result:(*types.Transaction)(0xc000332180),
-			// 	Error: &rpc.jsonError{Code:-32603, Message:"service temporarily unavailable", Data:interface {}(nil)},
-			// }
-			if elem.Error != nil {
-				panic(fmt.Sprintf("%#v (%#v)", elem, elem.Error))
-			}
+// Get transaction data (call-data) for Batch events from the blocks they're in, and put it in the
+// events.  Each block only gets fetched once, no matter how many Batch events it has.
+func GetNaiveBlockData(ctx context.Context, source LogSource, logs []EthereumEventLog, opts Options) error {
+	block_nums := []uint64{}
+	for _, l := range logs {
+		if len(block_nums) == 0 || block_nums[len(block_nums)-1] != l.BlockNumber {
+			block_nums = append(block_nums, l.BlockNumber)
+		}
+	}
 
-			tx, is_ok := elem.Result.(*types.Transaction)
-			if !is_ok {
-				panic(elem.Result)
+	txs := make(map[common.Hash]Transaction)
+	block_hashes := make(map[uint64]common.Hash)
+	for i := 0; i < len(block_nums); i += MAX_BATCH_SIZE {
+		ii := min(i+MAX_BATCH_SIZE, len(block_nums))
+		ReportProgress(opts.Progress, Progress{
+			Task:         "Naive contract: fetching call data (blocks)",
+			Done:         uint64(i),
+			Total:        uint64(len(block_nums)),
+			CurrentBlock: block_nums[i],
+			CreditsUsed:  opts.Meter.Used(),
+		})
+
+		blocks, err := source.Blocks(ctx, block_nums[i:ii])
+		if err != nil {
+			return fmt.Errorf("fetching blocks: %w", err)
+		}
+		for _, b := range blocks {
+			block_hashes[uint64(b.Number)] = b.Hash
+			for _, tx := range b.Transactions {
+				txs[tx.Hash] = tx
 			}
-			ret = append(ret, tx)
 		}
-		return ret
 	}
 
-	// Construct batches
-	const MAX_BATCH_SIZE = 20
-	for i := 0; i < len(logs); i += MAX_BATCH_SIZE {
-		// Compute batch set upper-bound
-		ii := i + MAX_BATCH_SIZE
-		if ii > len(logs) {
-			ii = len(logs)
+	indirect := []int{} // Events whose transactions didn't call the Naive contract directly
+	for i, l := range logs {
+		if block_hashes[l.BlockNumber] != l.BlockHash {
+			// Must have been a reorg since the logs were fetched
+			return fmt.Errorf("block %d has hash %s, but the log says %s", l.BlockNumber, block_hashes[l.BlockNumber], l.BlockHash)
+		}
+		tx, is_ok := txs[l.TxHash]
+		if !is_ok {
+			return fmt.Errorf("transaction %s not found in block %d", l.TxHash, l.BlockNumber)
 		}
+		logs[i].Data = tx.Input
+		logs[i].IsCallDataMissing = false
+		if !is_to(tx, l.ContractAddress) {
+			indirect = append(indirect, i)
+		}
+	}
+	return fill_indirect_call_data(ctx, source, logs, indirect, opts)
+}
 
-		fmt.Printf("===================================\ni: %d\n======================================\n\n", i)
-		fmt.Printf("Naive contract: fetching tx data %d - %d; total txs is %d\n", i, ii, len(logs))
+// Whether a transaction is a call to `address` (rather than to some other contract that calls it)
+func is_to(tx Transaction, address common.Address) bool {
+	return tx.To != nil && *tx.To == address
+}
 
-		// Prepare a batch for RPC-ing
-		batch := []rpc.BatchElem{}
-		// Index to let us map back from tx hash to update the logs
-		logs_by_txhash := make(map[common.Hash]EthereumEventLog)
-		for _, l := range logs[i:ii] {
-			batch = append(batch,
-				rpc.BatchElem{
-					Method: "eth_getTransactionByHash",
-					Args:   []interface{}{l.TxHash},
-					Result: new(types.Transaction), // Use go-ethereum's Transaction struct
-				},
-			)
-
-			// Also build the index of tx hashes to logs, while we're here
-			logs_by_txhash[l.TxHash] = l
-		}
-
-		// Execute the batch
-		txs := do_batched_rpc(batch)
-
-		// Save the result in the DB
-		for _, tx := range txs {
-			log, is_ok := logs_by_txhash[tx.Hash()]
-			if !is_ok {
-				panic(tx.Hash())
+// Fill in the call data of Batch events whose transactions called the Naive contract through some
+// other contract (e.g., a multisig), from call traces.  The transaction's own call data is for that
+// other contract, not a batch, so it can't be used.
+//
+// If there's no traces (e.g., the node doesn't have `trace_filter`), or they don't line up with the
+// events, the events get no call data, so they don't get played (see `GetBatchesMissingCallData`),
+// and `opts.Progress` gets a note about it.  `RepairNaiveCallData` can try them again later.
+//
+// WTF: a call that succeeded can still be undone, if something that called it reverted afterward.
+// Then there's more successful calls than Batch events in the transaction, and they can't be told
+// apart, so they don't line up.
+func fill_indirect_call_data(
+	ctx context.Context, source LogSource, logs []EthereumEventLog, indirect []int, opts Options,
+) error {
+	// Each block's traces only get fetched once
+	traces := make(map[uint64][]CallTrace)
+	is_traced := make(map[uint64]bool)
+	for _, i := range indirect {
+		logs[i].Data = []byte{}
+		logs[i].IsCallDataMissing = true
+		block_num := logs[i].BlockNumber
+		if _, is_ok := traces[block_num]; is_ok {
+			continue
+		}
+		block_traces, err := source.TraceCalls(ctx, block_num, block_num, logs[i].ContractAddress)
+		if errors.Is(err, ErrNotSupported) {
+			ReportProgress(opts.Progress, Progress{
+				Task:         "Naive contract: fetching call data",
+				CurrentBlock: block_num,
+				CreditsUsed:  opts.Meter.Used(),
+				Note: fmt.Sprintf("Batch event in transaction %s was sent through another contract, and there's no "+
+					"call traces to get its call data from (%s); it won't be played until it's repaired",
+					logs[i].TxHash, err),
+			})
+		} else if err != nil {
+			return fmt.Errorf("fetching call traces for block %d: %w", block_num, err)
+		}
+		traces[block_num] = block_traces
+		is_traced[block_num] = err == nil
+	}
+
+	// Match up each transaction's Batch events with its successful calls to the Naive contract, in order
+	tx_hashes := []common.Hash{}
+	for _, i := range indirect {
+		if !slices.Contains(tx_hashes, logs[i].TxHash) {
+			tx_hashes = append(tx_hashes, logs[i].TxHash)
+		}
+	}
+	for _, tx_hash := range tx_hashes {
+		events := []int{}
+		for _, i := range indirect {
+			if logs[i].TxHash == tx_hash {
+				events = append(events, i)
 			}
-			log.Data = tx.Data()
-			db.SmuggleNaiveBatchDataIntoEvent(log)
 		}
+		if !is_traced[logs[events[0]].BlockNumber] {
+			continue
+		}
+		calls := []CallTrace{}
+		for _, t := range traces[logs[events[0]].BlockNumber] {
+			if t.TxHash == tx_hash && t.Action.To == logs[events[0]].ContractAddress && t.Error == "" {
+				calls = append(calls, t)
+			}
+		}
+		slices.SortFunc(calls, func(a, b CallTrace) int {
+			return slices.Compare(a.TraceAddress, b.TraceAddress)
+		})
+		if len(calls) != len(events) {
+			ReportProgress(opts.Progress, Progress{
+				Task:         "Naive contract: fetching call data",
+				CurrentBlock: logs[events[0]].BlockNumber,
+				CreditsUsed:  opts.Meter.Used(),
+				Note: fmt.Sprintf("Transaction %s has %d Batch events but %d calls to the Naive contract were "+
+					"found; they won't be played until they're repaired", tx_hash, len(events), len(calls)),
+			})
+			continue
+		}
+		for j, i := range events {
+			logs[i].Data = calls[j].Action.Input
+			logs[i].IsCallDataMissing = false
+		}
+	}
+	return nil
+}
 
-		// Update latest-block-fetched
-		// WTF: transactions do not expose a block number, and you can't get it with ethclient
-		// See: https://github.com/ethereum/go-ethereum/issues/15210
-		db.SetLatestContractBlockFetched(contract.ID, logs[ii-1].BlockNumber)
+// Fetch the call data of Batch events that don't have it (see `GetBatchesMissingCallData`), and
+// save it.  Returns the events that got fixed; any that were already played got played as empty
+// batches, so the points they touched are wrong until the DB is rebuilt.
+func RepairNaiveCallData(ctx context.Context, source LogSource, db DB, opts Options) ([]EthereumEventLog, error) {
+	defer opts.Meter.Save(db)
+	get_call_data := GetNaiveTransactionData
+	if opts.CallDataStrategy == CALL_DATA_FROM_BLOCKS {
+		get_call_data = GetNaiveBlockData
+	}
 
-		time.Sleep(1 * time.Second)
+	ret := []EthereumEventLog{}
+	after_id := uint64(0)
+	for {
+		if err := ctx.Err(); err != nil {
+			return ret, err
+		}
+		events := db.GetBatchesMissingCallData(after_id, 1000)
+		if len(events) == 0 {
+			return ret, nil
+		}
+		if err := get_call_data(ctx, source, events, opts); err != nil {
+			return ret, err
+		}
+		for _, e := range events {
+			// Even if it's empty; it's been found
+			if !e.IsCallDataMissing {
+				db.SmuggleNaiveBatchDataIntoEvent(e)
+				ret = append(ret, e)
+			}
+		}
+		after_id = events[len(events)-1].ID
+		opts.Meter.Save(db)
+		ReportProgress(opts.Progress, Progress{
+			Task:         "Repairing Batch call data",
+			Done:         uint64(len(ret)),
+			CurrentBlock: events[len(events)-1].BlockNumber,
+			CreditsUsed:  opts.Meter.Used(),
+		})
 	}
 }
@@ -0,0 +1,148 @@
+package scraper_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "go-azimuth/pkg/db"
+	. "go-azimuth/pkg/scraper"
+)
+
+func TestRepairNaiveCallData(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	source, err := NewFileLogSource("testdata/logs.ndjson")
+	require.NoError(err)
+	db, err := DBCreate(":memory:")
+	require.NoError(err)
+	db.SetLatestContractBlockFetched(db.GetContractByName("Azimuth").ID, 13369000)
+
+	ctx := context.Background()
+	require.NoError(CatchUpAzimuthLogs(ctx, source, db, Options{}))
+	require.NoError(CatchUpNaiveLogs(ctx, source, db, Options{}))
+	db.SetFinalizedBlock(13369856) // The whole file
+
+	// Lose the call data, like an interrupted fetch used to
+	db.DB.MustExec(`update ethereum_events set data = X'', is_call_data_missing = 1 where topic0 = ?`, BATCH)
+	assert.ErrorIs(db.PlayNaiveLogs(ctx, nil), ErrMissingCallData)
+	// Everything before it got played
+	var num_played int
+	require.NoError(db.DB.Get(&num_played, `select count(*) from ethereum_events where is_processed = 1`))
+	assert.Equal(2, num_played)
+	assert.Len(db.GetBatchesMissingCallData(0, 10), 1)
+
+	repaired, err := RepairNaiveCallData(ctx, source, db, Options{})
+	require.NoError(err)
+	require.Len(repaired, 1)
+	assert.False(repaired[0].IsProcessed)
+	var batch EthereumEventLog
+	require.NoError(db.DB.Get(&batch, `select * from ethereum_events where topic0 = ?`, BATCH))
+	assert.Equal([]byte(source.Transactions[batch.TxHash].Input), batch.Data)
+	assert.Len(db.GetBatchesMissingCallData(0, 10), 0)
+	// So nothing's holding up playing it anymore.  (Not played here; the file doesn't have the
+	// points it's about.)
+	assert.Equal(uint64(0), db.CountUnplayedBatchesMissingCallData())
+
+	// A batch whose call data is really empty gets repaired too, rather than staying missing forever
+	db.DB.MustExec(`update ethereum_events set data = X'', is_call_data_missing = 1 where topic0 = ?`, BATCH)
+	empty_source, err := NewFileLogSource("testdata/logs.ndjson")
+	require.NoError(err)
+	tx := empty_source.Transactions[batch.TxHash]
+	tx.Input = nil
+	empty_source.Transactions[batch.TxHash] = tx
+	repaired, err = RepairNaiveCallData(ctx, empty_source, db, Options{})
+	require.NoError(err)
+	require.Len(repaired, 1)
+	assert.Len(db.GetBatchesMissingCallData(0, 10), 0)
+
+	// And it gets played (as an empty batch), instead of holding everything up
+	require.NoError(db.PlayNaiveLogs(ctx, nil))
+	require.NoError(db.DB.Get(&num_played, `select count(*) from ethereum_events where is_processed = 1`))
+	assert.Equal(3, num_played)
+}
+
+// A LogSource with call traces
+type traced_source struct {
+	FileLogSource
+	traces []CallTrace
+}
+
+func (s traced_source) TraceCalls(
+	ctx context.Context, from_block uint64, to_block uint64, to_address common.Address,
+) ([]CallTrace, error) {
+	ret := []CallTrace{}
+	for _, t := range s.traces {
+		if t.BlockNumber >= from_block && t.BlockNumber <= to_block && t.Action.To == to_address {
+			ret = append(ret, t)
+		}
+	}
+	return ret, nil
+}
+
+func TestIndirectNaiveBatch(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	source, err := NewFileLogSource("testdata/logs.ndjson")
+	require.NoError(err)
+	naive_address := common.HexToAddress("eb70029cfb3c53c778eaf68cd28de725390a1fe9")
+	multisig := common.HexToAddress("5555555555555555555555555555555555555555")
+
+	// Send the batch through a multisig instead
+	var batch_tx Transaction
+	for _, tx := range source.Transactions {
+		if tx.To != nil && *tx.To == naive_address {
+			batch_tx = tx
+		}
+	}
+	source.Transactions[batch_tx.Hash] = Transaction{Hash: batch_tx.Hash, To: &multisig, Input: []byte{1, 2, 3, 4}}
+	batch_call := CallTrace{BlockNumber: 13369856, TxHash: batch_tx.Hash, TraceAddress: []uint64{0}}
+	batch_call.Action.From = multisig
+	batch_call.Action.To = naive_address
+	batch_call.Action.Input = batch_tx.Input
+	reverted_call := batch_call
+	reverted_call.TraceAddress = []uint64{1}
+	reverted_call.Action.Input = []byte{5, 6, 7, 8}
+	reverted_call.Error = "Reverted"
+
+	for _, strategy := range []CallDataStrategy{CALL_DATA_FROM_TXS, CALL_DATA_FROM_BLOCKS} {
+		ctx := context.Background()
+		notes := []string{}
+		opts := Options{CallDataStrategy: strategy, Progress: ProgressFunc(func(p Progress) {
+			if p.Note != "" {
+				notes = append(notes, p.Note)
+			}
+		})}
+
+		// Without traces, the batch gets no call data, rather than the multisig's
+		db, err := DBCreate(":memory:")
+		require.NoError(err)
+		require.NoError(CatchUpNaiveLogs(ctx, source, db, opts))
+		require.Len(notes, 1)
+		assert.Contains(notes[0], "was sent through another contract")
+		db.SetFinalizedBlock(13369856) // The whole file
+		require.Len(db.GetBatchesMissingCallData(0, 10), 1)
+		assert.ErrorIs(db.PlayNaiveLogs(ctx, nil), ErrMissingCallData)
+
+		// With them, it gets repaired
+		traced := traced_source{FileLogSource: source, traces: []CallTrace{reverted_call, batch_call}}
+		repaired, err := RepairNaiveCallData(ctx, traced, db, opts)
+		require.NoError(err)
+		require.Len(repaired, 1)
+		assert.Equal([]byte(batch_tx.Input), repaired[0].Data)
+
+		// And gets the right call data in the first place
+		db, err = DBCreate(":memory:")
+		require.NoError(err)
+		require.NoError(CatchUpNaiveLogs(ctx, traced, db, opts))
+		assert.Len(db.GetBatchesMissingCallData(0, 10), 0)
+		var batch EthereumEventLog
+		require.NoError(db.DB.Get(&batch, `select * from ethereum_events where topic0 = ?`, BATCH))
+		assert.Equal([]byte(batch_tx.Input), batch.Data)
+	}
+}
@@ -0,0 +1,75 @@
+package scraper
+
+import (
+	"fmt"
+	"strconv"
+
+	. "go-azimuth/pkg/db"
+)
+
+// How to get the call data of Naive Batch transactions
+type CallDataStrategy int
+
+const (
+	// Look up each transaction by hash.  Small responses, but one call per transaction.
+	CALL_DATA_FROM_TXS CallDataStrategy = iota
+	// Get each block that has Batch logs, with all its transactions, and pick out the Batch ones.
+	// One call per block, but the responses are much bigger.
+	CALL_DATA_FROM_BLOCKS
+)
+
+func ParseCallDataStrategy(s string) (CallDataStrategy, error) {
+	switch s {
+	case "txs":
+		return CALL_DATA_FROM_TXS, nil
+	case "blocks":
+		return CALL_DATA_FROM_BLOCKS, nil
+	}
+	return 0, fmt.Errorf("invalid call data strategy %q (should be \"txs\" or \"blocks\")", s)
+}
+
+// When a block counts as final, i.e., its events can be played.  Either a block tag ("finalized",
+// "safe" or "latest"), or a number of confirmations (blocks on top of it).  The zero value means
+// "finalized"; zero confirmations is spelled "latest" (see `ParseFinality`).
+type Finality struct {
+	BlockTag      string
+	Confirmations uint64
+}
+
+func ParseFinality(s string) (Finality, error) {
+	switch s {
+	case "finalized", "safe", "latest":
+		return Finality{BlockTag: s}, nil
+	}
+	confirmations, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return Finality{}, fmt.Errorf("invalid finality %q (should be \"finalized\", \"safe\", \"latest\" or a number of "+
+			"confirmations)", s)
+	}
+	if confirmations == 0 {
+		// Same thing, but `Finality{}` would mean "finalized"
+		return Finality{BlockTag: "latest"}, nil
+	}
+	return Finality{Confirmations: confirmations}, nil
+}
+
+// Settings for the scraper.  The zero value is the defaults.
+type Options struct {
+	CallDataStrategy CallDataStrategy
+	Finality         Finality
+	// Whether to fetch galaxies' votes on polls.  Needs a node with `trace_filter`.
+	FetchVotes bool
+	// Whether to fetch calls to the star release contracts, to find out who locked-up stars belong to.
+	// Needs a node with `trace_filter`.
+	FetchLockups bool
+	// Whether to fetch the receipt of every transaction that emitted an Azimuth event, to find out who
+	// sent it (and so which of the point's roles did it).  One more call per transaction.
+	FetchSenders bool
+	// How many ranges of blocks to fetch logs from at once.  0 means 1.
+	Workers int
+	// Gets told how fetching is going.  Nil means nobody's listening.
+	Progress ProgressReporter
+	// The meter that the source charges calls to, if it's a MeteredSource.  Credits used get saved in
+	// the DB as ranges of blocks get saved, and show up in progress reports.
+	Meter *CreditMeter
+}
@@ -0,0 +1,138 @@
+package scraper
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	. "go-azimuth/pkg/db"
+)
+
+// Function selectors for voting on polls
+var (
+	CAST_UPGRADE_VOTE  = get_selector("castUpgradeVote(uint8,address,bool)")
+	CAST_DOCUMENT_VOTE = get_selector("castDocumentVote(uint8,bytes32,bool)")
+)
+
+// Fetches all the Polls logs (and votes, if `opts.FetchVotes` is set) so far, in chunks.
+func CatchUpPollsLogs(ctx context.Context, source LogSource, db DB, opts Options) error {
+	latest_block, err := source.BlockNumber(ctx)
+	if err != nil {
+		return fmt.Errorf("getting latest block number: %w", err)
+	}
+	return CatchUpPollsLogsUntil(ctx, source, db, latest_block, opts)
+}
+
+// Fetches Polls logs from where the previous fetch left off, up to and including `latest_block`;
+// then votes, if `opts.FetchVotes` is set.
+//
+// (The times of the blocks where polls started get saved along with the logs; that's when their
+// voting periods started.)
+func CatchUpPollsLogsUntil(ctx context.Context, source LogSource, db DB, latest_block uint64, opts Options) error {
+	err := fetch_tracked_logs(ctx, source, db, "Polls", latest_block, opts)
+	if err != nil || !opts.FetchVotes {
+		return err
+	}
+	return CatchUpPollVotesUntil(ctx, source, db, latest_block, opts)
+}
+
+// Convert a call to the Polls contract into a vote.  Returns false if it isn't a (successful) vote.
+//
+// Calldata is the selector, then 3 words: the galaxy (uint8), the proposal (an address or a
+// bytes32), and the vote (bool).
+func ParsePollVote(t CallTrace) (PollVote, bool) {
+	input := []byte(t.Action.Input)
+	if t.Error != "" || len(input) != 4+3*32 {
+		return PollVote{}, false
+	}
+	ret := PollVote{
+		BlockNumber:  t.BlockNumber,
+		TxHash:       t.TxHash[:],
+		TraceAddress: t.FormatTraceAddress(),
+		Galaxy:       AzimuthNumber(input[4+31]),
+		Vote:         input[4+2*32+31] == 1,
+	}
+	switch {
+	case bytes.Equal(input[:4], CAST_UPGRADE_VOTE):
+		ret.Kind = "upgrade"
+		ret.Proposal = input[4+32+12 : 4+2*32]
+	case bytes.Equal(input[:4], CAST_DOCUMENT_VOTE):
+		ret.Kind = "document"
+		ret.Proposal = input[4+32 : 4+2*32]
+	default:
+		return PollVote{}, false
+	}
+	return ret, true
+}
+
+// Fetches galaxies' votes from where the previous fetch left off, up to and including
+// `latest_block`.  Votes don't emit events, so this uses call traces, which need a node that has
+// `trace_filter` (e.g., Erigon or Nethermind; most hosted providers don't).
+//
+// WTF: a vote call that succeeded can still be undone, if something that called it reverted
+// afterward.  Ecliptic doesn't do anything after calling Polls that could revert, so this doesn't
+// check.
+func CatchUpPollVotesUntil(ctx context.Context, source LogSource, db DB, latest_block uint64, opts Options) error {
+	contract, is_ok := db.FindContractByName("Polls")
+	if !is_ok {
+		// Not on this network
+		return nil
+	}
+	from_block := max(contract.StartBlockNum, db.GetPollVotesFetched()+1)
+	return fetch_traces_in_ranges(ctx, source, db, contract, from_block, latest_block, opts,
+		func(traces []CallTrace, to_block uint64) error {
+			votes := []PollVote{}
+			for _, t := range traces {
+				if vote, is_vote := ParsePollVote(t); is_vote {
+					votes = append(votes, vote)
+				}
+			}
+			db.SavePollVotes(votes, to_block)
+			return nil
+		})
+}
+
+// Fetch the calls to a contract, from `from_block` up to and including `latest_block`, in ranges
+// of blocks.  Each range's calls are passed to `handle_traces`, which should save them and mark the
+// range as fetched, in one go.
+//
+// Like logs, a range that the node says has too many calls gets fetched in pieces, and makes the
+// next ranges smaller; they grow back (up to LOG_RANGE_SIZE) once they stop needing to be split.
+func fetch_traces_in_ranges(
+	ctx context.Context, source LogSource, db DB, contract Contract, from_block uint64, latest_block uint64,
+	opts Options, handle_traces func(traces []CallTrace, to_block uint64) error,
+) error {
+	defer opts.Meter.Save(db)
+	range_size := uint64(LOG_RANGE_SIZE)
+	first_block := from_block
+	for from_block <= latest_block {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("%s contract: %w", contract.Name, err)
+		}
+		to_block := min(latest_block, from_block+range_size-1)
+		traces, is_split, err := get_splitting(from_block, to_block, func(from_block, to_block uint64) ([]CallTrace, error) {
+			return source.TraceCalls(ctx, from_block, to_block, contract.Address)
+		})
+		if err != nil {
+			return fmt.Errorf("%s contract: fetching calls in %w", contract.Name, err)
+		}
+		if err := handle_traces(traces, to_block); err != nil {
+			return fmt.Errorf("%s contract: processing blocks %d - %d: %w", contract.Name, from_block, to_block, err)
+		}
+		opts.Meter.Save(db)
+		ReportProgress(opts.Progress, Progress{
+			Task:         contract.Name + " contract: fetching calls",
+			Done:         to_block - first_block + 1,
+			Total:        latest_block - first_block + 1,
+			CurrentBlock: to_block,
+			CreditsUsed:  opts.Meter.Used(),
+		})
+		if is_split {
+			range_size = max(MIN_LOG_RANGE_SIZE, range_size/2)
+		} else {
+			range_size = min(LOG_RANGE_SIZE, range_size*2)
+		}
+		from_block = to_block + 1
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package scraper_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "go-azimuth/pkg/db"
+	. "go-azimuth/pkg/scraper"
+)
+
+func TestParsePollVote(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	new_ecliptic := common.HexToAddress("e1e1e1e1e1e1e1e1e1e1e1e1e1e1e1e1e1e1e1e1")
+	document := common.HexToHash("d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0")
+	call := func(selector []byte, galaxy byte, proposal common.Hash, is_yes bool) CallTrace {
+		var ret CallTrace
+		ret.BlockNumber = 123
+		ret.TraceAddress = []uint64{0, 2}
+		vote := common.Hash{}
+		if is_yes {
+			vote = common.BigToHash(common.Big1)
+		}
+		ret.Action.Input = append(append(append(append([]byte{}, selector...), common.Hash{31: galaxy}.Bytes()...),
+			proposal.Bytes()...), vote.Bytes()...)
+		return ret
+	}
+
+	v, is_vote := ParsePollVote(call(CAST_UPGRADE_VOTE, 7, common.BytesToHash(new_ecliptic[:]), true))
+	require.True(is_vote)
+	assert.Equal("upgrade", v.Kind)
+	assert.Equal(new_ecliptic[:], v.Proposal)
+	assert.Equal(AzimuthNumber(7), v.Galaxy)
+	assert.True(v.Vote)
+	assert.Equal(uint64(123), v.BlockNumber)
+	assert.Equal("0,2", v.TraceAddress)
+
+	v, is_vote = ParsePollVote(call(CAST_DOCUMENT_VOTE, 0, document, false))
+	require.True(is_vote)
+	assert.Equal("document", v.Kind)
+	assert.Equal(document[:], v.Proposal)
+	assert.Equal(AzimuthNumber(0), v.Galaxy)
+	assert.False(v.Vote)
+
+	// Failed calls and other functions aren't votes
+	failed := call(CAST_DOCUMENT_VOTE, 0, document, true)
+	failed.Error = "Reverted"
+	_, is_vote = ParsePollVote(failed)
+	assert.False(is_vote)
+	_, is_vote = ParsePollVote(call([]byte{1, 2, 3, 4}, 0, document, true))
+	assert.False(is_vote)
+}
+
+// A node with a lot of votes, which won't return more than `max_results` calls at once
+type busy_trace_source struct {
+	FileLogSource
+	votes       []CallTrace
+	max_results int
+	num_calls   *int
+}
+
+func (s busy_trace_source) TraceCalls(
+	ctx context.Context, from_block uint64, to_block uint64, to_address common.Address,
+) ([]CallTrace, error) {
+	*s.num_calls += 1
+	ret := []CallTrace{}
+	for _, v := range s.votes {
+		if v.BlockNumber >= from_block && v.BlockNumber <= to_block {
+			ret = append(ret, v)
+		}
+	}
+	if len(ret) > s.max_results {
+		return nil, &TooManyResultsError{Err: errors.New("response size exceeded")}
+	}
+	return ret, nil
+}
+
+func TestSplitBusyVoteRanges(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	db, err := DBCreate(":memory:")
+	require.NoError(err)
+	polls := db.GetContractByName("Polls")
+
+	// 40 votes packed into a few thousand blocks
+	votes := []CallTrace{}
+	for i := 0; i < 40; i++ {
+		var v CallTrace
+		v.BlockNumber = polls.StartBlockNum + 1000 + uint64(i)*100
+		v.TxHash = common.BigToHash(common.Big1)
+		v.TraceAddress = []uint64{uint64(i)}
+		v.Action.Input = append(append(append(append([]byte{}, CAST_DOCUMENT_VOTE...), common.Hash{31: 1}.Bytes()...),
+			common.HexToHash("d0").Bytes()...), common.BigToHash(common.Big1).Bytes()...)
+		votes = append(votes, v)
+	}
+	num_calls := 0
+	source := busy_trace_source{votes: votes, max_results: 5, num_calls: &num_calls}
+
+	latest_block := polls.StartBlockNum + 300000
+	require.NoError(CatchUpPollVotesUntil(context.Background(), source, db, latest_block, Options{}))
+	var num_votes int
+	require.NoError(db.DB.Get(&num_votes, `select count(*) from poll_votes`))
+	assert.Equal(40, num_votes)
+	assert.Equal(latest_block, db.GetPollVotesFetched())
+	assert.Greater(num_calls, 3)
+
+	// A single block with too many can't be split any further
+	for i := range votes {
+		votes[i].BlockNumber = latest_block + 10
+	}
+	err = CatchUpPollVotesUntil(context.Background(), source, db, latest_block+100, Options{})
+	var too_many_results_err *TooManyResultsError
+	assert.ErrorAs(err, &too_many_results_err)
+	assert.Equal(latest_block, db.GetPollVotesFetched())
+}
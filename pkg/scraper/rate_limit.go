@@ -0,0 +1,114 @@
+package scraper
+
+import (
+	"context"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// A LogSource that makes at most a certain number of calls per second to another one, no matter
+// how many goroutines are using it.  (A batch of calls counts as one.)  Calls wait their turn.
+type RateLimitedSource struct {
+	LogSource
+	ticker *time.Ticker
+}
+
+func NewRateLimitedSource(source LogSource, requests_per_second float64) RateLimitedSource {
+	return RateLimitedSource{
+		LogSource: source,
+		ticker:    time.NewTicker(time.Duration(float64(time.Second) / requests_per_second)),
+	}
+}
+
+// Stop the rate limiter's ticker.  The source can't be used after this.
+func (s RateLimitedSource) Stop() {
+	s.ticker.Stop()
+}
+
+// Wait for the next turn to make a call
+func (s RateLimitedSource) wait(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.ticker.C:
+		return nil
+	}
+}
+
+func (s RateLimitedSource) ChainID(ctx context.Context) (uint64, error) {
+	if err := s.wait(ctx); err != nil {
+		return 0, err
+	}
+	return s.LogSource.ChainID(ctx)
+}
+
+func (s RateLimitedSource) BlockNumber(ctx context.Context) (uint64, error) {
+	if err := s.wait(ctx); err != nil {
+		return 0, err
+	}
+	return s.LogSource.BlockNumber(ctx)
+}
+
+func (s RateLimitedSource) TaggedBlockNumber(ctx context.Context, tag string) (uint64, error) {
+	if err := s.wait(ctx); err != nil {
+		return 0, err
+	}
+	return s.LogSource.TaggedBlockNumber(ctx, tag)
+}
+
+func (s RateLimitedSource) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	if err := s.wait(ctx); err != nil {
+		return nil, err
+	}
+	return s.LogSource.FilterLogs(ctx, q)
+}
+
+func (s RateLimitedSource) TransactionsByHash(ctx context.Context, hashes []common.Hash) ([]Transaction, error) {
+	if err := s.wait(ctx); err != nil {
+		return nil, err
+	}
+	return s.LogSource.TransactionsByHash(ctx, hashes)
+}
+
+func (s RateLimitedSource) BlockHeaders(ctx context.Context, block_nums []uint64) ([]BlockHeader, error) {
+	if err := s.wait(ctx); err != nil {
+		return nil, err
+	}
+	return s.LogSource.BlockHeaders(ctx, block_nums)
+}
+
+func (s RateLimitedSource) Blocks(ctx context.Context, block_nums []uint64) ([]Block, error) {
+	if err := s.wait(ctx); err != nil {
+		return nil, err
+	}
+	return s.LogSource.Blocks(ctx, block_nums)
+}
+
+func (s RateLimitedSource) BlockReceipts(ctx context.Context, block_nums []uint64) ([][]Receipt, error) {
+	if err := s.wait(ctx); err != nil {
+		return nil, err
+	}
+	return s.LogSource.BlockReceipts(ctx, block_nums)
+}
+
+func (s RateLimitedSource) TransactionReceipts(ctx context.Context, hashes []common.Hash) ([]Receipt, error) {
+	if err := s.wait(ctx); err != nil {
+		return nil, err
+	}
+	return s.LogSource.TransactionReceipts(ctx, hashes)
+}
+
+func (s RateLimitedSource) TraceCalls(
+	ctx context.Context, from_block uint64, to_block uint64, to_address common.Address,
+) ([]CallTrace, error) {
+	if err := s.wait(ctx); err != nil {
+		return nil, err
+	}
+	return s.LogSource.TraceCalls(ctx, from_block, to_block, to_address)
+}
@@ -0,0 +1,45 @@
+package scraper_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "go-azimuth/pkg/scraper"
+)
+
+func TestRateLimit(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	file_source, err := NewFileLogSource("testdata/logs.ndjson")
+	require.NoError(err)
+	source := NewRateLimitedSource(file_source, 100)
+	defer source.Stop()
+
+	// 10 calls from 5 goroutines take at least 10 ticks
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 2 {
+				_, err := source.BlockNumber(context.Background())
+				assert.NoError(err)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.GreaterOrEqual(time.Since(start), 90*time.Millisecond)
+
+	// Waiting gets cancelled along with the context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = source.BlockNumber(ctx)
+	assert.ErrorIs(err, context.Canceled)
+}
@@ -0,0 +1,214 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	. "go-azimuth/pkg/db"
+)
+
+// Get the canonical hashes of a bunch of blocks.  Blocks the source doesn't have (e.g., because
+// the chain got shorter) get a zero hash.
+func get_block_hashes(ctx context.Context, source LogSource, block_nums []uint64) (map[uint64]common.Hash, error) {
+	headers, err := source.BlockHeaders(ctx, block_nums)
+	if err != nil {
+		return nil, fmt.Errorf("getting block hashes: %w", err)
+	}
+	ret := make(map[uint64]common.Hash)
+	for i, h := range headers {
+		ret[block_nums[i]] = h.Hash
+	}
+	return ret, nil
+}
+
+// Get the hashes and timestamps of a bunch of blocks.  Blocks the source doesn't have are left out.
+func get_block_infos(ctx context.Context, source LogSource, block_nums []uint64) ([]BlockInfo, error) {
+	if len(block_nums) == 0 {
+		return []BlockInfo{}, nil
+	}
+	headers, err := source.BlockHeaders(ctx, block_nums)
+	if err != nil {
+		return nil, fmt.Errorf("getting block headers: %w", err)
+	}
+	ret := []BlockInfo{}
+	for i, h := range headers {
+		if h.Hash == (common.Hash{}) {
+			continue
+		}
+		ret = append(ret, BlockInfo{Number: block_nums[i], Hash: h.Hash, Timestamp: uint64(h.Timestamp)})
+	}
+	return ret, nil
+}
+
+// Get the senders of a bunch of transactions, from their receipts
+func get_transaction_infos(ctx context.Context, source LogSource, tx_hashes []common.Hash) ([]TransactionInfo, error) {
+	if len(tx_hashes) == 0 {
+		return []TransactionInfo{}, nil
+	}
+	receipts, err := source.TransactionReceipts(ctx, tx_hashes)
+	if err != nil {
+		return nil, fmt.Errorf("getting transaction receipts: %w", err)
+	}
+	ret := []TransactionInfo{}
+	for _, r := range receipts {
+		ret = append(ret, TransactionInfo{
+			TxHash:      r.TxHash,
+			BlockNumber: uint64(r.BlockNumber),
+			FromAddress: r.From,
+			GasUsed:     uint64(r.GasUsed),
+		})
+	}
+	return ret, nil
+}
+
+// Fetch the senders of transactions with Azimuth events that don't have one yet, e.g., ones that
+// were fetched without `FetchSenders`.  Events that were already played get their sender roles
+// worked out as their senders get saved.
+func BackfillTransactionSenders(ctx context.Context, source LogSource, db DB, opts Options) error {
+	defer opts.Meter.Save(db)
+	done := uint64(0)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// Each batch gets saved, so it won't come up again
+		missing := db.GetEventTxsWithoutSenders(1000)
+		if len(missing) == 0 {
+			return nil
+		}
+		tx_hashes := []common.Hash{}
+		for _, tx := range missing {
+			tx_hashes = append(tx_hashes, tx.TxHash)
+		}
+		txs, err := get_transaction_infos(ctx, source, tx_hashes)
+		if err != nil {
+			return err
+		}
+		db.SaveTransactions(txs)
+		done += uint64(len(txs))
+		opts.Meter.Save(db)
+		ReportProgress(opts.Progress, Progress{
+			Task:         "Fetching transaction senders",
+			Done:         done,
+			CurrentBlock: missing[len(missing)-1].BlockNumber,
+			CreditsUsed:  opts.Meter.Used(),
+		})
+	}
+}
+
+// Fetch the timestamps of blocks with events that don't have one yet, e.g., ones that were
+// fetched before timestamps were stored.  Blocks the source doesn't have a timestamp for (e.g., it's
+// a logs file) get recorded as unknown, so they aren't asked for again next time.
+func BackfillBlockTimestamps(ctx context.Context, source LogSource, db DB, opts Options) error {
+	defer opts.Meter.Save(db)
+	done := uint64(0)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// Each batch gets saved, one way or the other, so it won't come up again
+		block_nums := db.GetEventBlocksWithoutTimestamps(0, 1000)
+		if len(block_nums) == 0 {
+			return nil
+		}
+		blocks, err := get_block_infos(ctx, source, block_nums)
+		if err != nil {
+			return err
+		}
+		unknown := slices.Clone(block_nums)
+		for _, b := range blocks {
+			if b.Timestamp == 0 {
+				continue
+			}
+			db.SaveBlock(b.Number, b.Hash, b.Timestamp)
+			unknown = slices.DeleteFunc(unknown, func(n uint64) bool { return n == b.Number })
+		}
+		db.SaveUnknownBlockTimestamps(unknown)
+		done += uint64(len(block_nums))
+		opts.Meter.Save(db)
+		ReportProgress(opts.Progress, Progress{
+			Task:         "Fetching block timestamps",
+			Done:         done,
+			CurrentBlock: block_nums[len(block_nums)-1],
+			CreditsUsed:  opts.Meter.Used(),
+		})
+	}
+}
+
+// Record the hash of the chain head, so a later reorg can be detected even if there were no
+// events in that block
+func SaveBlockHash(ctx context.Context, source LogSource, db DB, block_num uint64) error {
+	headers, err := source.BlockHeaders(ctx, []uint64{block_num})
+	if err != nil {
+		return fmt.Errorf("getting block hashes: %w", err)
+	}
+	db.SaveBlock(block_num, headers[0].Hash, uint64(headers[0].Timestamp))
+	return nil
+}
+
+// Compare the block hashes in the DB against the canonical chain, over the last REORG_WINDOW
+// blocks before `latest_block`.
+//
+// If any of them don't match, returns the first block that needs to be re-fetched.  That's the
+// block after the newest one that still matches (everything up to a matching block must be
+// canonical too), since blocks that had no events before might have some now.  Each mismatch gets
+// reported to `opts.Progress`.
+func DetectReorg(
+	ctx context.Context, source LogSource, db DB, latest_block uint64, opts Options,
+) (uint64, bool, error) {
+	from_block := uint64(0)
+	if latest_block > REORG_WINDOW {
+		from_block = latest_block - REORG_WINDOW
+	}
+
+	stored_hashes := db.GetBlockHashesSince(from_block)
+	if len(stored_hashes) == 0 {
+		return 0, false, nil
+	}
+	block_nums := []uint64{}
+	for n := range stored_hashes {
+		block_nums = append(block_nums, n)
+	}
+	slices.Sort(block_nums)
+
+	canonical_hashes, err := get_block_hashes(ctx, source, block_nums)
+	if err != nil {
+		return 0, false, err
+	}
+
+	// Find the newest block that still matches, and whether anything after it doesn't
+	rollback_to := from_block
+	is_reorged := false
+	for _, n := range block_nums {
+		if stored_hashes[n] == canonical_hashes[n] {
+			if is_reorged {
+				// Matches again after a mismatch; the node's view of the chain is changing under us.
+				// Be safe and go back to the first mismatch.
+				break
+			}
+			rollback_to = n + 1
+		} else {
+			ReportProgress(opts.Progress, Progress{
+				Task:         "Checking for reorgs",
+				CurrentBlock: n,
+				Note: fmt.Sprintf("reorg detected at block %d: stored hash %s, canonical hash %s",
+					n, stored_hashes[n], canonical_hashes[n]),
+			})
+			is_reorged = true
+		}
+	}
+	return rollback_to, is_reorged, nil
+}
+
+// Check for a chain reorg near the head, and if there was one, undo the orphaned events so the
+// canonical ones can be fetched and played instead.
+func HandleReorgs(ctx context.Context, source LogSource, db DB, latest_block uint64, opts Options) error {
+	rollback_to, is_reorged, err := DetectReorg(ctx, source, db, latest_block, opts)
+	if err != nil || !is_reorged {
+		return err
+	}
+	return db.RollBackToBlock(rollback_to, latest_block, opts.Progress)
+}
@@ -0,0 +1,49 @@
+package scraper_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "go-azimuth/pkg/db"
+	. "go-azimuth/pkg/scraper"
+)
+
+// A LogSource that counts how many blocks' headers get asked for
+type header_counting_source struct {
+	FileLogSource
+	num_headers *atomic.Int64
+}
+
+func (s header_counting_source) BlockHeaders(ctx context.Context, block_nums []uint64) ([]BlockHeader, error) {
+	s.num_headers.Add(int64(len(block_nums)))
+	return s.FileLogSource.BlockHeaders(ctx, block_nums)
+}
+
+func TestBackfillBlockTimestamps(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	file_source, err := NewFileLogSource("testdata/logs.ndjson")
+	require.NoError(err)
+	db, err := DBCreate(":memory:")
+	require.NoError(err)
+	db.SetLatestContractBlockFetched(db.GetContractByName("Azimuth").ID, 13369000)
+	ctx := context.Background()
+	require.NoError(CatchUpAzimuthLogs(ctx, file_source, db, Options{}))
+	require.NoError(CatchUpNaiveLogs(ctx, file_source, db, Options{}))
+
+	// The file has no block times, so the first backfill asks for every block with events...
+	source := header_counting_source{FileLogSource: file_source, num_headers: new(atomic.Int64)}
+	require.NoError(BackfillBlockTimestamps(ctx, source, db, Options{}))
+	assert.NotZero(source.num_headers.Load())
+	assert.Equal([]uint64{}, db.GetEventBlocksWithoutTimestamps(0, 10))
+
+	// ...and the next one doesn't ask again
+	source.num_headers.Store(0)
+	require.NoError(BackfillBlockTimestamps(ctx, source, db, Options{}))
+	assert.Zero(source.num_headers.Load())
+}
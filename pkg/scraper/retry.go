@@ -0,0 +1,139 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	. "go-azimuth/pkg/db"
+)
+
+// How to retry calls that fail with a transient error (see `IsTransient`).  The delay starts at
+// InitialDelay and doubles after each retry, up to MaxDelay.
+type RetryPolicy struct {
+	MaxRetries   int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries:   8,
+	InitialDelay: 1 * time.Second,
+	MaxDelay:     1 * time.Minute,
+}
+
+// Run `f` until it succeeds, fails with a non-transient error, or runs out of retries.  Each retry
+// gets reported to `progress`.
+func (p RetryPolicy) retry(ctx context.Context, progress ProgressReporter, what string, f func() error) error {
+	delay := p.InitialDelay
+	for num_retries := 0; ; num_retries++ {
+		err := f()
+		if err == nil || !IsTransient(err) {
+			return err
+		}
+		if num_retries >= p.MaxRetries {
+			return fmt.Errorf("%s: %w (%d): %w", what, ErrRetriesExhausted, num_retries, err)
+		}
+
+		ReportProgress(progress, Progress{Task: what, Note: fmt.Sprintf("failed: %v.  Retrying in %s", err, delay)})
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, p.MaxDelay)
+	}
+}
+
+// A LogSource that retries failed calls to another one, according to a RetryPolicy
+type RetryingSource struct {
+	LogSource
+	Policy   RetryPolicy
+	Progress ProgressReporter // Gets told about each retry.  Can be nil.
+}
+
+func (s RetryingSource) ChainID(ctx context.Context) (ret uint64, err error) {
+	err = s.Policy.retry(ctx, s.Progress, "eth_chainId", func() (err error) {
+		ret, err = s.LogSource.ChainID(ctx)
+		return
+	})
+	return
+}
+
+func (s RetryingSource) BlockNumber(ctx context.Context) (ret uint64, err error) {
+	err = s.Policy.retry(ctx, s.Progress, "eth_blockNumber", func() (err error) {
+		ret, err = s.LogSource.BlockNumber(ctx)
+		return
+	})
+	return
+}
+
+func (s RetryingSource) TaggedBlockNumber(ctx context.Context, tag string) (ret uint64, err error) {
+	err = s.Policy.retry(ctx, s.Progress, "eth_getBlockByNumber", func() (err error) {
+		ret, err = s.LogSource.TaggedBlockNumber(ctx, tag)
+		return
+	})
+	return
+}
+
+func (s RetryingSource) FilterLogs(ctx context.Context, q ethereum.FilterQuery) (ret []types.Log, err error) {
+	err = s.Policy.retry(ctx, s.Progress, "eth_getLogs", func() (err error) {
+		ret, err = s.LogSource.FilterLogs(ctx, q)
+		return
+	})
+	return
+}
+
+func (s RetryingSource) TransactionsByHash(ctx context.Context, hashes []common.Hash) (ret []Transaction, err error) {
+	err = s.Policy.retry(ctx, s.Progress, "eth_getTransactionByHash", func() (err error) {
+		ret, err = s.LogSource.TransactionsByHash(ctx, hashes)
+		return
+	})
+	return
+}
+
+func (s RetryingSource) BlockHeaders(ctx context.Context, block_nums []uint64) (ret []BlockHeader, err error) {
+	err = s.Policy.retry(ctx, s.Progress, "eth_getBlockByNumber", func() (err error) {
+		ret, err = s.LogSource.BlockHeaders(ctx, block_nums)
+		return
+	})
+	return
+}
+
+func (s RetryingSource) Blocks(ctx context.Context, block_nums []uint64) (ret []Block, err error) {
+	err = s.Policy.retry(ctx, s.Progress, "eth_getBlockByNumber", func() (err error) {
+		ret, err = s.LogSource.Blocks(ctx, block_nums)
+		return
+	})
+	return
+}
+
+func (s RetryingSource) BlockReceipts(ctx context.Context, block_nums []uint64) (ret [][]Receipt, err error) {
+	err = s.Policy.retry(ctx, s.Progress, "eth_getBlockReceipts", func() (err error) {
+		ret, err = s.LogSource.BlockReceipts(ctx, block_nums)
+		return
+	})
+	return
+}
+
+func (s RetryingSource) TransactionReceipts(ctx context.Context, hashes []common.Hash) (ret []Receipt, err error) {
+	err = s.Policy.retry(ctx, s.Progress, "eth_getTransactionReceipt", func() (err error) {
+		ret, err = s.LogSource.TransactionReceipts(ctx, hashes)
+		return
+	})
+	return
+}
+
+func (s RetryingSource) TraceCalls(
+	ctx context.Context, from_block uint64, to_block uint64, to_address common.Address,
+) (ret []CallTrace, err error) {
+	err = s.Policy.retry(ctx, s.Progress, "trace_filter", func() (err error) {
+		ret, err = s.LogSource.TraceCalls(ctx, from_block, to_block, to_address)
+		return
+	})
+	return
+}
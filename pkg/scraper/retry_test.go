@@ -0,0 +1,132 @@
+package scraper_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "go-azimuth/pkg/db"
+	. "go-azimuth/pkg/scraper"
+)
+
+// A LogSource that fails in various ways before (maybe) letting calls through
+type flaky_source struct {
+	FileLogSource
+	num_failures   *int
+	max_range_size uint64
+}
+
+func (s flaky_source) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	if *s.num_failures > 0 {
+		*s.num_failures -= 1
+		return nil, fmt.Errorf("%w: connection reset", ErrTemporarilyUnavailable)
+	}
+	if s.max_range_size != 0 && q.ToBlock.Uint64()-q.FromBlock.Uint64()+1 > s.max_range_size {
+		return nil, &TooManyResultsError{Err: errors.New("query returned more than 10000 results")}
+	}
+	return s.FileLogSource.FilterLogs(ctx, q)
+}
+
+var fast_retries = RetryPolicy{MaxRetries: 3, InitialDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+func TestRetryTransientErrors(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	file_source, err := NewFileLogSource("testdata/logs.ndjson")
+	require.NoError(err)
+	num_failures := 3
+	notes := []string{}
+	source := RetryingSource{LogSource: flaky_source{file_source, &num_failures, 0}, Policy: fast_retries,
+		Progress: ProgressFunc(func(p Progress) { notes = append(notes, p.Task+": "+p.Note) })}
+
+	logs, err := source.FilterLogs(context.Background(), ethereum.FilterQuery{})
+	require.NoError(err)
+	assert.Len(logs, 3)
+	assert.Equal(0, num_failures)
+	// Each retry gets reported, instead of printed
+	require.Len(notes, 3)
+	assert.Contains(notes[0], "eth_getLogs: failed:")
+
+	// Too many failures in a row
+	num_failures = 4
+	_, err = source.FilterLogs(context.Background(), ethereum.FilterQuery{})
+	assert.ErrorIs(err, ErrRetriesExhausted)
+	assert.ErrorIs(err, ErrTemporarilyUnavailable)
+}
+
+func TestFailedCatchUpIsResumable(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	file_source, err := NewFileLogSource("testdata/logs.ndjson")
+	require.NoError(err)
+	db, err := DBCreate(":memory:")
+	require.NoError(err)
+	azimuth := db.GetContractByName("Azimuth")
+	db.SetLatestContractBlockFetched(azimuth.ID, 13369000)
+
+	// Keeps failing; nothing gets saved
+	num_failures := 100
+	source := RetryingSource{LogSource: flaky_source{file_source, &num_failures, 500}, Policy: fast_retries}
+	err = CatchUpAzimuthLogs(context.Background(), source, db, Options{})
+	assert.ErrorIs(err, ErrRetriesExhausted)
+	assert.Equal(uint64(13369000), db.GetContractByName("Azimuth").LatestBlockNumFetched)
+
+	// Run it again once the node is back; ranges that are too big get split up
+	num_failures = 0
+	require.NoError(CatchUpAzimuthLogs(context.Background(), source, db, Options{}))
+	assert.Equal(uint64(13369856), db.GetContractByName("Azimuth").LatestBlockNumFetched)
+	var num_events int
+	require.NoError(db.DB.Get(&num_events, `select count(*) from ethereum_events`))
+	assert.Equal(2, num_events)
+}
+
+// A LogSource where one range of blocks can't be fetched
+type gappy_source struct {
+	FileLogSource
+	bad_block uint64
+}
+
+func (s gappy_source) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	if q.FromBlock.Uint64() <= s.bad_block && s.bad_block <= q.ToBlock.Uint64() {
+		return nil, errors.New("internal error")
+	}
+	return s.FileLogSource.FilterLogs(ctx, q)
+}
+
+func TestParallelCatchUpNeverSkipsAGap(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	file_source, err := NewFileLogSource("testdata/logs.ndjson")
+	require.NoError(err)
+	db, err := DBCreate(":memory:")
+	require.NoError(err)
+	azimuth := db.GetContractByName("Azimuth")
+	opts := Options{Workers: 4}
+
+	// Whichever range has this block fails; the ones after it get fetched, but not saved.  (The ranges
+	// get bigger as they go, so it isn't known in advance which one that is.)
+	bad_block := azimuth.StartBlockNum + 3*LOG_RANGE_SIZE
+	source := gappy_source{file_source, bad_block}
+	err = CatchUpAzimuthLogs(context.Background(), source, db, opts)
+	assert.Error(err)
+	latest_fetched := db.GetContractByName("Azimuth").LatestBlockNumFetched
+	assert.Less(latest_fetched, bad_block)
+	assert.GreaterOrEqual(latest_fetched, azimuth.StartBlockNum+LOG_RANGE_SIZE-1) // The first range, at least
+
+	// Picks up from the gap
+	require.NoError(CatchUpAzimuthLogs(context.Background(), file_source, db, opts))
+	assert.Equal(uint64(13369856), db.GetContractByName("Azimuth").LatestBlockNumFetched)
+	var num_events int
+	require.NoError(db.DB.Get(&num_events, `select count(*) from ethereum_events`))
+	assert.Equal(2, num_events)
+}
@@ -0,0 +1,383 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Where the scraper gets its Ethereum data from.  Usually a node (see `EthClientSource`), but it
+// can be anything that can answer these.
+type LogSource interface {
+	// Get the ID of the chain the node is on
+	ChainID(ctx context.Context) (uint64, error)
+
+	// Get the latest block number
+	BlockNumber(ctx context.Context) (uint64, error)
+
+	// Get the number of the block with a tag, like "finalized" or "safe"
+	TaggedBlockNumber(ctx context.Context, tag string) (uint64, error)
+
+	// Get the logs matching a query
+	FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error)
+
+	// Look up a bunch of transactions.  Results are in the same order as `hashes`.
+	TransactionsByHash(ctx context.Context, hashes []common.Hash) ([]Transaction, error)
+
+	// Look up a bunch of block headers.  Results are in the same order as `block_nums`; blocks that
+	// don't exist get an empty header (with a zero hash).
+	BlockHeaders(ctx context.Context, block_nums []uint64) ([]BlockHeader, error)
+
+	// Look up a bunch of blocks, with all their transactions.  Results are in the same order as
+	// `block_nums`.
+	Blocks(ctx context.Context, block_nums []uint64) ([]Block, error)
+
+	// Get the receipts of every transaction in a bunch of blocks.  Results are in the same order as
+	// `block_nums`.
+	BlockReceipts(ctx context.Context, block_nums []uint64) ([][]Receipt, error)
+
+	// Look up the receipts of a bunch of transactions.  Results are in the same order as `hashes`.
+	TransactionReceipts(ctx context.Context, hashes []common.Hash) ([]Receipt, error)
+
+	// Get all the calls to a contract in a range of blocks, including ones from other contracts
+	// (i.e., internal transactions).  Not every node supports this; ones that don't should return
+	// ErrNotSupported.
+	TraceCalls(ctx context.Context, from_block uint64, to_block uint64, to_address common.Address) ([]CallTrace, error)
+}
+
+// Just the parts of a transaction we care about, as returned by `eth_getTransactionByHash`.
+//
+// This isn't go-ethereum's `types.Transaction`, because that one has to be able to decode (and
+// re-hash) every transaction type, and fails on types newer than the library.
+type Transaction struct {
+	Hash  common.Hash     `json:"hash"`
+	To    *common.Address `json:"to"` // nil for contract creations
+	Input hexutil.Bytes   `json:"input"`
+}
+
+// A block header, as returned by `eth_getBlockByNumber`.
+//
+// The hash is the node's; `ComputeHash` works it out from the other fields, to check it.
+type BlockHeader struct {
+	Number       hexutil.Uint64 `json:"number"`
+	Hash         common.Hash    `json:"hash"`
+	Timestamp    hexutil.Uint64 `json:"timestamp"`
+	ReceiptsRoot common.Hash    `json:"receiptsRoot"`
+
+	// The rest of the fields that go into the hash
+	ParentHash       common.Hash      `json:"parentHash"`
+	UncleHash        common.Hash      `json:"sha3Uncles"`
+	Miner            common.Address   `json:"miner"`
+	StateRoot        common.Hash      `json:"stateRoot"`
+	TransactionsRoot common.Hash      `json:"transactionsRoot"`
+	LogsBloom        types.Bloom      `json:"logsBloom"`
+	Difficulty       *hexutil.Big     `json:"difficulty"`
+	GasLimit         hexutil.Uint64   `json:"gasLimit"`
+	GasUsed          hexutil.Uint64   `json:"gasUsed"`
+	ExtraData        hexutil.Bytes    `json:"extraData"`
+	MixHash          common.Hash      `json:"mixHash"`
+	Nonce            types.BlockNonce `json:"nonce"`
+	// Added by hard forks; nil for blocks from before them
+	BaseFee               *hexutil.Big    `json:"baseFeePerGas,omitempty"`         // London
+	WithdrawalsRoot       *common.Hash    `json:"withdrawalsRoot,omitempty"`       // Shanghai
+	BlobGasUsed           *hexutil.Uint64 `json:"blobGasUsed,omitempty"`           // Cancun
+	ExcessBlobGas         *hexutil.Uint64 `json:"excessBlobGas,omitempty"`         // Cancun
+	ParentBeaconBlockRoot *common.Hash    `json:"parentBeaconBlockRoot,omitempty"` // Cancun
+	RequestsHash          *common.Hash    `json:"requestsHash,omitempty"`          // Prague
+}
+
+// Work out the block's hash from its fields (keccak of the RLP-encoded header).
+//
+// WTF: go-ethereum's `types.Header` can do this, but only for the fields it knows about, and it has
+// Prague's `requestsHash` under the wrong JSON name.  This is the same thing, for the forks up to
+// Prague.  A block from a later fork that adds more fields will hash to the wrong thing, so it
+// won't match the node's hash, rather than passing.
+func (h BlockHeader) ComputeHash() common.Hash {
+	difficulty := new(big.Int)
+	if h.Difficulty != nil {
+		difficulty = h.Difficulty.ToInt()
+	}
+	fields := []interface{}{
+		h.ParentHash, h.UncleHash, h.Miner, h.StateRoot, h.TransactionsRoot, h.ReceiptsRoot, h.LogsBloom,
+		difficulty, uint64(h.Number), uint64(h.GasLimit), uint64(h.GasUsed), uint64(h.Timestamp),
+		[]byte(h.ExtraData), h.MixHash, h.Nonce,
+	}
+	// Each fork's fields go on the end, if the block has them
+	if h.BaseFee != nil {
+		fields = append(fields, h.BaseFee.ToInt())
+	}
+	if h.WithdrawalsRoot != nil {
+		fields = append(fields, *h.WithdrawalsRoot)
+	}
+	if h.BlobGasUsed != nil {
+		fields = append(fields, uint64(*h.BlobGasUsed))
+	}
+	if h.ExcessBlobGas != nil {
+		fields = append(fields, uint64(*h.ExcessBlobGas))
+	}
+	if h.ParentBeaconBlockRoot != nil {
+		fields = append(fields, *h.ParentBeaconBlockRoot)
+	}
+	if h.RequestsHash != nil {
+		fields = append(fields, *h.RequestsHash)
+	}
+	encoded, err := rlp.EncodeToBytes(fields)
+	if err != nil {
+		panic(err) // Can't fail; it's all plain bytes and ints
+	}
+	return crypto.Keccak256Hash(encoded)
+}
+
+// A block with its transactions, as returned by `eth_getBlockByNumber` with full transactions
+type Block struct {
+	BlockHeader
+	Transactions []Transaction `json:"transactions"`
+}
+
+// Just the parts of a transaction receipt that go into the receipts trie (plus the transaction hash,
+// and who sent it), as returned by `eth_getBlockReceipts` and `eth_getTransactionReceipt`.  Like
+// Transaction, it isn't go-ethereum's `types.Receipt`, so it works for transaction types newer than
+// the library.
+type Receipt struct {
+	Type              hexutil.Uint64  `json:"type"`
+	Root              hexutil.Bytes   `json:"root"`   // Only before Byzantium
+	Status            *hexutil.Uint64 `json:"status"` // Only after Byzantium
+	CumulativeGasUsed hexutil.Uint64  `json:"cumulativeGasUsed"`
+	LogsBloom         types.Bloom     `json:"logsBloom"`
+	Logs              []types.Log     `json:"logs"`
+	TxHash            common.Hash     `json:"transactionHash"`
+	TransactionIndex  hexutil.Uint64  `json:"transactionIndex"`
+	BlockNumber       hexutil.Uint64  `json:"blockNumber"`
+	From              common.Address  `json:"from"`
+	GasUsed           hexutil.Uint64  `json:"gasUsed"`
+}
+
+// A call to a contract, as returned by `trace_filter`
+type CallTrace struct {
+	Action struct {
+		From  common.Address `json:"from"`
+		To    common.Address `json:"to"`
+		Input hexutil.Bytes  `json:"input"`
+	} `json:"action"`
+	BlockNumber  uint64      `json:"blockNumber"`
+	BlockHash    common.Hash `json:"blockHash"`
+	TxHash       common.Hash `json:"transactionHash"`
+	TraceAddress []uint64    `json:"traceAddress"` // Where it is in the tree of calls the transaction made
+	Error        string      `json:"error"`        // Empty unless the call failed
+}
+
+// The trace address as text, e.g., "0,2" for the third call made by the first call the transaction
+// made.  Empty for the transaction's own call.
+func (t CallTrace) FormatTraceAddress() string {
+	ret := make([]string, len(t.TraceAddress))
+	for i, n := range t.TraceAddress {
+		ret[i] = strconv.FormatUint(n, 10)
+	}
+	return strings.Join(ret, ",")
+}
+
+// Get the 4-byte selector of a function, from its signature, e.g., "transfer(address,uint256)"
+func get_selector(signature string) []byte {
+	return crypto.Keccak256([]byte(signature))[:4]
+}
+
+// How many calls to put in one RPC batch.  Bigger batches are faster, but providers cap them.
+const MAX_BATCH_SIZE = 20
+
+// A LogSource backed by an Ethereum node
+type EthClientSource struct {
+	*ethclient.Client
+}
+
+func (s EthClientSource) ChainID(ctx context.Context) (uint64, error) {
+	ret, err := s.Client.ChainID(ctx)
+	if err != nil {
+		return 0, classify_error(err)
+	}
+	return ret.Uint64(), nil
+}
+
+func (s EthClientSource) BlockNumber(ctx context.Context) (uint64, error) {
+	ret, err := s.Client.BlockNumber(ctx)
+	return ret, classify_error(err)
+}
+
+func (s EthClientSource) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	ret, err := s.Client.FilterLogs(ctx, q)
+	return ret, classify_error(err)
+}
+
+func (s EthClientSource) TaggedBlockNumber(ctx context.Context, tag string) (uint64, error) {
+	var header *BlockHeader
+	if err := s.Client.Client().CallContext(ctx, &header, "eth_getBlockByNumber", tag, false); err != nil {
+		return 0, classify_error(err)
+	}
+	if header == nil {
+		return 0, fmt.Errorf("no %q block", tag)
+	}
+	return uint64(header.Number), nil
+}
+
+// Execute RPC batches of up to MAX_BATCH_SIZE calls.  If any call in the batch fails, the whole
+// thing fails.
+func (s EthClientSource) batch_call(ctx context.Context, batch []rpc.BatchElem) error {
+	for i := 0; i < len(batch); i += MAX_BATCH_SIZE {
+		sub_batch := batch[i:min(i+MAX_BATCH_SIZE, len(batch))]
+		if err := s.Client.Client().BatchCallContext(ctx, sub_batch); err != nil {
+			return fmt.Errorf("batch call failed: %w", classify_error(err))
+		}
+		for _, elem := range sub_batch {
+			if elem.Error != nil {
+				return fmt.Errorf("%s%v: %w", elem.Method, elem.Args, classify_error(elem.Error))
+			}
+		}
+	}
+	return nil
+}
+
+func (s EthClientSource) TransactionsByHash(ctx context.Context, hashes []common.Hash) ([]Transaction, error) {
+	batch := []rpc.BatchElem{}
+	for _, h := range hashes {
+		batch = append(batch, rpc.BatchElem{
+			Method: "eth_getTransactionByHash",
+			Args:   []interface{}{h},
+			Result: new(*Transaction), // Stays nil if the transaction isn't found
+		})
+	}
+	if err := s.batch_call(ctx, batch); err != nil {
+		return nil, err
+	}
+
+	ret := []Transaction{}
+	for i, elem := range batch {
+		tx := *elem.Result.(**Transaction)
+		if tx == nil {
+			return nil, fmt.Errorf("transaction not found: %s", hashes[i])
+		}
+		ret = append(ret, *tx)
+	}
+	return ret, nil
+}
+
+func (s EthClientSource) BlockHeaders(ctx context.Context, block_nums []uint64) ([]BlockHeader, error) {
+	batch := []rpc.BatchElem{}
+	for _, n := range block_nums {
+		batch = append(batch, rpc.BatchElem{
+			Method: "eth_getBlockByNumber",
+			Args:   []interface{}{hexutil.EncodeUint64(n), false},
+			Result: new(*BlockHeader), // Stays nil if the block doesn't exist
+		})
+	}
+	if err := s.batch_call(ctx, batch); err != nil {
+		return nil, err
+	}
+
+	ret := []BlockHeader{}
+	for _, elem := range batch {
+		header := *elem.Result.(**BlockHeader)
+		if header == nil {
+			ret = append(ret, BlockHeader{})
+		} else {
+			ret = append(ret, *header)
+		}
+	}
+	return ret, nil
+}
+
+func (s EthClientSource) Blocks(ctx context.Context, block_nums []uint64) ([]Block, error) {
+	batch := []rpc.BatchElem{}
+	for _, n := range block_nums {
+		batch = append(batch, rpc.BatchElem{
+			Method: "eth_getBlockByNumber",
+			Args:   []interface{}{hexutil.EncodeUint64(n), true},
+			Result: new(*Block), // Stays nil if the block doesn't exist
+		})
+	}
+	if err := s.batch_call(ctx, batch); err != nil {
+		return nil, err
+	}
+
+	ret := []Block{}
+	for i, elem := range batch {
+		block := *elem.Result.(**Block)
+		if block == nil {
+			return nil, fmt.Errorf("block not found: %d", block_nums[i])
+		}
+		ret = append(ret, *block)
+	}
+	return ret, nil
+}
+
+func (s EthClientSource) BlockReceipts(ctx context.Context, block_nums []uint64) ([][]Receipt, error) {
+	batch := []rpc.BatchElem{}
+	for _, n := range block_nums {
+		batch = append(batch, rpc.BatchElem{
+			Method: "eth_getBlockReceipts",
+			Args:   []interface{}{hexutil.EncodeUint64(n)},
+			Result: new([]Receipt), // Stays nil if the block doesn't exist
+		})
+	}
+	if err := s.batch_call(ctx, batch); err != nil {
+		return nil, err
+	}
+
+	ret := [][]Receipt{}
+	for i, elem := range batch {
+		receipts := *elem.Result.(*[]Receipt)
+		if receipts == nil {
+			return nil, fmt.Errorf("block not found: %d", block_nums[i])
+		}
+		ret = append(ret, receipts)
+	}
+	return ret, nil
+}
+
+func (s EthClientSource) TransactionReceipts(ctx context.Context, hashes []common.Hash) ([]Receipt, error) {
+	batch := []rpc.BatchElem{}
+	for _, h := range hashes {
+		batch = append(batch, rpc.BatchElem{
+			Method: "eth_getTransactionReceipt",
+			Args:   []interface{}{h},
+			Result: new(*Receipt), // Stays nil if the transaction isn't found
+		})
+	}
+	if err := s.batch_call(ctx, batch); err != nil {
+		return nil, err
+	}
+
+	ret := []Receipt{}
+	for i, elem := range batch {
+		receipt := *elem.Result.(**Receipt)
+		if receipt == nil {
+			return nil, fmt.Errorf("transaction receipt not found: %s", hashes[i])
+		}
+		ret = append(ret, *receipt)
+	}
+	return ret, nil
+}
+
+func (s EthClientSource) TraceCalls(
+	ctx context.Context, from_block uint64, to_block uint64, to_address common.Address,
+) ([]CallTrace, error) {
+	var ret []CallTrace
+	err := s.Client.Client().CallContext(ctx, &ret, "trace_filter", map[string]interface{}{
+		"fromBlock": hexutil.EncodeUint64(from_block),
+		"toBlock":   hexutil.EncodeUint64(to_block),
+		"toAddress": []common.Address{to_address},
+	})
+	if err != nil {
+		return nil, classify_error(err)
+	}
+	return ret, nil
+}
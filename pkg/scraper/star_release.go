@@ -0,0 +1,198 @@
+package scraper
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	. "go-azimuth/pkg/db"
+)
+
+// Function selectors for the star release contracts.  (LinearStarRelease doesn't emit events.)
+var (
+	LINEAR_REGISTER          = get_selector("register(address,uint256,uint16,uint16,uint256)")
+	LINEAR_DEPOSIT           = get_selector("deposit(address,uint16)")
+	LINEAR_START_RELEASING   = get_selector("startReleasing()")
+	CONDITIONAL_REGISTER     = get_selector("register(address,uint16[],uint16,uint256)")
+	CONDITIONAL_DEPOSIT      = get_selector("deposit(address,uint8,uint16)")
+	CONDITIONAL_FORFEIT      = get_selector("forfeit(uint8)")
+	STAR_RELEASE_TRANSFER    = get_selector("transferBatch(address)") // The rest are the same in both
+	STAR_RELEASE_WITHDRAW    = get_selector("withdraw()")
+	STAR_RELEASE_WITHDRAW_TO = get_selector("withdraw(address)")
+	STAR_RELEASE_OVERDUE     = get_selector("withdrawOverdue(address,address)") // By the contract owner
+)
+
+// Convert a call to a star release contract into Our Type.  Returns false if it isn't a call we
+// track, or it failed.
+func ParseStarReleaseCall(t CallTrace) (StarReleaseCall, bool) {
+	input := []byte(t.Action.Input)
+	if t.Error != "" || len(input) < 4 {
+		return StarReleaseCall{}, false
+	}
+	// The arguments are 32-byte words after the selector
+	word := func(i int) []byte {
+		return input[4+32*i : 4+32*(i+1)]
+	}
+	uint_word := func(i int) uint64 {
+		return binary.BigEndian.Uint64(word(i)[24:])
+	}
+	has_words := func(n int) bool {
+		return len(input) >= 4+32*n
+	}
+
+	ret := StarReleaseCall{
+		BlockNumber:     t.BlockNumber,
+		TxHash:          t.TxHash[:],
+		TraceAddress:    t.FormatTraceAddress(),
+		ContractAddress: t.Action.To,
+		Caller:          t.Action.From,
+	}
+	selector := input[:4]
+	switch {
+	case bytes.Equal(selector, LINEAR_REGISTER) && has_words(5):
+		ret.Function = "register"
+		ret.Participant = common.BytesToAddress(word(0))
+		ret.Windup = uint_word(1)
+		ret.Amount = uint16(uint_word(2))
+		ret.Rate = uint16(uint_word(3))
+		ret.RateUnit = uint_word(4)
+	case bytes.Equal(selector, LINEAR_DEPOSIT) && has_words(2):
+		ret.Function = "deposit"
+		ret.Participant = common.BytesToAddress(word(0))
+		ret.Star = AzimuthNumber(uint_word(1))
+	case bytes.Equal(selector, LINEAR_START_RELEASING):
+		ret.Function = "start_releasing"
+	case bytes.Equal(selector, CONDITIONAL_REGISTER) && has_words(4):
+		ret.Function = "register"
+		ret.Participant = common.BytesToAddress(word(0))
+		ret.Rate = uint16(uint_word(2))
+		ret.RateUnit = uint_word(3)
+		// Word 1 is where the batch sizes array starts (in bytes), which is its length and then the
+		// sizes
+		start := uint_word(1) / 32
+		if start < 4 || !has_words(int(start)+1) {
+			return StarReleaseCall{}, false
+		}
+		num_batches := uint_word(int(start))
+		if num_batches > 255 || !has_words(int(start)+1+int(num_batches)) {
+			return StarReleaseCall{}, false
+		}
+		sizes := make([]string, num_batches)
+		for i := range sizes {
+			sizes[i] = strconv.FormatUint(uint_word(int(start)+1+i), 10)
+		}
+		ret.BatchSizes = strings.Join(sizes, ",")
+	case bytes.Equal(selector, CONDITIONAL_DEPOSIT) && has_words(3):
+		ret.Function = "deposit"
+		ret.Participant = common.BytesToAddress(word(0))
+		ret.Batch = uint8(uint_word(1))
+		ret.Star = AzimuthNumber(uint_word(2))
+	case bytes.Equal(selector, STAR_RELEASE_TRANSFER) && has_words(1):
+		ret.Function = "transfer_batch"
+		ret.Participant = common.BytesToAddress(word(0))
+	case bytes.Equal(selector, STAR_RELEASE_WITHDRAW), bytes.Equal(selector, STAR_RELEASE_WITHDRAW_TO):
+		// From the caller's own stars
+		ret.Function = "withdraw"
+		ret.Participant = t.Action.From
+	case bytes.Equal(selector, STAR_RELEASE_OVERDUE) && has_words(2):
+		ret.Function = "withdraw"
+		ret.Participant = common.BytesToAddress(word(0))
+	case bytes.Equal(selector, CONDITIONAL_FORFEIT) && has_words(1):
+		ret.Function = "forfeit"
+		ret.Batch = uint8(uint_word(0))
+	default:
+		// Approvals, etc.
+		return StarReleaseCall{}, false
+	}
+	return ret, true
+}
+
+// Convert a ConditionCompleted log into Our Type
+func ParseConditionCompleted(l types.Log) (StarReleaseCondition, bool) {
+	if len(l.Topics) != 2 || l.Topics[0] != CONDITION_COMPLETED || len(l.Data) != 32 {
+		return StarReleaseCondition{}, false
+	}
+	return StarReleaseCondition{
+		BlockNumber:    l.BlockNumber,
+		ConditionIndex: uint8(new(big.Int).SetBytes(l.Topics[1][:]).Uint64()),
+		CompletedAt:    new(big.Int).SetBytes(l.Data).Uint64(),
+	}, true
+}
+
+// Fetches all the calls to the star release contracts so far.  Needs a node with `trace_filter`,
+// like `CatchUpPollVotesUntil`.
+func CatchUpStarReleaseCalls(ctx context.Context, source LogSource, db DB, opts Options) error {
+	latest_block, err := source.BlockNumber(ctx)
+	if err != nil {
+		return fmt.Errorf("getting latest block number: %w", err)
+	}
+	return CatchUpStarReleaseCallsUntil(ctx, source, db, latest_block, opts)
+}
+
+// Fetches calls to the star release contracts from where the previous fetch left off, up to and
+// including `latest_block`.  For ConditionalStarRelease, also fetches when its conditions completed.
+//
+// Also saves the timestamp of the block where LinearStarRelease started releasing, since the
+// release schedule counts from then.
+func CatchUpStarReleaseCallsUntil(ctx context.Context, source LogSource, db DB, latest_block uint64, opts Options) error {
+	for _, name := range []string{"LinearStarRelease", "ConditionalStarRelease"} {
+		contract, is_ok := db.FindContractByName(name)
+		if !is_ok {
+			// Not on this network
+			continue
+		}
+		from_block := max(contract.StartBlockNum, contract.LatestBlockNumFetched+1)
+		next_block := from_block // Start of the range being handled
+		err := fetch_traces_in_ranges(ctx, source, db, contract, from_block, latest_block, opts,
+			func(traces []CallTrace, to_block uint64) error {
+				conditions := []StarReleaseCondition{}
+				if contract.Name == "ConditionalStarRelease" {
+					logs, err := source.FilterLogs(ctx, ethereum.FilterQuery{
+						FromBlock: new(big.Int).SetUint64(next_block),
+						ToBlock:   new(big.Int).SetUint64(to_block),
+						Addresses: []common.Address{contract.Address},
+						Topics:    [][]common.Hash{{CONDITION_COMPLETED}},
+					})
+					if err != nil {
+						return fmt.Errorf("getting completed conditions: %w", err)
+					}
+					for _, l := range logs {
+						if c, is_ok := ParseConditionCompleted(l); is_ok {
+							conditions = append(conditions, c)
+						}
+					}
+				}
+				next_block = to_block + 1
+
+				calls := []StarReleaseCall{}
+				for _, t := range traces {
+					call, is_ok := ParseStarReleaseCall(t)
+					if !is_ok {
+						continue
+					}
+					if call.Function == "start_releasing" {
+						headers, err := source.BlockHeaders(ctx, []uint64{call.BlockNumber})
+						if err != nil {
+							return fmt.Errorf("getting release start time: %w", err)
+						}
+						db.SaveBlock(call.BlockNumber, headers[0].Hash, uint64(headers[0].Timestamp))
+					}
+					calls = append(calls, call)
+				}
+				db.SaveStarReleaseCalls(contract.ID, calls, conditions, to_block)
+				return nil
+			})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
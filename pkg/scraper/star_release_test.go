@@ -0,0 +1,91 @@
+package scraper_test
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "go-azimuth/pkg/db"
+	. "go-azimuth/pkg/scraper"
+)
+
+func TestParseStarReleaseCall(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	linear := common.HexToAddress("86cd9cd0992f04231751e3761de45cecea5d1801")
+	participant := common.HexToAddress("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	caller := common.HexToAddress("dddddddddddddddddddddddddddddddddddddddd")
+	call := func(selector []byte, words ...common.Hash) CallTrace {
+		var ret CallTrace
+		ret.BlockNumber = 123
+		ret.Action.From = caller
+		ret.Action.To = linear
+		ret.Action.Input = append([]byte{}, selector...)
+		for _, w := range words {
+			ret.Action.Input = append(ret.Action.Input, w.Bytes()...)
+		}
+		return ret
+	}
+
+	c, is_ok := ParseStarReleaseCall(call(LINEAR_DEPOSIT, common.BytesToHash(participant[:]), common.Hash{31: 0x01, 30: 0x02}))
+	require.True(is_ok)
+	assert.Equal("deposit", c.Function)
+	assert.Equal(participant, c.Participant)
+	assert.Equal(AzimuthNumber(0x0201), c.Star)
+	assert.Equal(caller, c.Caller)
+	assert.Equal(linear, c.ContractAddress)
+
+	c, is_ok = ParseStarReleaseCall(call(LINEAR_REGISTER, common.BytesToHash(participant[:]), common.Hash{31: 10},
+		common.Hash{31: 3}, common.Hash{31: 1}, common.Hash{31: 20}))
+	require.True(is_ok)
+	assert.Equal("register", c.Function)
+	assert.Equal(uint64(10), c.Windup)
+	assert.Equal(uint16(3), c.Amount)
+	assert.Equal(uint16(1), c.Rate)
+	assert.Equal(uint64(20), c.RateUnit)
+
+	c, is_ok = ParseStarReleaseCall(call(STAR_RELEASE_WITHDRAW_TO, common.BytesToHash(participant[:])))
+	require.True(is_ok)
+	assert.Equal("withdraw", c.Function)
+	assert.Equal(caller, c.Participant) // Whose stars they are, not where they're going
+	c, is_ok = ParseStarReleaseCall(call(STAR_RELEASE_OVERDUE, common.BytesToHash(participant[:]),
+		common.BytesToHash(caller[:])))
+	require.True(is_ok)
+	assert.Equal("withdraw", c.Function)
+	assert.Equal(participant, c.Participant)
+
+	// ConditionalStarRelease's batch sizes are a dynamic array, after the other arguments
+	c, is_ok = ParseStarReleaseCall(call(CONDITIONAL_REGISTER, common.BytesToHash(participant[:]), common.Hash{31: 4 * 32},
+		common.Hash{31: 1}, common.Hash{31: 20}, common.Hash{31: 3}, common.Hash{31: 2}, common.Hash{31: 2},
+		common.Hash{31: 5}))
+	require.True(is_ok)
+	assert.Equal("register", c.Function)
+	assert.Equal("2,2,5", c.BatchSizes)
+	assert.Equal(uint16(1), c.Rate)
+	assert.Equal(uint64(20), c.RateUnit)
+	c, is_ok = ParseStarReleaseCall(call(CONDITIONAL_FORFEIT, common.Hash{31: 2}))
+	require.True(is_ok)
+	assert.Equal("forfeit", c.Function)
+	assert.Equal(uint8(2), c.Batch)
+
+	// Truncated, or not something we track
+	_, is_ok = ParseStarReleaseCall(call(LINEAR_DEPOSIT, common.BytesToHash(participant[:])))
+	assert.False(is_ok)
+	_, is_ok = ParseStarReleaseCall(call([]byte{1, 2, 3, 4}))
+	assert.False(is_ok)
+	_, is_ok = ParseStarReleaseCall(call(CONDITIONAL_REGISTER, common.BytesToHash(participant[:]), common.Hash{31: 4 * 32},
+		common.Hash{31: 1}, common.Hash{31: 20}, common.Hash{31: 3}, common.Hash{31: 2}))
+	assert.False(is_ok)
+
+	condition, is_ok := ParseConditionCompleted(types.Log{
+		BlockNumber: 123,
+		Topics:      []common.Hash{CONDITION_COMPLETED, {31: 1}},
+		Data:        common.Hash{30: 0x01, 31: 0x02}.Bytes(),
+	})
+	require.True(is_ok)
+	assert.Equal(StarReleaseCondition{BlockNumber: 123, ConditionIndex: 1, CompletedAt: 0x0102}, condition)
+}
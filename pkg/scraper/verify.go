@@ -0,0 +1,319 @@
+package scraper
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	. "go-azimuth/pkg/db"
+)
+
+// How many blocks to verify at once
+const VERIFY_BATCH_SIZE = 100
+
+// A saved event that didn't match what's in its block
+type VerificationFailure struct {
+	Event  EthereumEventLog
+	Reason string
+}
+
+// The fields of a receipt that go into the receipts trie, in the order they get RLP-encoded
+type rlp_receipt struct {
+	PostStateOrStatus []byte
+	CumulativeGasUsed uint64
+	Bloom             types.Bloom
+	Logs              []rlp_log
+}
+type rlp_log struct {
+	Address common.Address
+	Topics  []common.Hash
+	Data    []byte
+}
+
+// Encode a receipt the way it's stored in the receipts trie: RLP, with the transaction type in
+// front for typed (EIP-2718) transactions
+func (r Receipt) consensus_encoding() []byte {
+	data := rlp_receipt{CumulativeGasUsed: uint64(r.CumulativeGasUsed), Bloom: r.LogsBloom, Logs: []rlp_log{}}
+	if r.Status == nil {
+		data.PostStateOrStatus = r.Root
+	} else if *r.Status == 1 {
+		data.PostStateOrStatus = []byte{1}
+	} else {
+		data.PostStateOrStatus = []byte{}
+	}
+	for _, l := range r.Logs {
+		data.Logs = append(data.Logs, rlp_log{Address: l.Address, Topics: l.Topics, Data: l.Data})
+	}
+	encoded, err := rlp.EncodeToBytes(data)
+	if err != nil {
+		panic(err) // Can't fail; it's all plain bytes and ints
+	}
+	if r.Type == 0 {
+		return encoded
+	}
+	return append([]byte{byte(r.Type)}, encoded...)
+}
+
+// Rebuild the receipts trie of a block and get its root hash, which should match the block
+// header's `receiptsRoot`
+func ReceiptsRoot(receipts []Receipt) common.Hash {
+	sorted := slices.Clone(receipts)
+	slices.SortFunc(sorted, func(a, b Receipt) int {
+		return cmp.Compare(a.TransactionIndex, b.TransactionIndex)
+	})
+	entries := []trie_entry{}
+	for i, r := range sorted {
+		key, err := rlp.EncodeToBytes(uint64(i))
+		if err != nil {
+			panic(err)
+		}
+		entries = append(entries, trie_entry{key: to_nibbles(key), value: r.consensus_encoding()})
+	}
+	return trie_root(entries)
+}
+
+// A key (as nibbles) and value to put in a Merkle Patricia trie
+type trie_entry struct {
+	key   []byte
+	value []byte
+}
+
+// Get the root hash of a Merkle Patricia trie with these entries.
+//
+// WTF: go-ethereum has a trie package that does this, but importing it pulls in a whole database
+// engine (pebble, leveldb...) as dependencies.  A receipts trie is small and never changes, so
+// it's simpler to just build it all at once.
+func trie_root(entries []trie_entry) common.Hash {
+	if len(entries) == 0 {
+		return types.EmptyReceiptsHash
+	}
+	slices.SortFunc(entries, func(a, b trie_entry) int {
+		return bytes.Compare(a.key, b.key)
+	})
+	return crypto.Keccak256Hash(encode_trie_node(entries, 0))
+}
+
+// RLP-encode the trie node for a bunch of entries (sorted, and all sharing the first `depth`
+// nibbles of their keys)
+func encode_trie_node(entries []trie_entry, depth int) []byte {
+	var node []interface{}
+	if len(entries) == 1 {
+		// Leaf
+		node = []interface{}{hex_prefix(entries[0].key[depth:], true), entries[0].value}
+	} else if prefix_len := common_prefix_len(entries, depth); prefix_len > 0 {
+		// Extension
+		node = []interface{}{
+			hex_prefix(entries[0].key[depth:depth+prefix_len], false),
+			trie_node_ref(encode_trie_node(entries, depth+prefix_len)),
+		}
+	} else {
+		// Branch: one child per next nibble, plus the value of a key that ends here (if any)
+		node = make([]interface{}, 17)
+		for i := range node {
+			node[i] = []byte{}
+		}
+		for len(entries) > 0 {
+			if len(entries[0].key) == depth {
+				node[16] = entries[0].value
+				entries = entries[1:]
+				continue
+			}
+			nibble := entries[0].key[depth]
+			end := 1
+			for end < len(entries) && entries[end].key[depth] == nibble {
+				end++
+			}
+			node[nibble] = trie_node_ref(encode_trie_node(entries[:end], depth+1))
+			entries = entries[end:]
+		}
+	}
+	ret, err := rlp.EncodeToBytes(node)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// A node refers to its children by hash, unless they're small enough to just put inline
+func trie_node_ref(encoded_node []byte) interface{} {
+	if len(encoded_node) < 32 {
+		return rlp.RawValue(encoded_node)
+	}
+	return crypto.Keccak256(encoded_node)
+}
+
+// How many more nibbles (after the first `depth`) all the entries' keys have in common
+func common_prefix_len(entries []trie_entry, depth int) int {
+	ret := 0
+	for {
+		i := depth + ret
+		for _, e := range entries {
+			if i >= len(e.key) || e.key[i] != entries[0].key[i] {
+				return ret
+			}
+		}
+		ret++
+	}
+}
+
+func to_nibbles(key []byte) []byte {
+	ret := make([]byte, 0, len(key)*2)
+	for _, b := range key {
+		ret = append(ret, b>>4, b&0x0f)
+	}
+	return ret
+}
+
+// Pack nibbles back into bytes, with a flag nibble in front that says whether it's a leaf and
+// whether there's an odd number of them
+func hex_prefix(nibbles []byte, is_leaf bool) []byte {
+	flag := byte(0)
+	if is_leaf {
+		flag = 2
+	}
+	if len(nibbles)%2 == 1 {
+		nibbles = append([]byte{flag + 1}, nibbles...)
+	} else {
+		nibbles = append([]byte{flag, 0}, nibbles...)
+	}
+	ret := make([]byte, len(nibbles)/2)
+	for i := range ret {
+		ret[i] = nibbles[2*i]<<4 | nibbles[2*i+1]
+	}
+	return ret
+}
+
+// Check every saved event that hasn't been verified yet against the receipts of its block.
+//
+// For each block, this hashes the block header, which has to come out to the hash the events were
+// saved with.  Then it rebuilds the receipts trie from the node's receipts, and checks that its root
+// matches the header's `receiptsRoot`.  Then each event has to be the log at its index in those
+// receipts, with the same contract, topics and data.  Events that pass get marked as verified; ones
+// that don't are left alone and returned.
+//
+// So nothing from the node is trusted except the block hash that was saved with the events, which
+// came from whichever node the logs were fetched from (use a QuorumSource then, or check the hashes
+// against somewhere else).
+//
+// WTF: the data of a Naive Batch event is the call data of its transaction (or of a call traced
+// inside it), not the log's (which is empty), and that ISN'T verified at all: only the log part is.
+// The call data would have to be checked against the header's `transactionsRoot`, which needs every
+// transaction in the block re-encoded, and calls made through other contracts aren't in any root.
+// Likewise, the transaction hash isn't in the receipts trie, so that's only checked against the
+// node's receipts.
+func VerifyEvents(ctx context.Context, source LogSource, db DB, opts Options) ([]VerificationFailure, error) {
+	defer opts.Meter.Save(db)
+	failures := []VerificationFailure{}
+	after_block := uint64(0)
+	done := uint64(0)
+	for {
+		if err := ctx.Err(); err != nil {
+			return failures, err
+		}
+		block_nums := db.GetBlocksWithUnverifiedEvents(after_block, VERIFY_BATCH_SIZE)
+		if len(block_nums) == 0 {
+			return failures, nil
+		}
+		headers, err := source.BlockHeaders(ctx, block_nums)
+		if err != nil {
+			return failures, fmt.Errorf("getting block headers: %w", err)
+		}
+		receipts, err := source.BlockReceipts(ctx, block_nums)
+		if err != nil {
+			return failures, fmt.Errorf("getting block receipts: %w", err)
+		}
+
+		verified_ids := []uint64{}
+		for i, n := range block_nums {
+			ids, block_failures := verify_block(headers[i], receipts[i], db.GetUnverifiedEventsInBlock(n))
+			verified_ids = append(verified_ids, ids...)
+			failures = append(failures, block_failures...)
+		}
+		db.MarkEventsVerified(verified_ids)
+
+		after_block = block_nums[len(block_nums)-1]
+		done += uint64(len(block_nums))
+		opts.Meter.Save(db)
+		ReportProgress(opts.Progress, Progress{
+			Task:         "Verifying events",
+			Done:         done,
+			CurrentBlock: after_block,
+			CreditsUsed:  opts.Meter.Used(),
+		})
+	}
+}
+
+// Check a block's events against its header and receipts.  Returns the IDs of the ones that passed,
+// and why the rest didn't.
+func verify_block(
+	header BlockHeader, receipts []Receipt, events []EthereumEventLog,
+) (verified_ids []uint64, failures []VerificationFailure) {
+	fail_all := func(reason string) ([]uint64, []VerificationFailure) {
+		for _, e := range events {
+			failures = append(failures, VerificationFailure{Event: e, Reason: reason})
+		}
+		return nil, failures
+	}
+	if header.Hash == (common.Hash{}) {
+		return fail_all("block not found")
+	}
+	if hash := header.ComputeHash(); hash != header.Hash {
+		return fail_all(fmt.Sprintf("block header hashes to %s, but the node says %s", hash.Hex(), header.Hash.Hex()))
+	}
+	if root := ReceiptsRoot(receipts); root != header.ReceiptsRoot {
+		return fail_all(fmt.Sprintf("receipts root is %s, but the header says %s", root.Hex(), header.ReceiptsRoot.Hex()))
+	}
+
+	// Number the logs by where they are in the block, rather than trusting the node's log indexes
+	sorted := slices.Clone(receipts)
+	slices.SortFunc(sorted, func(a, b Receipt) int {
+		return cmp.Compare(a.TransactionIndex, b.TransactionIndex)
+	})
+	type log_with_tx struct {
+		types.Log
+		TxHash common.Hash
+	}
+	logs := []log_with_tx{}
+	for _, r := range sorted {
+		for _, l := range r.Logs {
+			logs = append(logs, log_with_tx{l, r.TxHash})
+		}
+	}
+
+	for _, e := range events {
+		reason := ""
+		if e.BlockHash != header.Hash {
+			reason = fmt.Sprintf("block hash is %s, but it was saved as %s", header.Hash.Hex(), e.BlockHash.Hex())
+		} else if int(e.LogIndex) >= len(logs) {
+			reason = fmt.Sprintf("block only has %d logs", len(logs))
+		} else if l := logs[e.LogIndex]; l.TxHash != e.TxHash {
+			reason = fmt.Sprintf("log is from transaction %s", l.TxHash.Hex())
+		} else if l.Address != e.ContractAddress {
+			reason = fmt.Sprintf("log is from contract %s", l.Address.Hex())
+		} else if !slices.Equal(pad_topics(l.Topics), []common.Hash{e.Topic0, e.Topic1, e.Topic2, e.Topic3}) {
+			reason = "topics don't match"
+		} else if e.Topic0 != BATCH && !bytes.Equal(l.Data, e.Data) {
+			reason = "data doesn't match"
+		}
+		if reason == "" {
+			verified_ids = append(verified_ids, e.ID)
+		} else {
+			failures = append(failures, VerificationFailure{Event: e, Reason: reason})
+		}
+	}
+	return verified_ids, failures
+}
+
+// Topics that aren't there are saved as zeros
+func pad_topics(topics []common.Hash) []common.Hash {
+	ret := make([]common.Hash, 4)
+	copy(ret, topics)
+	return ret
+}
@@ -0,0 +1,270 @@
+package scraper_test
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "go-azimuth/pkg/db"
+	. "go-azimuth/pkg/scraper"
+)
+
+func TestReceiptsRoot(t *testing.T) {
+	assert := assert.New(t)
+
+	success := hexutil.Uint64(1)
+	failure := hexutil.Uint64(0)
+	activated := Receipt{Status: &success, CumulativeGasUsed: 21000, Logs: []types.Log{{
+		Address: common.HexToAddress("223c067f8cf28ae173ee5cafea60ca44c335fecb"),
+		Topics:  []common.Hash{ACTIVATED, common.HexToHash("05")},
+		Data:    []byte{},
+	}}}
+	failed := Receipt{Type: 2, Status: &failure, CumulativeGasUsed: 50000, TransactionIndex: 1}
+
+	// Checked against go-ethereum's `types.DeriveSha`
+	assert.Equal(types.EmptyReceiptsHash, ReceiptsRoot(nil))
+	assert.Equal(common.HexToHash("6264802dc4b8525d769035e058c1e13629c2bb3d8686f5c53666fafdac17f83e"),
+		ReceiptsRoot([]Receipt{activated}))
+	assert.Equal(common.HexToHash("b4f2d312db7d026281922eae2dff67c906f8db5ae14eb7cff6e956258b90a283"),
+		ReceiptsRoot([]Receipt{failed, activated})) // Order doesn't matter; it goes by transaction index
+}
+
+func TestBlockHeaderHash(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Mainnet's genesis block, as a node returns it
+	var genesis BlockHeader
+	require.NoError(json.Unmarshal([]byte(`{
+		"number": "0x0",
+		"hash": "0xd4e56740f876aef8c010b86a40d5f56745a118d0906a34e69aec8c0db1cb8fa3",
+		"parentHash": "0x0000000000000000000000000000000000000000000000000000000000000000",
+		"sha3Uncles": "0x1dcc4de8dec75d7aab85b567b6ccd41ad312451b948a7413f0a142fd40d49347",
+		"miner": "0x0000000000000000000000000000000000000000",
+		"stateRoot": "0xd7f8974fb5ac78d9ac099b9ad5018bedc2ce0a72dad1827a1709da30580f0544",
+		"transactionsRoot": "0x56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421",
+		"receiptsRoot": "0x56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421",
+		"logsBloom": "0x`+strings.Repeat("00", 256)+`",
+		"difficulty": "0x400000000",
+		"gasLimit": "0x1388",
+		"gasUsed": "0x0",
+		"timestamp": "0x0",
+		"extraData": "0x11bbe8db4e347b4e8c937c1c8370e4b5ed33adb3db69cbdb7a38e1e50b1b82fa",
+		"mixHash": "0x0000000000000000000000000000000000000000000000000000000000000000",
+		"nonce": "0x0000000000000042"
+	}`), &genesis))
+	assert.Equal(genesis.Hash, genesis.ComputeHash())
+
+	// With every fork's fields, checked against go-ethereum's `types.Header`
+	beacon_root := common.HexToHash("03")
+	requests_hash := common.HexToHash("04")
+	blob_gas_used := uint64(131072)
+	excess_blob_gas := uint64(0)
+	expected := types.Header{
+		ParentHash: common.HexToHash("01"), UncleHash: types.EmptyUncleHash, Coinbase: common.HexToAddress("02"),
+		Root: common.HexToHash("05"), TxHash: types.EmptyTxsHash, ReceiptHash: types.EmptyReceiptsHash,
+		Difficulty: big.NewInt(0), Number: big.NewInt(22431084), GasLimit: 36000000, GasUsed: 21000,
+		Time: 1746612311, Extra: []byte("some builder"), Nonce: types.BlockNonce{}, BaseFee: big.NewInt(1000000000),
+		WithdrawalsHash: &types.EmptyWithdrawalsHash, BlobGasUsed: &blob_gas_used, ExcessBlobGas: &excess_blob_gas,
+		ParentBeaconRoot: &beacon_root, RequestsHash: &requests_hash,
+	}
+	blob_gas_used_hex := hexutil.Uint64(blob_gas_used)
+	excess_blob_gas_hex := hexutil.Uint64(excess_blob_gas)
+	header := BlockHeader{
+		Number: 22431084, Timestamp: 1746612311, ReceiptsRoot: types.EmptyReceiptsHash,
+		ParentHash: common.HexToHash("01"), UncleHash: types.EmptyUncleHash, Miner: common.HexToAddress("02"),
+		StateRoot: common.HexToHash("05"), TransactionsRoot: types.EmptyTxsHash,
+		Difficulty: (*hexutil.Big)(big.NewInt(0)), GasLimit: 36000000, GasUsed: 21000,
+		ExtraData: []byte("some builder"), BaseFee: (*hexutil.Big)(big.NewInt(1000000000)),
+		WithdrawalsRoot: &types.EmptyWithdrawalsHash, BlobGasUsed: &blob_gas_used_hex,
+		ExcessBlobGas: &excess_blob_gas_hex, ParentBeaconBlockRoot: &beacon_root, RequestsHash: &requests_hash,
+	}
+	assert.Equal(expected.Hash(), header.ComputeHash())
+
+	// Before Prague
+	expected.RequestsHash = nil
+	header.RequestsHash = nil
+	assert.Equal(expected.Hash(), header.ComputeHash())
+}
+
+// A LogSource with receipts made up from the logs in the file (padded out so the log indexes line
+// up), and made-up headers with the matching receipts root.  The logs' block hashes are replaced
+// with the made-up headers' hashes, so it all hangs together like a real chain.
+type receipts_source struct {
+	FileLogSource
+	headers map[uint64]BlockHeader
+}
+
+func new_receipts_source(file_source FileLogSource) receipts_source {
+	ret := receipts_source{file_source, map[uint64]BlockHeader{}}
+	block_nums := []uint64{}
+	for n := range file_source.BlockHashes {
+		block_nums = append(block_nums, n)
+	}
+	slices.Sort(block_nums)
+	receipts, err := ret.BlockReceipts(context.Background(), block_nums)
+	if err != nil {
+		panic(err)
+	}
+	parent_hash := common.Hash{}
+	for i, n := range block_nums {
+		header := BlockHeader{
+			Number: hexutil.Uint64(n), Timestamp: hexutil.Uint64(1600000000 + n), ReceiptsRoot: ReceiptsRoot(receipts[i]),
+			ParentHash: parent_hash, UncleHash: types.EmptyUncleHash, TransactionsRoot: types.EmptyTxsHash,
+			Difficulty: (*hexutil.Big)(big.NewInt(0)), GasLimit: 30000000,
+			BaseFee: (*hexutil.Big)(big.NewInt(1000000000)),
+		}
+		header.Hash = header.ComputeHash()
+		ret.headers[n] = header
+		ret.BlockHashes[n] = header.Hash
+		parent_hash = header.Hash
+	}
+	for i := range ret.Logs {
+		ret.Logs[i].BlockHash = ret.BlockHashes[ret.Logs[i].BlockNumber]
+	}
+	return ret
+}
+
+func (s receipts_source) BlockReceipts(ctx context.Context, block_nums []uint64) ([][]Receipt, error) {
+	success := hexutil.Uint64(1)
+	ret := [][]Receipt{}
+	for _, n := range block_nums {
+		receipts := []Receipt{}
+		num_logs := uint(0)
+		for _, l := range s.Logs {
+			if l.BlockNumber != n {
+				continue
+			}
+			if l.Index > num_logs {
+				filler := Receipt{Status: &success, TxHash: common.HexToHash("ff")}
+				for ; num_logs < l.Index; num_logs++ {
+					filler.Logs = append(filler.Logs, types.Log{Address: common.HexToAddress("ff")})
+				}
+				receipts = append(receipts, filler)
+			}
+			if len(receipts) == 0 || receipts[len(receipts)-1].TxHash != l.TxHash {
+				receipts = append(receipts, Receipt{Status: &success, TxHash: l.TxHash})
+			}
+			receipts[len(receipts)-1].Logs = append(receipts[len(receipts)-1].Logs, l)
+			num_logs++
+		}
+		for i := range receipts {
+			receipts[i].TransactionIndex = hexutil.Uint64(i)
+		}
+		ret = append(ret, receipts)
+	}
+	return ret, nil
+}
+
+func (s receipts_source) BlockHeaders(ctx context.Context, block_nums []uint64) ([]BlockHeader, error) {
+	ret := []BlockHeader{}
+	for _, n := range block_nums {
+		ret = append(ret, s.headers[n])
+	}
+	return ret, nil
+}
+
+func TestVerifyEvents(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	file_source, err := NewFileLogSource("testdata/logs.ndjson")
+	require.NoError(err)
+	source := new_receipts_source(file_source)
+	db, err := DBCreate(":memory:")
+	require.NoError(err)
+	db.SetLatestContractBlockFetched(db.GetContractByName("Azimuth").ID, 13369000)
+
+	ctx := context.Background()
+	require.NoError(CatchUpAzimuthLogs(ctx, source, db, Options{}))
+	require.NoError(CatchUpNaiveLogs(ctx, source, db, Options{}))
+
+	// Tamper with one of them
+	db.DB.MustExec(`update ethereum_events set topic2 = ? where topic0 = ?`, common.HexToHash("bad"), OWNER_CHANGED)
+
+	failures, err := VerifyEvents(ctx, source, db, Options{})
+	require.NoError(err)
+	require.Len(failures, 1)
+	assert.Equal(OWNER_CHANGED, failures[0].Event.Topic0)
+	assert.Equal("topics don't match", failures[0].Reason)
+
+	// Everything else passed, including the Batch (whose data is its transaction's call data)
+	verified, total := db.CountVerifiedEvents()
+	assert.Equal(uint64(2), verified)
+	assert.Equal(uint64(3), total)
+
+	// Receipts that don't match the header's root aren't trusted at all
+	db.DB.MustExec(`update ethereum_events set topic2 = ? where topic0 = ?`,
+		common.HexToHash("671738dada5c209c12b6501e80c62e091c27b14a"), OWNER_CHANGED)
+	failures, err = VerifyEvents(ctx, lying_receipts_source{source, false, false}, db, Options{})
+	require.NoError(err)
+	require.Len(failures, 1)
+	assert.Contains(failures[0].Reason, "receipts root is")
+
+	// Nor is a header with the right receipts root for them, but the wrong hash for its fields
+	failures, err = VerifyEvents(ctx, lying_receipts_source{source, true, false}, db, Options{})
+	require.NoError(err)
+	require.Len(failures, 1)
+	assert.Contains(failures[0].Reason, "block header hashes to")
+
+	// Nor is a whole made-up block, since its hash isn't the one the logs were saved with
+	failures, err = VerifyEvents(ctx, lying_receipts_source{source, true, true}, db, Options{})
+	require.NoError(err)
+	require.Len(failures, 1)
+	assert.Contains(failures[0].Reason, "but it was saved as")
+
+	// Fixed, it passes
+	failures, err = VerifyEvents(ctx, source, db, Options{})
+	require.NoError(err)
+	assert.Len(failures, 0)
+	verified, _ = db.CountVerifiedEvents()
+	assert.Equal(uint64(3), verified)
+}
+
+// Adds a log to every block's receipts.  It can also put the new receipts root in the headers, and
+// give them a new hash to match.
+type lying_receipts_source struct {
+	receipts_source
+	is_fixing_root bool
+	is_fixing_hash bool
+}
+
+func (s lying_receipts_source) BlockReceipts(ctx context.Context, block_nums []uint64) ([][]Receipt, error) {
+	ret, err := s.receipts_source.BlockReceipts(ctx, block_nums)
+	if err != nil {
+		return nil, err
+	}
+	for i := range ret {
+		ret[i][0].Logs = append(ret[i][0].Logs, types.Log{Address: common.HexToAddress("ff")})
+	}
+	return ret, nil
+}
+
+func (s lying_receipts_source) BlockHeaders(ctx context.Context, block_nums []uint64) ([]BlockHeader, error) {
+	ret, err := s.receipts_source.BlockHeaders(ctx, block_nums)
+	if err != nil {
+		return nil, err
+	}
+	receipts, err := s.BlockReceipts(ctx, block_nums)
+	if err != nil {
+		return nil, err
+	}
+	for i := range ret {
+		if s.is_fixing_root {
+			ret[i].ReceiptsRoot = ReceiptsRoot(receipts[i])
+		}
+		if s.is_fixing_hash {
+			ret[i].Hash = ret[i].ComputeHash()
+		}
+	}
+	return ret, nil
+}